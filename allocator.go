@@ -0,0 +1,351 @@
+package sharding
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Allocator computes the desired shards of every node.
+// It is only called on the leader, every time the list of active nodes or the current assignments changed.
+type Allocator interface {
+	// Allocate returns the desired list of shards for each of input.Nodes.
+	// Every shard in [0, NumShards) that is not in input.Reserved should be assigned to exactly one node,
+	// and every shard in input.Pinned must be assigned to its pinned node.
+	// A result with unknown nodes, out of range shards, shards assigned to more than one node,
+	// reserved shards or misplaced pinned shards is reported to the error handler with OpAllocate.
+	Allocate(input AllocateInput) map[string][]ShardID
+}
+
+// AllocateNode is an active node in the input of Allocator
+type AllocateNode struct {
+	ID string
+
+	// Weight is the capacity of the node, always >= 1
+	Weight uint32
+
+	// Topology is empty if the node is not configured with WithNodeTopology
+	Topology Topology
+}
+
+// AllocateInput is the input of Allocator
+type AllocateInput struct {
+	// Nodes is the list of active nodes that are not draining, sorted by node id.
+	// If all nodes are draining, it contains all of them. It is empty only when there are no active nodes,
+	// then the result should also be empty.
+	Nodes []AllocateNode
+
+	// Current is the current shards of each node, it can contain nodes that are no longer active
+	Current map[string][]ShardID
+
+	NumShards ShardID
+
+	// Reserved is the list of shards that must NOT be allocated to any node,
+	// e.g. shards of nodes that are in their grace period
+	Reserved []ShardID
+
+	// Pinned maps the shards pinned by PinShard to their nodes, only contains nodes in the list input.Nodes
+	Pinned map[ShardID]string
+
+	// Loads is the latest reported load of each shard, only with WithLoadReporting.
+	// Shards that are not reported yet are missing.
+	Loads map[ShardID]ShardLoad
+
+	// ShardWeights is the static weights of shards configured by WithShardWeights,
+	// shards that are missing have weight 1
+	ShardWeights map[ShardID]uint32
+}
+
+// validateAllocation checks that the result of an Allocator only contains active nodes,
+// shards in [0, NumShards), every shard at most once, no reserved shards and the pinned shards on their nodes
+func validateAllocation(input AllocateInput, result map[string][]ShardID) error {
+	owners, err := validateAllocatedShards(input, result)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range input.Reserved {
+		if nodeID, ok := owners[id]; ok {
+			return fmt.Errorf("%w: reserved shard %d is assigned to %s", ErrInvalidAllocation, id, nodeID)
+		}
+	}
+	for _, id := range getKeys(input.Pinned) {
+		if owners[id] != input.Pinned[id] {
+			return fmt.Errorf(
+				"%w: shard %d is NOT assigned to its pinned node %s", ErrInvalidAllocation, id, input.Pinned[id],
+			)
+		}
+	}
+	return nil
+}
+
+// validateAllocatedShards returns the node of each allocated shard
+func validateAllocatedShards(input AllocateInput, result map[string][]ShardID) (map[ShardID]string, error) {
+	owners := map[ShardID]string{}
+	for _, nodeID := range getKeys(result) {
+		_, found := slices.BinarySearchFunc(input.Nodes, nodeID, func(n AllocateNode, id string) int {
+			return cmp.Compare(n.ID, id)
+		})
+		if !found {
+			return nil, fmt.Errorf("%w: unknown node %s", ErrInvalidAllocation, nodeID)
+		}
+
+		for _, id := range result[nodeID] {
+			if id >= input.NumShards {
+				return nil, fmt.Errorf("%w: shard %d is out of range on node %s", ErrInvalidAllocation, id, nodeID)
+			}
+			if prev, ok := owners[id]; ok {
+				return nil, fmt.Errorf(
+					"%w: shard %d is assigned to both %s and %s", ErrInvalidAllocation, id, prev, nodeID,
+				)
+			}
+			owners[id] = nodeID
+		}
+	}
+	return owners, nil
+}
+
+// NewDefaultAllocator returns the default allocator.
+// It splits shards between zones proportionally to the total weights of their nodes,
+// then splits shards of each zone between its nodes proportionally to their weights.
+// It keeps the current shards of nodes as much as possible and allocates the free shards with the lowest ids first.
+// Pinned shards are counted in the quotas of their nodes, a node with more pinned shards than its quota
+// only gets its pinned shards.
+// With input.ShardWeights, it balances the summed weights of shards instead of their numbers, ignoring zones.
+func NewDefaultAllocator() Allocator {
+	return &defaultAllocator{}
+}
+
+type defaultAllocator struct {
+}
+
+func (*defaultAllocator) Allocate(input AllocateInput) map[string][]ShardID {
+	if len(input.Nodes) == 0 {
+		// all shards are unassigned
+		return map[string][]ShardID{}
+	}
+	if len(input.ShardWeights) > 0 {
+		return balanceByCosts(input, computeShardWeightCosts(input), 0)
+	}
+
+	allocated := map[ShardID]struct{}{}
+	for _, id := range input.Reserved {
+		allocated[id] = struct{}{}
+	}
+	numShards := input.NumShards - ShardID(len(allocated))
+
+	pinnedShards := map[string][]ShardID{}
+	for _, id := range getKeys(input.Pinned) {
+		allocated[id] = struct{}{}
+		nodeID := input.Pinned[id]
+		pinnedShards[nodeID] = append(pinnedShards[nodeID], id)
+	}
+
+	nodes := sortNodesByNumShards(input.Nodes, input.Current)
+	quotas := computePinnedQuotas(nodes, numShards, pinnedShards)
+	result := make(map[string][]ShardID, len(nodes))
+
+	for i, node := range nodes {
+		pinned := pinnedShards[node.ID]
+		shards := allocateNodeShards(
+			input.Current[node.ID], int(quotas[i])-len(pinned), allocated, input.NumShards,
+		)
+		if len(pinned) > 0 {
+			shards = append(shards, pinned...)
+			slices.Sort(shards)
+		}
+		result[node.ID] = shards
+	}
+
+	return result
+}
+
+// computePinnedQuotas is computeZoneQuotas with the pinned shards counted in the quotas of their nodes.
+// Nodes whose quotas are less than their numbers of pinned shards are fixed to these numbers,
+// and the remaining shards are split again between the other nodes.
+func computePinnedQuotas(nodes []AllocateNode, numShards ShardID, pinned map[string][]ShardID) []ShardID {
+	if len(pinned) == 0 {
+		return computeZoneQuotas(nodes, numShards)
+	}
+
+	fixed := make([]bool, len(nodes))
+	for {
+		remaining := numShards
+		var freeIndices []int
+		var freeNodes []AllocateNode
+		for i, n := range nodes {
+			if fixed[i] {
+				remaining -= ShardID(len(pinned[n.ID]))
+				continue
+			}
+			freeIndices = append(freeIndices, i)
+			freeNodes = append(freeNodes, n)
+		}
+
+		freeQuotas := computeZoneQuotas(freeNodes, remaining)
+
+		changed := false
+		for k, index := range freeIndices {
+			if int(freeQuotas[k]) < len(pinned[nodes[index].ID]) {
+				fixed[index] = true
+				changed = true
+			}
+		}
+		if changed {
+			continue
+		}
+
+		quotas := make([]ShardID, len(nodes))
+		for i, n := range nodes {
+			quotas[i] = ShardID(len(pinned[n.ID]))
+		}
+		for k, index := range freeIndices {
+			quotas[index] = freeQuotas[k]
+		}
+		return quotas
+	}
+}
+
+// sortNodesByNumShards sorts nodes by the number of current shards, in descending order
+func sortNodesByNumShards(nodes []AllocateNode, current map[string][]ShardID) []AllocateNode {
+	nodes = slices.Clone(nodes)
+	slices.SortStableFunc(nodes, func(a, b AllocateNode) int {
+		return len(current[b.ID]) - len(current[a.ID])
+	})
+	return nodes
+}
+
+// computeZoneQuotas splits numShards between zones proportionally to the total weights of their nodes,
+// and then between nodes of each zone. Zones are ordered by their first appearances in the list.
+func computeZoneQuotas(nodes []AllocateNode, numShards ShardID) []ShardID {
+	var zones []string
+	zoneNodes := map[string][]int{}
+	for i, n := range nodes {
+		zone := n.Topology.Zone
+		if _, existed := zoneNodes[zone]; !existed {
+			zones = append(zones, zone)
+		}
+		zoneNodes[zone] = append(zoneNodes[zone], i)
+	}
+
+	if len(zones) <= 1 {
+		return computeNodeQuotas(nodes, numShards)
+	}
+
+	zoneWeights := make([]uint64, len(zones))
+	for i, zone := range zones {
+		for _, index := range zoneNodes[zone] {
+			zoneWeights[i] += uint64(nodes[index].Weight)
+		}
+	}
+	zoneQuotas := computeQuotas(zoneWeights, numShards)
+
+	quotas := make([]ShardID, len(nodes))
+	for i, zone := range zones {
+		indices := zoneNodes[zone]
+
+		weights := make([]uint64, 0, len(indices))
+		for _, index := range indices {
+			weights = append(weights, uint64(nodes[index].Weight))
+		}
+
+		for k, q := range computeQuotas(weights, zoneQuotas[i]) {
+			quotas[indices[k]] = q
+		}
+	}
+	return quotas
+}
+
+// computeNodeQuotas splits numShards proportionally to the weights of nodes, using the largest remainder method.
+// Between nodes with the same remainder, the nodes appear first in the list get the extra shards first.
+func computeNodeQuotas(nodes []AllocateNode, numShards ShardID) []ShardID {
+	weights := make([]uint64, 0, len(nodes))
+	for _, n := range nodes {
+		weights = append(weights, uint64(n.Weight))
+	}
+	return computeQuotas(weights, numShards)
+}
+
+func computeQuotas(weights []uint64, numShards ShardID) []ShardID {
+	var totalWeight uint64
+	for _, w := range weights {
+		totalWeight += w
+	}
+
+	quotas := make([]ShardID, len(weights))
+	remainders := make([]uint64, len(weights))
+
+	var numAllocated ShardID
+	for i, w := range weights {
+		value := uint64(numShards) * w
+		quotas[i] = ShardID(value / totalWeight)
+		remainders[i] = value % totalWeight
+		numAllocated += quotas[i]
+	}
+
+	indices := make([]int, len(weights))
+	for i := range indices {
+		indices[i] = i
+	}
+	slices.SortStableFunc(indices, func(a, b int) int {
+		return cmp.Compare(remainders[b], remainders[a])
+	})
+
+	for _, index := range indices[:numShards-numAllocated] {
+		quotas[index]++
+	}
+	return quotas
+}
+
+func allocateNodeShards(
+	oldShards []ShardID, expectLen int,
+	allocatedShards map[ShardID]struct{},
+	numShards ShardID,
+) []ShardID {
+	current := make([]ShardID, 0, len(oldShards))
+	for _, id := range oldShards {
+		_, existed := allocatedShards[id]
+		if existed {
+			continue
+		}
+		current = append(current, id)
+	}
+	slices.Sort(current)
+
+	addToAllocated := func(curr []ShardID) {
+		for _, id := range curr {
+			allocatedShards[id] = struct{}{}
+		}
+	}
+
+	if len(current) > expectLen {
+		current = slices.Clone(current[:expectLen])
+		addToAllocated(current)
+		return current
+	}
+
+	if len(current) < expectLen {
+		addToAllocated(current)
+
+		list := computeFreeShards(allocatedShards, numShards)
+		missing := expectLen - len(current)
+
+		addToAllocated(list[:missing])
+		return slices.Clone(append(current, list[:missing]...))
+	}
+
+	addToAllocated(current)
+	return current
+}
+
+func computeFreeShards(allocated map[ShardID]struct{}, numShards ShardID) []ShardID {
+	var list []ShardID
+	for id := ShardID(0); id < numShards; id++ {
+		_, ok := allocated[id]
+		if ok {
+			continue
+		}
+		list = append(list, id)
+	}
+	return list
+}
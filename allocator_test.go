@@ -0,0 +1,545 @@
+package sharding
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAllocateNodes(ids ...string) []AllocateNode {
+	nodes := make([]AllocateNode, 0, len(ids))
+	for _, id := range ids {
+		nodes = append(nodes, AllocateNode{ID: id, Weight: 1})
+	}
+	return nodes
+}
+
+func newZoneAllocateNodes(zones map[string]string) []AllocateNode {
+	nodes := newAllocateNodes(getKeys(zones)...)
+	for i := range nodes {
+		nodes[i].Topology.Zone = zones[nodes[i].ID]
+	}
+	return nodes
+}
+
+func TestDefaultAllocator(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes:     newAllocateNodes("node01", "node02", "node03"),
+			Current:   map[string][]ShardID{},
+			NumShards: 8,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1, 2},
+			"node02": {3, 4, 5},
+			"node03": {6, 7},
+		}, result)
+	})
+
+	t.Run("keep current shards", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: newAllocateNodes("node02", "node03"),
+			Current: map[string][]ShardID{
+				"node01": {0, 1, 2},
+				"node02": {3, 4, 5},
+				"node03": {6, 7},
+			},
+			NumShards: 8,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node02": {3, 4, 5, 0},
+			"node03": {6, 7, 1, 2},
+		}, result)
+	})
+
+	t.Run("remove duplicated and exceeded shards", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: newAllocateNodes("node01", "node02"),
+			Current: map[string][]ShardID{
+				"node01": {0, 1, 2, 3, 4, 5},
+				"node02": {5, 6},
+			},
+			NumShards: 8,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1, 2, 3},
+			"node02": {5, 6, 4, 7},
+		}, result)
+	})
+
+	t.Run("with reserved shards", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: newAllocateNodes("node02", "node03"),
+			Current: map[string][]ShardID{
+				"node01": {0, 1, 2},
+				"node02": {3, 4, 5},
+				"node03": {6, 7},
+			},
+			NumShards: 8,
+			Reserved:  []ShardID{0, 1, 2},
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node02": {3, 4, 5},
+			"node03": {6, 7},
+		}, result)
+	})
+
+	t.Run("no nodes", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Current: map[string][]ShardID{
+				"node01": {0, 1, 2},
+			},
+			NumShards:    8,
+			ShardWeights: map[ShardID]uint32{0: 2},
+		})
+		assert.Equal(t, map[string][]ShardID{}, result)
+	})
+}
+
+func TestDefaultAllocator_With_Weights(t *testing.T) {
+	t.Run("proportional to weights", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: []AllocateNode{
+				{ID: "node01", Weight: 1},
+				{ID: "node02", Weight: 3},
+			},
+			Current:   map[string][]ShardID{},
+			NumShards: 8,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1},
+			"node02": {2, 3, 4, 5, 6, 7},
+		}, result)
+	})
+
+	t.Run("largest remainder", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: []AllocateNode{
+				{ID: "node01", Weight: 1},
+				{ID: "node02", Weight: 2},
+				{ID: "node03", Weight: 2},
+			},
+			Current:   map[string][]ShardID{},
+			NumShards: 8,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1},
+			"node02": {2, 3, 4},
+			"node03": {5, 6, 7},
+		}, result)
+	})
+
+	t.Run("weight increased", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: []AllocateNode{
+				{ID: "node01", Weight: 1},
+				{ID: "node02", Weight: 3},
+			},
+			Current: map[string][]ShardID{
+				"node01": {0, 1, 2, 3},
+				"node02": {4, 5, 6, 7},
+			},
+			NumShards: 8,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1},
+			"node02": {4, 5, 6, 7, 2, 3},
+		}, result)
+	})
+}
+
+func TestDefaultAllocator_With_Zones(t *testing.T) {
+	t.Run("balance between zones", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: newZoneAllocateNodes(map[string]string{
+				"node01": "zone-a",
+				"node02": "zone-a",
+				"node03": "zone-b",
+			}),
+			Current:   map[string][]ShardID{},
+			NumShards: 8,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1, 2},
+			"node02": {3, 4},
+			"node03": {5, 6, 7},
+		}, result)
+	})
+
+	t.Run("move shards to the smaller zone", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: newZoneAllocateNodes(map[string]string{
+				"node01": "zone-a",
+				"node02": "zone-b",
+				"node03": "zone-b",
+			}),
+			Current: map[string][]ShardID{
+				"node01": {0, 1},
+				"node02": {2, 3, 4},
+				"node03": {5, 6, 7},
+			},
+			NumShards: 8,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1, 7},
+			"node02": {2, 3, 4},
+			"node03": {5, 6},
+		}, result)
+	})
+}
+
+func TestDefaultAllocator_With_Pinned_Shards(t *testing.T) {
+	t.Run("pinned shards counted in quotas", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: newAllocateNodes("node01", "node02"),
+			Current: map[string][]ShardID{
+				"node01": {0, 1, 2, 3},
+				"node02": {4, 5, 6, 7},
+			},
+			NumShards: 8,
+			Pinned: map[ShardID]string{
+				5: "node01",
+			},
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1, 2, 5},
+			"node02": {4, 6, 7, 3},
+		}, result)
+	})
+
+	t.Run("more pinned shards than quota", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes:     newAllocateNodes("node01", "node02", "node03"),
+			Current:   map[string][]ShardID{},
+			NumShards: 8,
+			Pinned: map[ShardID]string{
+				0: "node01",
+				1: "node01",
+				2: "node01",
+				3: "node01",
+				4: "node01",
+			},
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1, 2, 3, 4},
+			"node02": {5, 6},
+			"node03": {7},
+		}, result)
+	})
+
+	t.Run("with reserved shards", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes:     newAllocateNodes("node01", "node02"),
+			Current:   map[string][]ShardID{},
+			NumShards: 8,
+			Reserved:  []ShardID{0, 1},
+			Pinned: map[ShardID]string{
+				7: "node01",
+			},
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {2, 3, 7},
+			"node02": {4, 5, 6},
+		}, result)
+	})
+}
+
+func TestDefaultAllocator_With_Shard_Weights(t *testing.T) {
+	t.Run("balance summed weights", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes:     newAllocateNodes("node01", "node02"),
+			Current:   map[string][]ShardID{},
+			NumShards: 8,
+			ShardWeights: map[ShardID]uint32{
+				0: 6,
+			},
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 7},
+			"node02": {1, 2, 3, 4, 5, 6},
+		}, result)
+	})
+
+	t.Run("keep current shards", func(t *testing.T) {
+		result := NewDefaultAllocator().Allocate(AllocateInput{
+			Nodes: newAllocateNodes("node01", "node02"),
+			Current: map[string][]ShardID{
+				"node01": {0, 1, 2, 3},
+				"node02": {4, 5, 6, 7},
+			},
+			NumShards: 8,
+			ShardWeights: map[ShardID]uint32{
+				0: 3,
+				1: 3,
+			},
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {1, 2, 3, 4},
+			"node02": {0, 5, 6, 7},
+		}, result)
+	})
+}
+
+func cpuLoads(costs map[ShardID]float64) map[ShardID]ShardLoad {
+	result := map[ShardID]ShardLoad{}
+	for id, cost := range costs {
+		result[id] = ShardLoad{CPU: cost}
+	}
+	return result
+}
+
+func cpuCost(load ShardLoad) float64 {
+	return load.CPU
+}
+
+func TestLoadAwareAllocator(t *testing.T) {
+	t.Run("without loads", func(t *testing.T) {
+		result := NewLoadAwareAllocator(cpuCost, 0.1).Allocate(AllocateInput{
+			Nodes:     newAllocateNodes("node01", "node02", "node03"),
+			Current:   map[string][]ShardID{},
+			NumShards: 8,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1, 2},
+			"node02": {3, 4, 5},
+			"node03": {6, 7},
+		}, result)
+	})
+
+	t.Run("separate hot shards", func(t *testing.T) {
+		result := NewLoadAwareAllocator(cpuCost, 0.1).Allocate(AllocateInput{
+			Nodes: newAllocateNodes("node01", "node02"),
+			Current: map[string][]ShardID{
+				"node01": {0, 1, 2, 3},
+				"node02": {4, 5, 6, 7},
+			},
+			NumShards: 8,
+			Loads: cpuLoads(map[ShardID]float64{
+				0: 20, 1: 20, 2: 1, 3: 1,
+				4: 1, 5: 1, 6: 1, 7: 1,
+			}),
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {1, 2, 3},
+			"node02": {0, 4, 5, 6, 7},
+		}, result)
+	})
+
+	t.Run("within tolerance", func(t *testing.T) {
+		result := NewLoadAwareAllocator(cpuCost, 0.1).Allocate(AllocateInput{
+			Nodes: newAllocateNodes("node01", "node02"),
+			Current: map[string][]ShardID{
+				"node01": {0, 1, 2, 3},
+				"node02": {4, 5, 6, 7},
+			},
+			NumShards: 8,
+			Loads: cpuLoads(map[ShardID]float64{
+				0: 11, 1: 10, 2: 10, 3: 10,
+				4: 10, 5: 10, 6: 10, 7: 10,
+			}),
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1, 2, 3},
+			"node02": {4, 5, 6, 7},
+		}, result)
+	})
+
+	t.Run("free shards to least loaded nodes", func(t *testing.T) {
+		result := NewLoadAwareAllocator(cpuCost, 0).Allocate(AllocateInput{
+			Nodes: newAllocateNodes("node01", "node02"),
+			Current: map[string][]ShardID{
+				"node02": {0, 1, 2, 3},
+			},
+			NumShards: 8,
+			Loads: cpuLoads(map[ShardID]float64{
+				0: 1, 1: 1, 2: 1, 3: 1,
+				4: 8, 5: 2, 6: 2,
+			}),
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {4, 6},
+			"node02": {0, 1, 2, 3, 5, 7},
+		}, result)
+	})
+
+	t.Run("with weights and pinned shards", func(t *testing.T) {
+		result := NewLoadAwareAllocator(cpuCost, 0).Allocate(AllocateInput{
+			Nodes: []AllocateNode{
+				{ID: "node01", Weight: 1},
+				{ID: "node02", Weight: 3},
+			},
+			Current:   map[string][]ShardID{},
+			NumShards: 4,
+			Pinned: map[ShardID]string{
+				0: "node01",
+				1: "node01",
+			},
+			Loads: cpuLoads(map[ShardID]float64{
+				0: 5, 1: 5, 2: 1, 3: 1,
+			}),
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {0, 1},
+			"node02": {2, 3},
+		}, result)
+	})
+}
+
+type reverseAllocator struct {
+}
+
+func (*reverseAllocator) Allocate(input AllocateInput) map[string][]ShardID {
+	result := map[string][]ShardID{}
+	for id := ShardID(0); id < input.NumShards; id++ {
+		nodeID := input.Nodes[len(input.Nodes)-1-int(id)%len(input.Nodes)].ID
+		result[nodeID] = append(result[nodeID], id)
+	}
+	return result
+}
+
+func TestSharding_With_Custom_Allocator(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithAllocator(&reverseAllocator{}))
+	startSharding(store, client2, "node02", WithAllocator(&reverseAllocator{}))
+
+	store.Begin(client1)
+	store.Begin(client2)
+
+	initContainerNodes(store, client1)
+	initContainerNodes(store, client2)
+
+	lockGranted(store, client1)
+	lockBlocked(store, client2)
+
+	store.ChildrenApply(client1)
+	store.ChildrenApply(client1)
+
+	assert.Equal(t, []string{"get-w", "get-w"}, store.PendingCalls(client1))
+	store.GetApply(client1)
+	store.GetApply(client1)
+
+	store.CreateApply(client1)
+	store.CreateApply(client1)
+	assignsListed(store, client1)
+
+	assert.Equal(t, []string{}, store.PendingCalls(client1))
+
+	children := store.Root.Children[0].Children[2].Children
+	assert.Equal(t, 2, len(children))
+
+	assert.Equal(t, "node01", children[0].Name)
+	assert.Equal(t, `{"shards":[1,3,5,7]}`, string(children[0].Data))
+
+	assert.Equal(t, "node02", children[1].Name)
+	assert.Equal(t, `{"shards":[0,2,4,6]}`, string(children[1].Data))
+}
+
+func TestValidateAllocation(t *testing.T) {
+	input := AllocateInput{
+		Nodes:     []AllocateNode{{ID: "node01", Weight: 1}, {ID: "node02", Weight: 1}},
+		NumShards: 4,
+	}
+
+	assert.Equal(t, nil, validateAllocation(input, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {2},
+	}))
+
+	err := validateAllocation(input, map[string][]ShardID{
+		"node01": {0, 1},
+		"node03": {2, 3},
+	})
+	assert.True(t, errors.Is(err, ErrInvalidAllocation))
+	assert.Equal(t, "sharding: invalid allocation: unknown node node03", err.Error())
+
+	err = validateAllocation(input, map[string][]ShardID{
+		"node01": {0, 4},
+	})
+	assert.Equal(t, "sharding: invalid allocation: shard 4 is out of range on node node01", err.Error())
+
+	err = validateAllocation(input, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {1, 2},
+	})
+	assert.Equal(t, "sharding: invalid allocation: shard 1 is assigned to both node01 and node02", err.Error())
+
+	input.Reserved = []ShardID{3}
+	err = validateAllocation(input, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {2, 3},
+	})
+	assert.Equal(t, "sharding: invalid allocation: reserved shard 3 is assigned to node02", err.Error())
+
+	input.Pinned = map[ShardID]string{1: "node02"}
+	err = validateAllocation(input, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {2},
+	})
+	assert.Equal(t, "sharding: invalid allocation: shard 1 is NOT assigned to its pinned node node02", err.Error())
+
+	assert.Equal(t, nil, validateAllocation(input, map[string][]ShardID{
+		"node01": {0},
+		"node02": {1, 2},
+	}))
+}
+
+type duplicateAllocator struct {
+}
+
+func (*duplicateAllocator) Allocate(input AllocateInput) map[string][]ShardID {
+	result := map[string][]ShardID{}
+	for _, node := range input.Nodes {
+		for id := ShardID(0); id < input.NumShards; id++ {
+			result[node.ID] = append(result[node.ID], id)
+		}
+	}
+	return result
+}
+
+func TestSharding_With_Invalid_Allocator(t *testing.T) {
+	store := initStore()
+
+	var errs []*UnexpectedError
+	options := []Option{
+		WithLogger(&noopLogger{}),
+		WithAllocator(&duplicateAllocator{}),
+		WithErrorHandler(func(err *UnexpectedError) ErrorAction {
+			errs = append(errs, err)
+			return ErrorActionSkip
+		}, time.Second),
+	}
+	startSharding(store, client1, "node01", options...)
+	startSharding(store, client2, "node02", options...)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	// falls back to the default allocator
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	assert.Greater(t, len(errs), 0)
+	assert.Equal(t, OpAllocate, errs[0].Op)
+	assert.Equal(t, "/sharding/assigns", errs[0].Path)
+	assert.True(t, errors.Is(errs[0], ErrInvalidAllocation))
+}
+
+func TestSharding_With_Invalid_Allocator__Default_Panic(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithAllocator(&duplicateAllocator{}))
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithAllocator(&duplicateAllocator{}))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	assert.Panics(t, func() {
+		runTesterWithoutErrors(tester)
+	})
+}
@@ -0,0 +1,163 @@
+package sharding
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+)
+
+func (s *Sharding) newConfigData() configData {
+	twoPhaseHandoff := s.twoPhaseHandoff
+	return configData{
+		NumShards:         s.numShards,
+		ReplicationFactor: s.replicationFactor,
+		TwoPhaseHandoff:   &twoPhaseHandoff,
+		Settings:          s.newConfigSettings(),
+	}
+}
+
+func (s *Sharding) newConfigSettings() *configSettings {
+	settings := &configSettings{
+		Allocator:            fmt.Sprintf("%T", s.allocator),
+		MaxMovesPerRound:     s.maxMovesPerRound,
+		MinRebalanceInterval: s.minRebalanceInterval,
+		NodeGracePeriod:      s.nodeGracePeriod,
+
+		ShardPinning:     s.shardPinning,
+		NodeDraining:     s.nodeDraining,
+		DynamicNumShards: s.dynamicNumShards,
+		ShardStatus:      s.shardStatus,
+		LoadReporting:    s.loadReportFunc != nil,
+		FencingEpochs:    s.fencingEpochs,
+	}
+	if len(s.shardWeights) > 0 {
+		settings.ShardWeights = maps.Clone(s.shardWeights)
+	}
+	for _, g := range s.groupConfigs {
+		if settings.ShardGroups == nil {
+			settings.ShardGroups = map[string]ShardID{}
+		}
+		settings.ShardGroups[g.name] = g.numShards
+	}
+	return settings
+}
+
+// setupConfigController makes the controller create, validate and watch the config znode
+func (s *Sharding) setupConfigController(controller *containerNodeController) {
+	controller.config = s.newConfigData().marshalJSON()
+	controller.checkConfig = s.checkConfig
+	controller.completeConfig = s.completeConfig
+}
+
+func newConfigMismatchError(field string, stored any, local any) error {
+	return fmt.Errorf(
+		"%w: %s is %v in zookeeper, but %v in the current process",
+		ErrConfigMismatch, field, stored, local,
+	)
+}
+
+// validateConfig compares the config znode with the local configuration, unknown fields are not compared
+func (s *Sharding) validateConfig(config *configData) error {
+	if config == nil {
+		return nil
+	}
+	if !s.dynamicNumShards && config.NumShards != s.numShards {
+		return newConfigMismatchError("num_shards", config.NumShards, s.numShards)
+	}
+	if config.ReplicationFactor > 0 && config.ReplicationFactor != s.replicationFactor {
+		return newConfigMismatchError("replication_factor", config.ReplicationFactor, s.replicationFactor)
+	}
+	if config.TwoPhaseHandoff != nil && *config.TwoPhaseHandoff != s.twoPhaseHandoff {
+		return newConfigMismatchError("two_phase_handoff", *config.TwoPhaseHandoff, s.twoPhaseHandoff)
+	}
+	if config.Settings != nil {
+		return validateConfigSettings(config.Settings, s.newConfigSettings())
+	}
+	return nil
+}
+
+func validateConfigSettings(stored *configSettings, local *configSettings) error {
+	fields := []struct {
+		name   string
+		stored any
+		local  any
+	}{
+		{name: "allocator", stored: stored.Allocator, local: local.Allocator},
+		{name: "max_moves_per_round", stored: stored.MaxMovesPerRound, local: local.MaxMovesPerRound},
+		{name: "min_rebalance_interval", stored: stored.MinRebalanceInterval, local: local.MinRebalanceInterval},
+		{name: "node_grace_period", stored: stored.NodeGracePeriod, local: local.NodeGracePeriod},
+		{name: "shard_pinning", stored: stored.ShardPinning, local: local.ShardPinning},
+		{name: "node_draining", stored: stored.NodeDraining, local: local.NodeDraining},
+		{name: "dynamic_num_shards", stored: stored.DynamicNumShards, local: local.DynamicNumShards},
+		{name: "shard_status", stored: stored.ShardStatus, local: local.ShardStatus},
+		{name: "load_reporting", stored: stored.LoadReporting, local: local.LoadReporting},
+		{name: "fencing_epochs", stored: stored.FencingEpochs, local: local.FencingEpochs},
+		{name: "shard_weights", stored: stored.ShardWeights, local: local.ShardWeights},
+		{name: "shard_groups", stored: stored.ShardGroups, local: local.ShardGroups},
+	}
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.stored, f.local) {
+			return newConfigMismatchError(f.name, f.stored, f.local)
+		}
+	}
+	return nil
+}
+
+// completeConfig fills the unknown fields of the config znode with the local configuration,
+// returns nil if nothing is unknown
+func (s *Sharding) completeConfig(config configData) []byte {
+	local := s.newConfigData()
+	changed := false
+
+	if config.ReplicationFactor == 0 {
+		config.ReplicationFactor = local.ReplicationFactor
+		changed = true
+	}
+	if config.TwoPhaseHandoff == nil {
+		config.TwoPhaseHandoff = local.TwoPhaseHandoff
+		changed = true
+	}
+	if config.Settings == nil {
+		config.Settings = local.Settings
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return config.marshalJSON()
+}
+
+func (s *Sharding) checkConfig(config *configData) error {
+	err := s.validateConfig(config)
+	if err != nil {
+		s.logger.Errorf("Refuse to join: %v", err)
+	}
+
+	s.sessMut.Lock()
+	s.configErr = err
+	s.sessMut.Unlock()
+
+	return err
+}
+
+// ConfigError returns the error of validating the config znode in the latest zookeeper session,
+// the current node does NOT join the cluster while it is not nil.
+// The config znode is watched, the current node leaves the cluster by restarting its session
+// if the config znode is changed to a different configuration, and joins again after it is changed back.
+func (s *Sharding) ConfigError() error {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	return s.configErr
+}
+
+// validateObserverConfig compares the config znode with the number of shards of an observer
+func validateObserverConfig(config *configData, numShards ShardID, dynamicNumShards bool) error {
+	if config == nil || dynamicNumShards {
+		return nil
+	}
+	if config.NumShards != numShards {
+		return newConfigMismatchError("num_shards", config.NumShards, numShards)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+// Package sharding assigns shards to the nodes of a cluster using zookeeper.
+//
+// Any node can become the leader, so the following options must be used by every node of the cluster,
+// with the same arguments. They are stored in the config znode by the first node,
+// the other nodes refuse to join the cluster if they are configured differently, see Sharding.ConfigError:
+//
+//   - WithAllocator, only the type of the allocator is compared
+//   - WithReplicationFactor
+//   - WithMaxMovesPerRound
+//   - WithMinRebalanceInterval
+//   - WithNodeGracePeriod
+//   - WithShardPinning
+//   - WithNodeDraining
+//   - WithTwoPhaseHandoff
+//   - WithShardStatus
+//   - WithDynamicNumShards
+//   - WithLoadReporting, only whether it is used
+//   - WithShardWeights
+//   - WithShardGroup
+//   - WithFencingEpochs
+package sharding
@@ -0,0 +1,114 @@
+package sharding
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+func (s *Sharding) getDrainsPath() string {
+	return s.parentPath + drainsZNodeName
+}
+
+// DrainNode marks the node as draining, the leader moves all shards of a draining node to other nodes,
+// while the node is still active. The mark is kept after the node restarts, until UndrainNode is called.
+// Drains are only honored by leaders configured with WithNodeDraining.
+// The callback is called on the goroutine of the zookeeper client, or on a new goroutine with ErrNoSession.
+func (s *Sharding) DrainNode(nodeID string, callback func(err error)) {
+	sess := s.getCurrentSession()
+	if sess == nil {
+		go callback(ErrNoSession)
+		return
+	}
+	client := sess.GetClient()
+	pathVal := s.getDrainsPath() + "/" + nodeID
+
+	var createDrainNode func(parentCreated bool)
+	createDrainNode = func(parentCreated bool) {
+		client.Create(pathVal, nil, 0, func(resp zk.CreateResponse, err error) {
+			if errors.Is(err, zk.ErrNodeExists) {
+				callback(nil)
+				return
+			}
+			if errors.Is(err, zk.ErrNoNode) && !parentCreated {
+				client.Create(s.getDrainsPath(), nil, 0, func(resp zk.CreateResponse, err error) {
+					if err != nil && !errors.Is(err, zk.ErrNodeExists) {
+						callback(err)
+						return
+					}
+					createDrainNode(true)
+				})
+				return
+			}
+			callback(err)
+		})
+	}
+	createDrainNode(false)
+}
+
+// UndrainNode removes the draining mark of the node, the leader can assign shards to it again.
+// The callback is called on the goroutine of the zookeeper client, or on a new goroutine with ErrNoSession.
+func (s *Sharding) UndrainNode(nodeID string, callback func(err error)) {
+	sess := s.getCurrentSession()
+	if sess == nil {
+		go callback(ErrNoSession)
+		return
+	}
+	deleteIfExists(sess.GetClient(), s.getDrainsPath()+"/"+nodeID, callback)
+}
+
+// ========================================
+// Leader Logic
+// ========================================
+
+func (s *Sharding) listDrainingNodes(sess *curator.Session) {
+	sess.GetClient().ChildrenW(s.getDrainsPath(),
+		func(resp zk.ChildrenResponse, err error) {
+			if err != nil {
+				if errors.Is(err, zk.ErrConnectionClosed) {
+					sess.AddRetry(s.listDrainingNodes)
+					return
+				}
+				s.handleError(sess, OpChildren, s.getDrainsPath(), err, s.listDrainingNodes, false)
+				return
+			}
+
+			s.state.draining = map[string]struct{}{}
+			for _, nodeID := range resp.Children {
+				s.state.draining[nodeID] = struct{}{}
+			}
+			s.state.drainsFetched = true
+			s.startHandleNodeChanges(sess)
+		},
+		func(ev zk.Event) {
+			if ev.Type == zk.EventNodeChildrenChanged {
+				s.listDrainingNodes(sess)
+			}
+		},
+	)
+}
+
+func (s *Sharding) isDrainsReady() bool {
+	if !s.nodeDraining {
+		return true
+	}
+	return s.state.drainsFetched
+}
+
+// getAssignableNodes returns the active nodes that are neither draining nor leaving, see Sharding.Shutdown.
+// If every active node is draining or leaving, they are all used, because shards still need to be served.
+func (s *Sharding) getAssignableNodes() []string {
+	nodes := slices.DeleteFunc(slices.Clone(s.state.nodes), func(nodeID string) bool {
+		if info, ok := s.state.nodeDataMap[nodeID]; ok && info.data.Leaving {
+			return true
+		}
+		_, ok := s.state.draining[nodeID]
+		return ok
+	})
+	if len(nodes) == 0 {
+		return s.state.nodes
+	}
+	return nodes
+}
@@ -0,0 +1,218 @@
+package sharding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+// DuplicateNodePolicy decides what to do when the node znode of the current node id already exists
+// and is owned by another zookeeper session, e.g. a restarted process whose previous session has NOT expired yet,
+// or two processes configured with the same node id
+type DuplicateNodePolicy int
+
+const (
+	// DuplicateNodeWait waits until the existing node znode is deleted, then joins the cluster
+	DuplicateNodeWait DuplicateNodePolicy = iota + 1
+
+	// DuplicateNodeFail refuses to join the cluster in the current zookeeper session,
+	// the error is returned by Sharding.DuplicateNodeError
+	DuplicateNodeFail
+
+	// DuplicateNodeTakeOver deletes the existing node znode and joins the cluster.
+	// The other session is NOT notified, so it should only be used when the other process is known to be dead.
+	DuplicateNodeTakeOver
+)
+
+func validateDuplicateNodePolicy(policy DuplicateNodePolicy) {
+	switch policy {
+	case DuplicateNodeWait, DuplicateNodeFail, DuplicateNodeTakeOver:
+	default:
+		panic("Invalid duplicate node policy")
+	}
+}
+
+// DuplicateNodeError returns ErrDuplicateNodeID if the current node refused to join the cluster
+// in the latest zookeeper session because of DuplicateNodeFail.
+// Always nil if not configured with WithDuplicateNodePolicy.
+func (s *Sharding) DuplicateNodeError() error {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	return s.duplicateErr
+}
+
+func (s *Sharding) setDuplicateNodeError(err error) {
+	if err != nil {
+		s.logger.Errorf("Refuse to join: %v", err)
+	}
+
+	s.sessMut.Lock()
+	s.duplicateErr = err
+	s.sessMut.Unlock()
+}
+
+// ========================================
+// Logic for Creating the Node ZNode
+// ========================================
+
+// createOwnedEphemeralNode creates the node znode with the session token of the controller.
+// An existing node znode is owned by the current session if it contains the same token,
+// e.g. when the response of the previous create request was lost.
+func (c *containerNodeController) createOwnedEphemeralNode(sess *curator.Session) {
+	pathVal := c.getNodesPath() + "/" + c.nodeID
+
+	data := c.getNodeData()
+	data.Session = c.state.sessionToken
+
+	sess.GetClient().Create(pathVal, data.marshalJSON(), zk.FlagEphemeral, func(resp zk.CreateResponse, err error) {
+		if err == nil {
+			c.onEphemeralNodeCreated(sess)
+			return
+		}
+		if errors.Is(err, zk.ErrNodeExists) {
+			c.checkExistingNode(sess)
+			return
+		}
+		if errors.Is(err, zk.ErrConnectionClosed) {
+			sess.AddRetry(c.createOwnedEphemeralNode)
+			return
+		}
+		c.errs.handle(sess, &UnexpectedError{Op: OpCreate, Path: pathVal, Err: err}, c.createOwnedEphemeralNode, false)
+	})
+}
+
+func (c *containerNodeController) onEphemeralNodeCreated(sess *curator.Session) {
+	c.setDuplicateErr(nil)
+	c.state.nodesCreated = true
+	c.createCompleted(sess)
+}
+
+func (c *containerNodeController) checkExistingNode(sess *curator.Session) {
+	pathVal := c.getNodesPath() + "/" + c.nodeID
+
+	sess.GetClient().Get(pathVal, func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(c.checkExistingNode)
+				return
+			}
+			if errors.Is(err, zk.ErrNoNode) {
+				c.createOwnedEphemeralNode(sess)
+				return
+			}
+			c.errs.handle(sess, &UnexpectedError{Op: OpGet, Path: pathVal, Err: err}, c.checkExistingNode, false)
+			return
+		}
+
+		var data nodeData
+		if err := json.Unmarshal(resp.Data, &data); err == nil && data.Session == c.state.sessionToken {
+			c.onEphemeralNodeCreated(sess)
+			return
+		}
+		c.handleDuplicateNode(sess, resp.Stat.Version)
+	})
+}
+
+func (c *containerNodeController) handleDuplicateNode(sess *curator.Session, version int32) {
+	switch c.duplicatePolicy {
+	case DuplicateNodeFail:
+		c.setDuplicateErr(fmt.Errorf("%w: %s", ErrDuplicateNodeID, c.nodeID))
+	case DuplicateNodeTakeOver:
+		c.deleteExistingNode(sess, version)
+	default:
+		c.waitExistingNodeDeleted(sess)
+	}
+}
+
+func (c *containerNodeController) setDuplicateErr(err error) {
+	if c.onDuplicate != nil {
+		c.onDuplicate(err)
+	}
+}
+
+func (c *containerNodeController) waitExistingNodeDeleted(sess *curator.Session) {
+	pathVal := c.getNodesPath() + "/" + c.nodeID
+	state := c.state
+
+	sess.GetClient().GetW(pathVal, func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(c.waitExistingNodeDeleted)
+				return
+			}
+			if errors.Is(err, zk.ErrNoNode) {
+				c.createOwnedEphemeralNode(sess)
+				return
+			}
+			c.errs.handle(sess, &UnexpectedError{Op: OpGet, Path: pathVal, Err: err}, c.waitExistingNodeDeleted, false)
+		}
+	}, func(ev zk.Event) {
+		if c.state != state {
+			return
+		}
+		if ev.Type == zk.EventNodeDeleted {
+			c.createOwnedEphemeralNode(sess)
+		} else if ev.Type == zk.EventNodeDataChanged {
+			c.waitExistingNodeDeleted(sess)
+		}
+	})
+}
+
+func (c *containerNodeController) deleteExistingNode(sess *curator.Session, version int32) {
+	pathVal := c.getNodesPath() + "/" + c.nodeID
+
+	sess.GetClient().Delete(pathVal, version, func(resp zk.DeleteResponse, err error) {
+		if err == nil || errors.Is(err, zk.ErrNoNode) {
+			c.createOwnedEphemeralNode(sess)
+			return
+		}
+		if errors.Is(err, zk.ErrConnectionClosed) {
+			// the delete request might be applied, the existing node is checked again
+			sess.AddRetry(c.checkExistingNode)
+			return
+		}
+		if errors.Is(err, zk.ErrBadVersion) {
+			c.checkExistingNode(sess)
+			return
+		}
+		c.errs.handle(sess, &UnexpectedError{Op: OpDelete, Path: pathVal, Err: err}, c.checkExistingNode, false)
+	})
+}
+
+// deleteOwnedNode deletes the node znode if it is created by the current session of the controller,
+// for ErrorActionRestartSession. owned is false if the node znode does not exist or is owned by another process.
+func (c *containerNodeController) deleteOwnedNode(client curator.Client, callback func(owned bool, err error)) {
+	if len(c.nodeID) == 0 {
+		callback(true, nil)
+		return
+	}
+
+	pathVal := c.getNodesPath() + "/" + c.nodeID
+	token := c.state.sessionToken
+
+	client.Get(pathVal, func(resp zk.GetResponse, err error) {
+		if errors.Is(err, zk.ErrNoNode) {
+			callback(false, nil)
+			return
+		}
+		if err != nil {
+			callback(false, err)
+			return
+		}
+
+		var data nodeData
+		if err := json.Unmarshal(resp.Data, &data); err != nil || data.Session != token {
+			callback(false, nil)
+			return
+		}
+		client.Delete(pathVal, resp.Stat.Version, func(_ zk.DeleteResponse, err error) {
+			if errors.Is(err, zk.ErrNoNode) {
+				err = nil
+			}
+			callback(true, err)
+		})
+	})
+}
@@ -0,0 +1,53 @@
+package sharding
+
+// resolveEpochs returns the fencing epoch of each primary shard of the assign znode,
+// shards without an epoch in the data are assigned by the current version of the znode, with the mzxid
+func resolveEpochs(assign assignData, mzxid int64) map[ShardID]int64 {
+	epochs := make(map[ShardID]int64, len(assign.Shards))
+	for _, id := range assign.Shards {
+		epoch, ok := assign.Epochs[id]
+		if !ok {
+			epoch = mzxid
+		}
+		epochs[id] = epoch
+	}
+	return epochs
+}
+
+// ShardEpoch returns the fencing epoch of a shard that the current node is holding.
+// The epoch of a shard only increases when the shard is assigned to a node,
+// so a storage can reject writes with epochs lower than the highest one it has seen,
+// e.g. from a node that has lost the shard but has NOT noticed yet.
+// Returns false if the current node is not holding the shard, or is not configured with WithFencingEpochs.
+func (s *Sharding) ShardEpoch(id ShardID) (int64, bool) {
+	if !s.fencingEpochs || s.owner == nil {
+		return 0, false
+	}
+	return s.owner.getEpoch(id)
+}
+
+// ========================================
+// Leader Logic
+// ========================================
+
+// getKeptEpochs returns the epochs of shards that the node keeps from its current assign znode
+func (s *Sharding) getKeptEpochs(nodeID string, shards []ShardID) map[ShardID]int64 {
+	if !s.fencingEpochs {
+		return nil
+	}
+
+	current := s.state.currentAssignMap[nodeID].epochs
+
+	var result map[ShardID]int64
+	for _, id := range shards {
+		epoch, ok := current[id]
+		if !ok {
+			continue
+		}
+		if result == nil {
+			result = map[ShardID]int64{}
+		}
+		result[id] = epoch
+	}
+	return result
+}
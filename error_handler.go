@@ -0,0 +1,176 @@
+package sharding
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+)
+
+// ErrorAction is the action decided by an ErrorHandler, other values are rejected by a panic
+type ErrorAction int
+
+const (
+	// ErrorActionPanic panics with the original error, the default behavior without WithErrorHandler
+	ErrorActionPanic ErrorAction = iota
+
+	// ErrorActionRetry sends the failed request again after the retry delay of the error handler.
+	// The delay is doubled on every consecutive failure of the same operation on the same znode,
+	// up to 32 times the retry delay.
+	ErrorActionRetry
+
+	// ErrorActionSkip ignores the failed znode, e.g. a node with an invalid assign znode is treated as not existed.
+	// For requests that can NOT be skipped, e.g. listing the children of a container znode, it is the same as retry.
+	ErrorActionSkip
+
+	// ErrorActionRestartSession drops the current zookeeper session of the library and begins a new one
+	// with the same zookeeper client, same as a session expiry: the shards of the current node are released,
+	// its node znode and leader lock znodes are deleted, and all znodes are read again in the new session.
+	// The zookeeper session itself is NOT closed, only the requests of the dropped session are ignored.
+	// An Observer only reads all znodes again.
+	ErrorActionRestartSession
+)
+
+// Operations of UnexpectedError
+const (
+	OpGet       = "get"
+	OpChildren  = "children"
+	OpCreate    = "create"
+	OpSet       = "set"
+	OpDelete    = "delete"
+	OpUnmarshal = "unmarshal" // the data of a znode is invalid
+	OpAllocate  = "allocate"  // the result of the Allocator is invalid
+)
+
+// UnexpectedError is an error returned by zookeeper that can NOT be handled by the library itself,
+// or an invalid data of a znode. It is passed to the handler configured by WithErrorHandler.
+type UnexpectedError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *UnexpectedError) Error() string {
+	return fmt.Sprintf("sharding: unexpected error on %s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e *UnexpectedError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler decides the action for an unexpected error, it is called on the goroutine of the zookeeper client
+type ErrorHandler func(err *UnexpectedError) ErrorAction
+
+// maxRetryDelayFactor limits the exponential backoff of ErrorActionRetry
+const maxRetryDelayFactor = 32
+
+type errorHandler struct {
+	parent     string
+	handler    ErrorHandler
+	retryDelay time.Duration
+	now        func() time.Time
+	afterFunc  func(d time.Duration, fn func())
+
+	// restartSession is called for ErrorActionRestartSession, see sessionRunner
+	restartSession func()
+
+	// seq is increased on every new zookeeper session, for ignoring retries of previous sessions
+	seq int
+
+	// failures are the consecutive failures of each operation and znode, for the exponential backoff
+	failures map[string]retryFailure
+}
+
+type retryFailure struct {
+	delay  time.Duration
+	lastAt time.Time
+}
+
+func newErrorHandler(parent string, handler ErrorHandler, retryDelay time.Duration) *errorHandler {
+	return &errorHandler{
+		parent:     parent,
+		handler:    handler,
+		retryDelay: retryDelay,
+		now:        time.Now,
+		afterFunc: func(d time.Duration, fn func()) {
+			time.AfterFunc(d, fn)
+		},
+	}
+}
+
+func (h *errorHandler) onSessionStart() {
+	h.seq++
+	h.failures = nil
+}
+
+// handle takes the action decided by the handler, it returns true if the failed znode should be skipped.
+// If canSkip is false, skipping is the same as retrying.
+func (h *errorHandler) handle(
+	sess *curator.Session, err *UnexpectedError,
+	retry func(sess *curator.Session), canSkip bool,
+) bool {
+	action := ErrorActionPanic
+	if h.handler != nil {
+		action = h.handler(err)
+	}
+
+	switch action {
+	case ErrorActionSkip:
+		if canSkip {
+			return true
+		}
+		h.retryAfterDelay(sess, err, retry)
+	case ErrorActionRetry:
+		h.retryAfterDelay(sess, err, retry)
+	case ErrorActionRestartSession:
+		h.restartSession()
+	case ErrorActionPanic:
+		panic(err.Err)
+	default:
+		panic(fmt.Sprintf("Invalid error action %d", action))
+	}
+	return false
+}
+
+// nextRetryDelay doubles the delay of the previous failure of the same operation and znode.
+// A failure long after the previous one is considered not consecutive, the delay starts again from retryDelay.
+func (h *errorHandler) nextRetryDelay(err *UnexpectedError) time.Duration {
+	key := err.Op + " " + err.Path
+	maxDelay := h.retryDelay * maxRetryDelayFactor
+	now := h.now()
+
+	prev, ok := h.failures[key]
+	delay := h.retryDelay
+	if ok && now.Sub(prev.lastAt) <= 2*maxDelay {
+		delay = min(2*prev.delay, maxDelay)
+	}
+
+	if h.failures == nil {
+		h.failures = map[string]retryFailure{}
+	}
+	h.failures[key] = retryFailure{delay: delay, lastAt: now}
+	return delay
+}
+
+// retryAfterDelay runs the retry on the goroutine of the session after the delay, see runOnSession.
+// The retry is ignored if the session has changed.
+func (h *errorHandler) retryAfterDelay(
+	sess *curator.Session, err *UnexpectedError, retry func(sess *curator.Session),
+) {
+	seq := h.seq
+	h.afterFunc(h.nextRetryDelay(err), func() {
+		runOnSession(sess, h.parent, func(sess *curator.Session) {
+			if h.seq != seq {
+				return
+			}
+			retry(sess)
+		})
+	})
+}
+
+func (s *Sharding) handleError(
+	sess *curator.Session, op string, pathVal string, err error,
+	retry func(sess *curator.Session), canSkip bool,
+) bool {
+	return s.errs.handle(sess, &UnexpectedError{Op: op, Path: pathVal, Err: err}, retry, canSkip)
+}
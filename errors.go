@@ -0,0 +1,37 @@
+package sharding
+
+import (
+	"errors"
+)
+
+// ErrNoSession is returned when the zookeeper session has not been established yet
+var ErrNoSession = errors.New("sharding: zookeeper session not established")
+
+// ErrInvalidNumShards is returned when the new number of shards is neither a multiple nor a divisor of the current one
+var ErrInvalidNumShards = errors.New("sharding: invalid number of shards")
+
+// ErrConfigMismatch is returned when the configuration of the current process
+// is different from the configuration stored in the config znode
+var ErrConfigMismatch = errors.New("sharding: config mismatch")
+
+// ErrInvalidShardID is returned when the shard id is not in [0, numShards)
+var ErrInvalidShardID = errors.New("sharding: invalid shard id")
+
+// ErrInvalidAllocation is reported to the error handler when the result of an Allocator is invalid
+var ErrInvalidAllocation = errors.New("sharding: invalid allocation")
+
+// ErrOutOfOrderResponse is reported to the error handler when the leader receives the data of an assign znode
+// older than the version it already knows
+var ErrOutOfOrderResponse = errors.New("sharding: out of order responses")
+
+// ErrNoObserver is returned when waiting for assignments without WithShardingObserver
+var ErrNoObserver = errors.New("sharding: observer not configured")
+
+// ErrShutdown is returned when Shutdown is called more than once
+var ErrShutdown = errors.New("sharding: already shut down")
+
+// ErrShutdownTimeout is returned when the shards of the current node are NOT handed off before the timeout of Shutdown
+var ErrShutdownTimeout = errors.New("sharding: shutdown timeout")
+
+// ErrDuplicateNodeID is returned when the node znode of the current node id is owned by another zookeeper session
+var ErrDuplicateNodeID = errors.New("sharding: duplicate node id")
@@ -4,6 +4,7 @@ go 1.21.2
 
 require (
 	github.com/QuangTung97/zk v0.1.1-0.20240410063201-0b639ad85c1a
+	github.com/cespare/xxhash/v2 v2.1.2
 	github.com/mgechev/revive v1.3.7
 	github.com/stretchr/testify v1.9.0
 )
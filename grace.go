@@ -0,0 +1,57 @@
+package sharding
+
+import (
+	"slices"
+
+	"github.com/QuangTung97/zk/curator"
+)
+
+// computeGraceNodes returns the nodes that disappeared from the list of active nodes
+// but are still in their grace period. The shards of these nodes are reserved for them.
+// A new leader starts the grace period of a node from the time it first sees the node missing.
+func (s *Sharding) computeGraceNodes(sess *curator.Session) map[string]struct{} {
+	if s.nodeGracePeriod <= 0 {
+		return nil
+	}
+
+	now := s.now()
+	result := map[string]struct{}{}
+
+	for nodeID := range s.state.vanishedAt {
+		_, ok := s.state.currentAssignMap[nodeID]
+		if !ok || slices.Contains(s.state.nodes, nodeID) {
+			delete(s.state.vanishedAt, nodeID)
+		}
+	}
+
+	for nodeID := range s.state.currentAssignMap {
+		if slices.Contains(s.state.nodes, nodeID) {
+			continue
+		}
+
+		vanishedAt, ok := s.state.vanishedAt[nodeID]
+		if !ok {
+			vanishedAt = now
+			s.state.vanishedAt[nodeID] = now
+		}
+
+		elapsed := now.Sub(vanishedAt)
+		if elapsed >= s.nodeGracePeriod {
+			continue
+		}
+
+		result[nodeID] = struct{}{}
+		s.scheduleRebalance(sess, s.nodeGracePeriod-elapsed)
+	}
+
+	return result
+}
+
+// getReservedShards returns the shards of nodes in their grace period
+func (s *Sharding) getReservedShards(graceNodes map[string]struct{}) []ShardID {
+	var result []ShardID
+	for _, nodeID := range getKeys(graceNodes) {
+		result = append(result, s.state.currentAssignMap[nodeID].shards...)
+	}
+	return result
+}
@@ -0,0 +1,127 @@
+package sharding
+
+import (
+	"strings"
+)
+
+type shardGroupConfig struct {
+	name      string
+	numShards ShardID
+	listener  ShardListener
+	handler   ShardHandler
+}
+
+// GroupOption configures a shard group of the current node, see WithShardGroup
+type GroupOption func(g *shardGroupConfig)
+
+// WithGroupShardListener is WithShardListener for the shards of the shard group,
+// the current shards of the group are also available by Sharding.GroupShards
+func WithGroupShardListener(listener ShardListener) GroupOption {
+	return func(g *shardGroupConfig) {
+		if listener == nil {
+			panic("Invalid shard listener")
+		}
+		g.listener = listener
+	}
+}
+
+// WithGroupShardHandler is WithShardHandler for the shards of the shard group
+func WithGroupShardHandler(handler ShardHandler) GroupOption {
+	return func(g *shardGroupConfig) {
+		if handler == nil {
+			panic("Invalid shard handler")
+		}
+		g.handler = handler
+	}
+}
+
+// getGroupAssignsName returns the assigns znode of the shard group, e.g. /groups/<group>/assigns
+func getGroupAssignsName(group string) string {
+	return groupsZNodeName + "/" + group + assignZNodeName
+}
+
+// getGroupContainerNames returns the container znodes of the shard groups, parents first
+func getGroupContainerNames(configs []shardGroupConfig) []string {
+	if len(configs) == 0 {
+		return nil
+	}
+	names := []string{groupsZNodeName}
+	for _, g := range configs {
+		names = append(names, groupsZNodeName+"/"+g.name, getGroupAssignsName(g.name))
+	}
+	return names
+}
+
+func validateShardGroup(configs []shardGroupConfig, name string, numShards ShardID) {
+	if len(name) == 0 || strings.Contains(name, "/") || numShards == 0 {
+		panic("Invalid shard group")
+	}
+	for _, g := range configs {
+		if g.name == name {
+			panic("Duplicated shard group")
+		}
+	}
+}
+
+// initShardGroups creates a leader and an owner for each shard group. They share the zookeeper session,
+// the node znode and the leader lock of the current node, but only support the basic features:
+// the allocator, node weights, node topologies, the shard listener and the shard handler.
+func (s *Sharding) initShardGroups() {
+	for _, config := range s.groupConfigs {
+		g := &Sharding{
+			parentPath: s.parentPath,
+			nodeID:     s.nodeID,
+			numShards:  config.numShards,
+			nodeAddr:   s.nodeAddr,
+			group:      config.name,
+
+			replicationFactor: 1,
+
+			logger:    s.logger,
+			allocator: s.allocator,
+
+			knownNumShards: config.numShards,
+			shardFunc:      s.shardFunc,
+
+			now:       s.now,
+			afterFunc: s.afterFunc,
+			errs:      s.errs,
+		}
+
+		if !s.coordinator {
+			g.owner = newShardOwner(s.parentPath, s.nodeID, false, false, s.errs)
+			g.owner.assignsPath = g.getAssignsPath()
+			g.owner.listener = config.listener
+			g.owner.afterFunc = s.owner.afterFunc
+			if config.handler != nil {
+				g.owner.setHandler(config.handler)
+			}
+		}
+
+		if s.observerFunc != nil {
+			g.obs = newGroupObserverCore(s.parentPath, config, s.observerFunc)
+			g.obs.shardFunc = s.shardFunc
+			g.obs.errs = s.errs
+		}
+
+		s.groups = append(s.groups, g)
+	}
+}
+
+// getOwners returns the owners of the default group and the shard groups, empty for a coordinator
+func (s *Sharding) getOwners() []*shardOwner {
+	if s.owner == nil {
+		return nil
+	}
+	owners := []*shardOwner{s.owner}
+	for _, g := range s.groups {
+		owners = append(owners, g.owner)
+	}
+	return owners
+}
+
+func newGroupObserverCore(parent string, config shardGroupConfig, observerFunc ObserverFunc) *observerCore {
+	c := newObserverCore(parent, config.numShards, observerFunc)
+	c.group = config.name
+	return c
+}
@@ -0,0 +1,121 @@
+package sharding
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// ShardHandler runs the work of the shards held by the current node, see WithShardHandler
+type ShardHandler interface {
+	// OnAcquire is called on a new goroutine when the current node acquires the shard.
+	// It should do the work of the shard until ctx is cancelled, that is when the shard is released,
+	// when the zookeeper session of the current node is restarted, or is lost, see WithSessionLossTimeout.
+	OnAcquire(ctx context.Context, id ShardID)
+
+	// OnRelease is called on the same goroutine after OnAcquire has returned and ctx is cancelled
+	OnRelease(id ShardID)
+}
+
+// shardRunner runs a worker goroutine for each shard held by the current node.
+// A worker of a shard only starts after the previous worker of the same shard has stopped.
+type shardRunner struct {
+	handler ShardHandler
+
+	// onStopped is called on the goroutine of a worker after the worker has stopped
+	onStopped func()
+
+	// stopped is closed and replaced every time a worker has stopped
+	mut     sync.Mutex
+	workers map[ShardID]*shardWorker
+	stopped chan struct{}
+}
+
+type shardWorker struct {
+	cancel func()
+	done   chan struct{}
+}
+
+func newShardRunner(handler ShardHandler, onStopped func()) *shardRunner {
+	return &shardRunner{
+		handler:   handler,
+		onStopped: onStopped,
+		workers:   map[ShardID]*shardWorker{},
+		stopped:   make(chan struct{}),
+	}
+}
+
+func (r *shardRunner) acquire(id ShardID) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &shardWorker{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	prev := r.workers[id]
+	r.workers[id] = w
+
+	go r.run(ctx, id, w, prev)
+}
+
+func (r *shardRunner) run(ctx context.Context, id ShardID, w *shardWorker, prev *shardWorker) {
+	if prev != nil {
+		<-prev.done
+	}
+
+	r.handler.OnAcquire(ctx, id)
+	<-ctx.Done()
+	r.handler.OnRelease(id)
+
+	r.mut.Lock()
+	if r.workers[id] == w {
+		delete(r.workers, id)
+	}
+	close(r.stopped)
+	r.stopped = make(chan struct{})
+	r.mut.Unlock()
+
+	close(w.done)
+	r.onStopped()
+}
+
+func (r *shardRunner) release(id ShardID) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	w, ok := r.workers[id]
+	if ok {
+		w.cancel()
+	}
+}
+
+// waitAllStopped blocks until there are no workers
+func (r *shardRunner) waitAllStopped() {
+	for {
+		r.mut.Lock()
+		num := len(r.workers)
+		stopped := r.stopped
+		r.mut.Unlock()
+
+		if num == 0 {
+			return
+		}
+		<-stopped
+	}
+}
+
+// getRunningShards returns the sorted shards that have workers, including the released ones that are stopping
+func (r *shardRunner) getRunningShards() []ShardID {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	result := make([]ShardID, 0, len(r.workers))
+	for id := range r.workers {
+		result = append(result, id)
+	}
+	slices.Sort(result)
+	return result
+}
@@ -0,0 +1,285 @@
+package sharding
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+type leaderNodeStatus struct {
+	shards     []ShardID
+	numShards  ShardID
+	assignZxid int64
+	czxid      int64
+	fetched    bool
+}
+
+func (s *Sharding) getStatusPath() string {
+	return s.parentPath + statusZNodeName
+}
+
+func (s *Sharding) listNodeStatuses(sess *curator.Session) {
+	sess.GetClient().ChildrenW(s.getStatusPath(), func(resp zk.ChildrenResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(s.listNodeStatuses)
+				return
+			}
+			s.handleError(sess, OpChildren, s.getStatusPath(), err, s.listNodeStatuses, false)
+			return
+		}
+
+		for nodeID := range s.state.statusMap {
+			if !slices.Contains(resp.Children, nodeID) {
+				delete(s.state.statusMap, nodeID)
+			}
+		}
+
+		for _, nodeID := range resp.Children {
+			_, ok := s.state.statusMap[nodeID]
+			if ok {
+				continue
+			}
+			s.state.statusMap[nodeID] = &leaderNodeStatus{}
+			s.getNodeStatus(sess, nodeID)
+		}
+
+		s.state.listStatusCompleted = true
+		s.startHandleNodeChanges(sess)
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeChildrenChanged {
+			s.listNodeStatuses(sess)
+		}
+	})
+}
+
+func (s *Sharding) getNodeStatus(sess *curator.Session, nodeID string) {
+	pathVal := s.getStatusPath() + "/" + nodeID
+	retry := func(sess *curator.Session) {
+		s.getNodeStatus(sess, nodeID)
+	}
+
+	sess.GetClient().GetW(pathVal, func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(retry)
+				return
+			}
+			if errors.Is(err, zk.ErrNoNode) {
+				delete(s.state.statusMap, nodeID)
+				s.startHandleNodeChanges(sess)
+				return
+			}
+			s.handleError(sess, OpGet, pathVal, err, retry, false)
+			return
+		}
+
+		var status statusData
+		if err := json.Unmarshal(resp.Data, &status); err != nil {
+			// a skipped status znode is treated as a node holding no shards
+			if !s.handleError(sess, OpUnmarshal, pathVal, err, retry, true) {
+				return
+			}
+			status = statusData{}
+		}
+
+		info, ok := s.state.statusMap[nodeID]
+		if !ok {
+			return
+		}
+		info.shards = status.Shards
+		info.numShards = status.NumShards
+		info.assignZxid = status.AssignZxid
+		info.czxid = resp.Stat.Czxid
+		info.fetched = true
+		s.startHandleNodeChanges(sess)
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDataChanged {
+			s.getNodeStatus(sess, nodeID)
+		} else if ev.Type == zk.EventNodeDeleted {
+			delete(s.state.statusMap, nodeID)
+			s.startHandleNodeChanges(sess)
+		}
+	})
+}
+
+func (s *Sharding) isNodeStatusReady() bool {
+	if !s.twoPhaseHandoff {
+		return true
+	}
+	if !s.state.listStatusCompleted {
+		return false
+	}
+	for _, info := range s.state.statusMap {
+		if !info.fetched {
+			return false
+		}
+	}
+	return true
+}
+
+// isAssignOutdated returns true if the assign znode of the node was written before the status znode
+// of its current session was created. The node ignores such an assignment, so it must be written again.
+func (s *Sharding) isAssignOutdated(nodeID string) bool {
+	if !s.twoPhaseHandoff {
+		return false
+	}
+	assign, ok := s.state.currentAssignMap[nodeID]
+	if !ok {
+		return false
+	}
+	status, ok := s.state.statusMap[nodeID]
+	if !ok {
+		return false
+	}
+	return assign.mzxid < status.czxid
+}
+
+// isStatusCaughtUp returns true if the node has applied its current assign znode.
+// Such a node is holding exactly the shards in its status znode.
+func (s *Sharding) isStatusCaughtUp(nodeID string, status *leaderNodeStatus) bool {
+	assign, ok := s.state.currentAssignMap[nodeID]
+	if !ok {
+		return len(status.shards) == 0
+	}
+	return status.assignZxid == assign.mzxid
+}
+
+// getHeldAssignShards returns the assigned shards that the node may consider as owned
+func (s *Sharding) getHeldAssignShards(nodeID string) []ShardID {
+	if s.isAssignOutdated(nodeID) {
+		return nil
+	}
+	return s.state.currentAssignMap[nodeID].shards
+}
+
+// computeGrantedShards returns the shards that can be written to the assign znodes of nodes.
+// With two-phase handoff, a desired shard is only granted to a node after
+// all other nodes are no longer assigned and no longer report holding it.
+// A node that has not yet acknowledged its assign znode might still hold the shards revoked from it since,
+// no new shard is granted while those shards are unknown, e.g. when they were revoked by a previous leader.
+func (s *Sharding) computeGrantedShards(desired map[string][]ShardID) map[string][]ShardID {
+	if !s.twoPhaseHandoff {
+		return desired
+	}
+
+	holders, allKnown := s.computeShardHolders()
+
+	isHeldByOthers := func(id ShardID, nodeID string) bool {
+		for _, holder := range holders[id] {
+			if holder != nodeID {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := make(map[string][]ShardID, len(desired))
+	for nodeID, shards := range desired {
+		current := s.getHeldAssignShards(nodeID)
+
+		granted := make([]ShardID, 0, len(shards))
+		for _, id := range shards {
+			if slices.Contains(current, id) || (allKnown && !isHeldByOthers(id, nodeID)) {
+				granted = append(granted, id)
+			}
+		}
+		result[nodeID] = granted
+	}
+	return result
+}
+
+// computeShardHolders returns the nodes that may be holding each shard, by their assign and status znodes
+// and the shards revoked from them, and whether the revoked shards of every node are known
+func (s *Sharding) computeShardHolders() (map[ShardID][]string, bool) {
+	// the view of assign znodes is only trustworthy when no write is in flight
+	allKnown := s.state.assignWritesInFlight == 0 && !s.state.assignsRelisting
+	holders := map[ShardID][]string{}
+	for nodeID, info := range s.state.statusMap {
+		for _, id := range mapShards(info.shards, info.numShards, s.state.numShards) {
+			holders[id] = append(holders[id], nodeID)
+		}
+		if s.isStatusCaughtUp(nodeID, info) {
+			continue
+		}
+		revoked, known := s.getUnackedRevokedShards(nodeID, info)
+		if !known {
+			allKnown = false
+		}
+		for _, id := range revoked {
+			holders[id] = append(holders[id], nodeID)
+		}
+	}
+	for nodeID := range s.state.currentAssignMap {
+		for _, id := range s.getHeldAssignShards(nodeID) {
+			holders[id] = append(holders[id], nodeID)
+		}
+	}
+	return holders, allKnown
+}
+
+// assignHistory is the shards revoked from a node since the current leader first read its assign znode
+type assignHistory struct {
+	// since is the mzxid of the first assign znode of the node read by the current leader,
+	// shards revoked before it are unknown
+	since int64
+
+	shards  []ShardID
+	basis   ShardID
+	revoked []revokedShard
+}
+
+type revokedShard struct {
+	id ShardID
+
+	// mzxid is of the assign znode that revoked the shard
+	mzxid int64
+}
+
+// trackRevokedShards records the shards removed from the assign znode of the node, only with two-phase handoff
+func (s *Sharding) trackRevokedShards(nodeID string, assign assignState) {
+	if !s.twoPhaseHandoff {
+		return
+	}
+
+	history, ok := s.state.assignHistory[nodeID]
+	if !ok || history.basis != assign.basis {
+		history = &assignHistory{since: assign.mzxid}
+		s.state.assignHistory[nodeID] = history
+	}
+
+	for _, id := range history.shards {
+		if !slices.Contains(assign.shards, id) {
+			history.revoked = append(history.revoked, revokedShard{id: id, mzxid: assign.mzxid})
+		}
+	}
+	history.shards = slices.Clone(assign.shards)
+	history.basis = assign.basis
+}
+
+// getUnackedRevokedShards returns the shards revoked from the node after the assign znode it has acknowledged,
+// and false if they are unknown. The node ignores assign znodes written before its status znode was created.
+func (s *Sharding) getUnackedRevokedShards(nodeID string, status *leaderNodeStatus) ([]ShardID, bool) {
+	acked := max(status.assignZxid, status.czxid)
+
+	history, ok := s.state.assignHistory[nodeID]
+	if !ok {
+		return nil, false
+	}
+
+	history.revoked = slices.DeleteFunc(history.revoked, func(r revokedShard) bool {
+		return r.mzxid <= acked
+	})
+	if acked < history.since || history.basis != s.state.currentAssignMap[nodeID].basis {
+		return nil, false
+	}
+
+	result := make([]ShardID, 0, len(history.revoked))
+	for _, r := range history.revoked {
+		result = append(result, r.id)
+	}
+	return result, true
+}
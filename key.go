@@ -0,0 +1,130 @@
+package sharding
+
+import (
+	"hash/fnv"
+	"slices"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// ShardFunc maps a key to a shard in [0, numShards).
+// Every service accessing the same cluster must use the same ShardFunc.
+type ShardFunc func(key string, numShards ShardID) ShardID
+
+// FNVModuloShardFunc maps the 64-bit FNV-1a hash of the key modulo numShards, it is the default ShardFunc.
+// Keys are moved following the split and merge mapping of ShardResize when the number of shards changed.
+func FNVModuloShardFunc(key string, numShards ShardID) ShardID {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return ShardID(h.Sum64() % uint64(numShards))
+}
+
+// XXHashModuloShardFunc maps the 64-bit xxhash of the key modulo numShards.
+// Keys are moved following the split and merge mapping of ShardResize when the number of shards changed.
+func XXHashModuloShardFunc(key string, numShards ShardID) ShardID {
+	return ShardID(xxhash.Sum64String(key) % uint64(numShards))
+}
+
+// JumpHashShardFunc maps the 64-bit xxhash of the key using the jump consistent hash algorithm.
+// When the number of shards increased, only the minimal number of keys are moved, all to the new shards,
+// so it does NOT follow the split mapping of ShardResize. It should be configured by WithNonResizableShardFunc,
+// and can NOT be used with WithDynamicNumShards.
+func JumpHashShardFunc(key string, numShards ShardID) ShardID {
+	return ShardID(jumpHash(xxhash.Sum64String(key), int64(numShards)))
+}
+
+// jumpHash is the algorithm from the paper "A Fast, Minimal Memory, Consistent Hash Algorithm"
+func jumpHash(key uint64, numBuckets int64) int64 {
+	var b, j int64 = -1, 0
+	for j < numBuckets {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return b
+}
+
+// ShardForKey returns the shard of the key, using the ShardFunc configured by WithShardFunc
+// and the latest known number of shards. Returns 0 if the number of shards is 0.
+func (s *Sharding) ShardForKey(key string) ShardID {
+	numShards := s.getKnownNumShards()
+	if numShards == 0 {
+		return 0
+	}
+	return s.shardFunc(key, numShards)
+}
+
+// NodeForKey returns the primary node of the shard of the key, from the latest event of the observer.
+// Returns false if the current node is not configured with WithShardingObserver, or no event has happened yet.
+func (s *Sharding) NodeForKey(key string) (Node, bool) {
+	if s.obs == nil {
+		return Node{}, false
+	}
+	return s.obs.nodeForKey(key)
+}
+
+func (s *Sharding) getKnownNumShards() ShardID {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	return s.knownNumShards
+}
+
+func (s *Sharding) setKnownNumShards(numShards ShardID) {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	s.knownNumShards = numShards
+}
+
+// ShardForKey returns the shard of the key, using the ShardFunc configured by WithObserverShardFunc
+// and the number of shards of the latest event. Returns 0 if the number of shards is 0.
+func (o *Observer) ShardForKey(key string) ShardID {
+	return o.core.shardForKey(key)
+}
+
+// NodeForKey returns the primary node of the shard of the key, from the latest event of the observer.
+// Returns false if no event has happened yet.
+func (o *Observer) NodeForKey(key string) (Node, bool) {
+	return o.core.nodeForKey(key)
+}
+
+// setNotified is called on every event, for answering queries from other goroutines
+func (c *observerCore) setNotified(nodes []Node) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.hasNotified = true
+	c.notified = nodes
+	c.notifiedNumShards = c.numShards
+
+	if len(nodes) > 0 {
+		c.ready = true
+	}
+	c.lastChange = c.now()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *observerCore) shardForKey(key string) ShardID {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.notifiedNumShards == 0 {
+		return 0
+	}
+	return c.shardFunc(key, c.notifiedNumShards)
+}
+
+func (c *observerCore) nodeForKey(key string) (Node, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if !c.hasNotified || c.notifiedNumShards == 0 {
+		return Node{}, false
+	}
+
+	id := c.shardFunc(key, c.notifiedNumShards)
+	for _, n := range c.notified {
+		if slices.Contains(n.Shards, id) {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
@@ -0,0 +1,156 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardFuncs(t *testing.T) {
+	t.Run("fnv modulo", func(t *testing.T) {
+		assert.Equal(t, ShardID(5), FNVModuloShardFunc("", 16))
+		assert.Equal(t, ShardID(4), FNVModuloShardFunc("user-1", 16))
+		assert.Equal(t, ShardID(13), FNVModuloShardFunc("user-2", 16))
+		assert.Equal(t, ShardID(6), FNVModuloShardFunc("order:12345", 16))
+	})
+
+	t.Run("xxhash modulo", func(t *testing.T) {
+		assert.Equal(t, ShardID(9), XXHashModuloShardFunc("", 16))
+		assert.Equal(t, ShardID(8), XXHashModuloShardFunc("user-1", 16))
+		assert.Equal(t, ShardID(15), XXHashModuloShardFunc("order:12345", 16))
+	})
+
+	t.Run("jump hash", func(t *testing.T) {
+		assert.Equal(t, int64(0), jumpHash(1, 1))
+		assert.Equal(t, int64(43), jumpHash(42, 57))
+		assert.Equal(t, int64(361), jumpHash(0xDEAD10CC, 666))
+		assert.Equal(t, int64(520), jumpHash(256, 1024))
+
+		assert.Equal(t, ShardID(7), JumpHashShardFunc("", 16))
+		assert.Equal(t, ShardID(13), JumpHashShardFunc("order:12345", 16))
+	})
+
+	t.Run("jump hash only moves keys to new shards", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			before := JumpHashShardFunc(key, 16)
+			after := JumpHashShardFunc(key, 17)
+			if before != after {
+				assert.Equal(t, ShardID(16), after)
+			}
+		}
+	})
+
+	t.Run("modulo follows split mapping", func(t *testing.T) {
+		resize := newShardResize(8, 16)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			before := FNVModuloShardFunc(key, 8)
+			after := FNVModuloShardFunc(key, 16)
+			assert.Contains(t, resize.Mapping[before], after)
+		}
+	})
+}
+
+func TestStandaloneObserver_Node_For_Key(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}))
+
+	factory := curator.NewFakeClientFactory(store, observer1)
+	observer := NewObserver(parentPath, numShards, func(event ChangeEvent) {},
+		WithObserverShardFunc(XXHashModuloShardFunc),
+	)
+	factory.Start(observer.GetCurator())
+
+	_, ok := observer.NodeForKey("user-1")
+	assert.Equal(t, false, ok)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, observer1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	// xxhash of "user-1" modulo 8 = 0
+	assert.Equal(t, ShardID(0), observer.ShardForKey("user-1"))
+	node, ok := observer.NodeForKey("user-1")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "node01", node.ID)
+
+	// xxhash of "order:12345" modulo 8 = 7
+	assert.Equal(t, ShardID(7), observer.ShardForKey("order:12345"))
+	node, ok = observer.NodeForKey("order:12345")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "node02", node.ID)
+	assert.Equal(t, "node02-addr:4001", node.Address)
+}
+
+func TestSharding_Shard_For_Key__Dynamic_Num_Shards(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithDynamicNumShards())
+	s2 := startSharding(store, client2, "node02",
+		WithLogger(&noopLogger{}),
+		WithDynamicNumShards(),
+		WithShardingObserver(func(event ChangeEvent) {}),
+	)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, ShardID(5), s1.ShardForKey(""))
+	assert.Equal(t, ShardID(5), s2.ShardForKey(""))
+
+	_, ok := s1.NodeForKey("")
+	assert.Equal(t, false, ok)
+
+	node, ok := s2.NodeForKey("")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "node02", node.ID)
+
+	s1.SetNumShards(16, func(err error) {})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, ShardID(5), s1.ShardForKey(""))
+	assert.Equal(t, ShardID(13), s2.ShardForKey("user-2"))
+	assert.Equal(t, ShardID(13), s1.ShardForKey("user-2"))
+
+	node, ok = s2.NodeForKey("user-2")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "node02", node.ID)
+}
+
+func TestSharding_Shard_For_Key__Zero_Num_Shards(t *testing.T) {
+	s := New(parentPath, "node01", 0, "node01-addr:4001")
+	assert.Equal(t, ShardID(0), s.ShardForKey("user-1"))
+
+	observer := NewObserver(parentPath, 0, func(event ChangeEvent) {})
+	assert.Equal(t, ShardID(0), observer.ShardForKey("user-1"))
+}
+
+func TestSharding_Jump_Hash__With_Dynamic_Num_Shards(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid shard func with dynamic number of shards", func() {
+		New(parentPath, "node01", numShards, "node01-addr:4001",
+			WithDynamicNumShards(), WithNonResizableShardFunc(JumpHashShardFunc),
+		)
+	})
+	assert.PanicsWithValue(t, "Invalid shard func with dynamic number of shards", func() {
+		NewObserver(parentPath, numShards, func(event ChangeEvent) {},
+			WithObserverDynamicNumShards(), WithObserverNonResizableShardFunc(JumpHashShardFunc),
+		)
+	})
+
+	assert.NotPanics(t, func() {
+		New(parentPath, "node01", numShards, "node01-addr:4001",
+			WithDynamicNumShards(), WithShardFunc(XXHashModuloShardFunc),
+		)
+	})
+}
@@ -0,0 +1,150 @@
+package sharding
+
+import (
+	"sync"
+	"time"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+// sessionLease considers the zookeeper session of the current node lost when none of the requests
+// sent within the timeout has succeeded, see WithSessionLossTimeout.
+// The checks run on timer goroutines, because the responses of a broken connection may never come.
+type sessionLease struct {
+	parent  string
+	timeout time.Duration
+	onLost  func()
+
+	now       func() time.Time
+	afterFunc func(d time.Duration, fn func())
+
+	// suspend and onLost are called on the timer goroutine of the check when the session is considered lost,
+	// resume is called on the goroutine of the zookeeper client when a request has succeeded again.
+	// They are called after mut is released, so start and stop never wait for them.
+	suspend func()
+	resume  func(sess *curator.Session)
+
+	// notifyMut serializes the changes of lost together with the calls of suspend, onLost and resume,
+	// so they are called in the same order as the changes
+	notifyMut sync.Mutex
+
+	mut sync.Mutex
+
+	// seq is increased on every new zookeeper session, for stopping the checks of previous sessions
+	seq int
+
+	// lastContact is the time of sending the latest request that has succeeded,
+	// the zookeeper server has NOT expired the session before that time
+	lastContact time.Time
+	lost        bool
+}
+
+func newSessionLease(parent string, timeout time.Duration, onLost func()) *sessionLease {
+	if onLost == nil {
+		onLost = func() {}
+	}
+	return &sessionLease{
+		parent:  parent,
+		timeout: timeout,
+		onLost:  onLost,
+
+		suspend: func() {},
+		resume:  func(sess *curator.Session) {},
+	}
+}
+
+func (l *sessionLease) getCheckInterval() time.Duration {
+	return l.timeout / 4
+}
+
+func (l *sessionLease) start(sess *curator.Session) {
+	l.mut.Lock()
+	l.seq++
+	seq := l.seq
+	l.lastContact = l.now()
+	l.lost = false
+	l.mut.Unlock()
+
+	l.scheduleCheck(sess, seq)
+}
+
+// stop stops the checks of the current session
+func (l *sessionLease) stop() {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.seq++
+}
+
+func (l *sessionLease) scheduleCheck(sess *curator.Session, seq int) {
+	l.afterFunc(l.getCheckInterval(), func() {
+		if !l.check(seq) {
+			return
+		}
+		l.ping(sess, seq)
+		l.scheduleCheck(sess, seq)
+	})
+}
+
+// check returns false if the session has changed
+func (l *sessionLease) check(seq int) bool {
+	l.notifyMut.Lock()
+	defer l.notifyMut.Unlock()
+
+	valid, lost := l.checkLost(seq)
+	if lost {
+		l.suspend()
+		l.onLost()
+	}
+	return valid
+}
+
+// checkLost returns whether the session is still the same, and whether it has just been considered lost
+func (l *sessionLease) checkLost(seq int) (valid bool, lost bool) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.seq != seq {
+		return false, false
+	}
+	if l.lost || l.now().Sub(l.lastContact) < l.timeout {
+		return true, false
+	}
+	l.lost = true
+	return true, true
+}
+
+// ping sends a cheap request, errors are ignored because the session is considered lost by the checks
+func (l *sessionLease) ping(sess *curator.Session, seq int) {
+	sentAt := l.now()
+	sess.GetClient().Children(l.parent, func(_ zk.ChildrenResponse, err error) {
+		if err != nil {
+			return
+		}
+
+		l.notifyMut.Lock()
+		defer l.notifyMut.Unlock()
+
+		if l.onContact(seq, sentAt) {
+			l.resume(sess)
+		}
+	})
+}
+
+// onContact records a succeeded request, it returns true if the session was considered lost
+func (l *sessionLease) onContact(seq int, sentAt time.Time) bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.seq != seq {
+		return false
+	}
+	if sentAt.After(l.lastContact) {
+		l.lastContact = sentAt
+	}
+	if !l.lost {
+		return false
+	}
+	l.lost = false
+	return true
+}
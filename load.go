@@ -0,0 +1,265 @@
+package sharding
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+// LoadReportFunc returns the current loads of the shards served by the current node.
+// It is called on a timer goroutine, concurrently with the other callbacks, see WithLoadReporting
+type LoadReportFunc func() map[ShardID]ShardLoad
+
+func (s *Sharding) getLoadsPath() string {
+	return s.parentPath + loadsZNodeName
+}
+
+// startLoadReporting publishes the loads of the current node after every interval,
+// until the session changes or Shutdown has finished
+func (s *Sharding) startLoadReporting(sess *curator.Session) {
+	s.scheduleLoadReport(sess, s.sessionSeq)
+}
+
+// The loads are collected on the timer goroutine, then written on the goroutine of the session by runOnSession.
+func (s *Sharding) scheduleLoadReport(sess *curator.Session, seq int) {
+	s.afterFunc(s.loadReportInterval, func() {
+		if s.isShutdownFinished() {
+			return
+		}
+		data := loadData{
+			Shards: s.loadReportFunc(),
+		}
+		if s.dynamicNumShards {
+			data.NumShards = s.getKnownNumShards()
+		}
+		runOnSession(sess, s.parentPath, func(sess *curator.Session) {
+			if s.sessionSeq != seq {
+				return
+			}
+			s.publishLoads(sess, seq, data.marshalJSON())
+		})
+	})
+}
+
+// publishLoads writes the ephemeral load znode of the current node, with the version read right before writing.
+// Failed writes are not retried, the next report will overwrite them anyway.
+func (s *Sharding) publishLoads(sess *curator.Session, seq int, data []byte) {
+	client := sess.GetClient()
+	pathVal := s.getLoadsPath() + "/" + s.nodeID
+
+	client.Get(pathVal, func(resp zk.GetResponse, err error) {
+		if s.sessionSeq != seq {
+			return
+		}
+		if errors.Is(err, zk.ErrNoNode) {
+			client.Create(pathVal, data, zk.FlagEphemeral, func(resp zk.CreateResponse, err error) {
+				s.onLoadsPublished(sess, seq, err)
+			})
+			return
+		}
+		if err != nil {
+			s.onLoadsPublished(sess, seq, err)
+			return
+		}
+		client.Set(pathVal, data, resp.Stat.Version, func(resp zk.SetResponse, err error) {
+			s.onLoadsPublished(sess, seq, err)
+		})
+	})
+}
+
+func (s *Sharding) onLoadsPublished(sess *curator.Session, seq int, err error) {
+	if s.sessionSeq != seq {
+		return
+	}
+	if err != nil && !isOneOfErrors(err,
+		zk.ErrConnectionClosed, zk.ErrNodeExists, zk.ErrNoNode, zk.ErrBadVersion,
+	) {
+		// a skipped report is the same as a failed write, only retrying delays the next report
+		pathVal := s.getLoadsPath() + "/" + s.nodeID
+		if !s.handleError(sess, OpSet, pathVal, err, s.publishNextLoads(seq), true) {
+			return
+		}
+	}
+	s.scheduleLoadReport(sess, seq)
+}
+
+func (s *Sharding) publishNextLoads(seq int) func(sess *curator.Session) {
+	return func(sess *curator.Session) {
+		if s.sessionSeq != seq {
+			return
+		}
+		s.scheduleLoadReport(sess, seq)
+	}
+}
+
+// ========================================
+// Leader Logic
+// ========================================
+
+// listLoadReports reads the load reports of all nodes as a part of listAssignNodes.
+// Load znodes are NOT watched, because they change on every report,
+// instead they are read again after every report interval by scheduleLoadRefresh.
+func (s *Sharding) listLoadReports(sess *curator.Session, counter *callbackCounter) {
+	if s.loadReportFunc == nil {
+		return
+	}
+
+	finish := counter.begin()
+	sess.GetClient().Children(s.getLoadsPath(), func(resp zk.ChildrenResponse, err error) {
+		defer finish()
+
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				counter.addRetry(sess, s.listAssignNodes)
+				return
+			}
+			s.handleError(sess, OpChildren, s.getLoadsPath(), err, s.listAssignNodes, false)
+			counter.cancel()
+			return
+		}
+		s.getLoadReports(sess, resp.Children, counter, s.listAssignNodes)
+	})
+}
+
+// getLoadReports reads the load reports into a new map, which replaces the current one
+// only after all reports are read, so a partial result is never used by the shard allocation
+func (s *Sharding) getLoadReports(
+	sess *curator.Session, nodes []string,
+	counter *callbackCounter, retry func(sess *curator.Session),
+) {
+	loads := map[string]loadData{}
+	replaced := newCallbackCounter(func() {
+		s.state.loads = loads
+	})
+
+	done := replaced.begin()
+	for _, tmpNodeID := range nodes {
+		nodeID := tmpNodeID
+
+		pathVal := s.getLoadsPath() + "/" + nodeID
+
+		finish := counter.begin()
+		replace := replaced.begin()
+		sess.GetClient().Get(pathVal, func(resp zk.GetResponse, err error) {
+			defer finish()
+			defer replace()
+
+			if err != nil {
+				if errors.Is(err, zk.ErrNoNode) {
+					return
+				}
+				replaced.cancel()
+				if errors.Is(err, zk.ErrConnectionClosed) {
+					counter.addRetry(sess, retry)
+					return
+				}
+				s.handleError(sess, OpGet, pathVal, err, retry, false)
+				counter.cancel()
+				return
+			}
+
+			var data loadData
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				// a skipped load report is treated as not reported
+				if !s.handleError(sess, OpUnmarshal, pathVal, err, retry, true) {
+					replaced.cancel()
+					counter.cancel()
+				}
+				return
+			}
+			loads[nodeID] = data
+		})
+	}
+	done()
+}
+
+// scheduleLoadRefresh reads the load reports again after the report interval and runs the shard allocation.
+// Same as scheduleRebalance, the timer callback is moved to the goroutine of the session by runOnSession,
+// and is ignored if the leader session has changed, or Shutdown has finished.
+func (s *Sharding) scheduleLoadRefresh(sess *curator.Session) {
+	seq := s.sessionSeq
+	state := s.state
+	s.afterFunc(s.loadReportInterval, func() {
+		if s.isShutdownFinished() {
+			return
+		}
+		runOnSession(sess, s.parentPath, func(sess *curator.Session) {
+			if s.sessionSeq != seq || s.state != state {
+				return
+			}
+			s.refreshLoadReports(sess, seq, state)
+		})
+	})
+}
+
+func (s *Sharding) refreshLoadReports(sess *curator.Session, seq int, state *sessionState) {
+	isOutdated := func() bool {
+		return s.sessionSeq != seq || s.state != state
+	}
+
+	var retry func(sess *curator.Session)
+	retry = func(sess *curator.Session) {
+		s.refreshLoadReports(sess, seq, state)
+	}
+
+	sess.GetClient().Children(s.getLoadsPath(), func(resp zk.ChildrenResponse, err error) {
+		if isOutdated() {
+			return
+		}
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(retry)
+				return
+			}
+			s.handleError(sess, OpChildren, s.getLoadsPath(), err, retry, false)
+			return
+		}
+
+		counter := newCallbackCounter(func() {
+			if isOutdated() {
+				return
+			}
+			s.startHandleNodeChanges(sess)
+			s.scheduleLoadRefresh(sess)
+		})
+
+		fn := counter.begin()
+		s.getLoadReports(sess, resp.Children, counter, retry)
+		fn()
+	})
+}
+
+// getShardLoads merges the load reports of nodes. When a shard is reported by many nodes,
+// e.g. right after it was moved, the report of its current owner is used.
+func (s *Sharding) getShardLoads() map[ShardID]ShardLoad {
+	if s.loadReportFunc == nil {
+		return nil
+	}
+
+	owners := map[ShardID]string{}
+	for nodeID, assign := range s.state.currentAssignMap {
+		for _, id := range assign.shards {
+			owners[id] = nodeID
+		}
+	}
+
+	result := map[ShardID]ShardLoad{}
+	for _, nodeID := range getKeys(s.state.loads) {
+		data := s.state.loads[nodeID]
+		if data.NumShards != 0 && data.NumShards != s.state.numShards {
+			continue
+		}
+		for id, load := range data.Shards {
+			if id >= s.state.numShards {
+				continue
+			}
+			if _, existed := result[id]; existed && owners[id] != nodeID {
+				continue
+			}
+			result[id] = load
+		}
+	}
+	return result
+}
@@ -0,0 +1,302 @@
+package sharding
+
+import (
+	"cmp"
+	"slices"
+)
+
+// LoadCostFunc converts the load of a shard to a single number used for balancing, e.g. its CPU usage
+type LoadCostFunc func(load ShardLoad) float64
+
+// NewLoadAwareAllocator returns an allocator that balances the total costs of shards between nodes,
+// proportionally to their weights, instead of the numbers of shards.
+// Shards without load reports are counted with the average cost of the reported shards.
+// It keeps the current shards of nodes, and only moves shards away from a node
+// while its total cost exceeds its fair share by more than the tolerance, e.g. 0.1 for 10%.
+// Zones are NOT considered. Without any load reports, it behaves like NewDefaultAllocator.
+func NewLoadAwareAllocator(cost LoadCostFunc, tolerance float64) Allocator {
+	if cost == nil {
+		panic("Invalid load cost func")
+	}
+	if tolerance < 0 {
+		panic("Invalid load tolerance")
+	}
+	return &loadAwareAllocator{
+		cost:      cost,
+		tolerance: tolerance,
+	}
+}
+
+type loadAwareAllocator struct {
+	cost      LoadCostFunc
+	tolerance float64
+}
+
+func (a *loadAwareAllocator) Allocate(input AllocateInput) map[string][]ShardID {
+	if len(input.Loads) == 0 || len(input.Nodes) == 0 {
+		return NewDefaultAllocator().Allocate(input)
+	}
+
+	return balanceByCosts(input, a.computeCosts(input), a.tolerance)
+}
+
+// balanceByCosts keeps the current shards, assigns the free shards to the least loaded nodes,
+// then moves shards from the most loaded nodes until they are within the tolerance
+func balanceByCosts(input AllocateInput, costs []float64, tolerance float64) map[string][]ShardID {
+	b := newLoadBalancer(input, costs)
+	b.assignPinnedShards(input.Pinned)
+	b.keepCurrentShards(input.Current)
+	b.assignFreeShards()
+	b.moveShards(tolerance)
+	return b.getResult()
+}
+
+// computeShardWeightCosts returns the static weight of each shard as its cost, indexed by shard id
+func computeShardWeightCosts(input AllocateInput) []float64 {
+	costs := make([]float64, input.NumShards)
+	for id := range costs {
+		costs[id] = float64(getShardWeight(input.ShardWeights, ShardID(id)))
+	}
+	return costs
+}
+
+func getShardWeight(weights map[ShardID]uint32, id ShardID) uint32 {
+	w, ok := weights[id]
+	if !ok {
+		return 1
+	}
+	return w
+}
+
+// computeCosts returns the cost of each shard, indexed by shard id
+func (a *loadAwareAllocator) computeCosts(input AllocateInput) []float64 {
+	costs := make([]float64, input.NumShards)
+	reported := make([]bool, input.NumShards)
+
+	var total float64
+	var numReported int
+	for id, load := range input.Loads {
+		if id >= input.NumShards {
+			continue
+		}
+		costs[id] = a.cost(load)
+		reported[id] = true
+		total += costs[id]
+		numReported++
+	}
+
+	var average float64
+	if numReported > 0 {
+		average = total / float64(numReported)
+	}
+	for id := range costs {
+		if !reported[id] {
+			costs[id] = average
+		}
+	}
+	return costs
+}
+
+type loadBalancer struct {
+	nodes []AllocateNode
+	costs []float64
+
+	// owners is the index of the node holding each shard, or freeShardOwner or reservedShardOwner
+	owners []int
+	pinned map[ShardID]struct{}
+
+	loads  []float64
+	counts []int
+}
+
+const (
+	freeShardOwner     = -1
+	reservedShardOwner = -2
+)
+
+func newLoadBalancer(input AllocateInput, costs []float64) *loadBalancer {
+	owners := make([]int, input.NumShards)
+	for id := range owners {
+		owners[id] = freeShardOwner
+	}
+	for _, id := range input.Reserved {
+		if id < input.NumShards {
+			owners[id] = reservedShardOwner
+		}
+	}
+
+	return &loadBalancer{
+		nodes:  input.Nodes,
+		costs:  costs,
+		owners: owners,
+		pinned: map[ShardID]struct{}{},
+		loads:  make([]float64, len(input.Nodes)),
+		counts: make([]int, len(input.Nodes)),
+	}
+}
+
+func (b *loadBalancer) assign(index int, id ShardID) {
+	b.owners[id] = index
+	b.loads[index] += b.costs[id]
+	b.counts[index]++
+}
+
+func (b *loadBalancer) unassign(id ShardID) {
+	index := b.owners[id]
+	b.owners[id] = freeShardOwner
+	b.loads[index] -= b.costs[id]
+	b.counts[index]--
+}
+
+// ratio is the total cost of the node per unit of weight
+func (b *loadBalancer) ratio(index int) float64 {
+	return b.loads[index] / float64(b.nodes[index].Weight)
+}
+
+func (b *loadBalancer) indexOf(nodeID string) int {
+	for index, node := range b.nodes {
+		if node.ID == nodeID {
+			return index
+		}
+	}
+	return -1
+}
+
+func (b *loadBalancer) assignPinnedShards(pinned map[ShardID]string) {
+	for _, id := range getKeys(pinned) {
+		index := b.indexOf(pinned[id])
+		if index < 0 || int(id) >= len(b.owners) || b.owners[id] != freeShardOwner {
+			continue
+		}
+		b.assign(index, id)
+		b.pinned[id] = struct{}{}
+	}
+}
+
+func (b *loadBalancer) keepCurrentShards(current map[string][]ShardID) {
+	for index, node := range b.nodes {
+		for _, id := range current[node.ID] {
+			if int(id) >= len(b.owners) || b.owners[id] != freeShardOwner {
+				continue
+			}
+			b.assign(index, id)
+		}
+	}
+}
+
+// lessLoaded returns true if the node at index x should receive a shard with the cost before the node at index y
+func (b *loadBalancer) lessLoaded(x, y int, cost float64) bool {
+	ratioX := (b.loads[x] + cost) / float64(b.nodes[x].Weight)
+	ratioY := (b.loads[y] + cost) / float64(b.nodes[y].Weight)
+	if ratioX != ratioY {
+		return ratioX < ratioY
+	}
+	// compare counts[x] / weight[x] with counts[y] / weight[y]
+	return uint64(b.counts[x])*uint64(b.nodes[y].Weight) < uint64(b.counts[y])*uint64(b.nodes[x].Weight)
+}
+
+// assignFreeShards assigns the free shards, from the most costly ones, to the least loaded nodes
+func (b *loadBalancer) assignFreeShards() {
+	var free []ShardID
+	for id, owner := range b.owners {
+		if owner == freeShardOwner {
+			free = append(free, ShardID(id))
+		}
+	}
+	// the order of shards with the same cost is kept by shard id
+	slices.SortStableFunc(free, func(x, y ShardID) int {
+		return cmp.Compare(b.costs[y], b.costs[x])
+	})
+
+	for _, id := range free {
+		best := 0
+		for index := 1; index < len(b.nodes); index++ {
+			if b.lessLoaded(index, best, b.costs[id]) {
+				best = index
+			}
+		}
+		b.assign(best, id)
+	}
+}
+
+// moveShards moves shards from the most loaded node to the least loaded node,
+// while the most loaded node exceeds its fair share by more than the tolerance
+// and the move decreases the maximum ratio of the two nodes.
+func (b *loadBalancer) moveShards(tolerance float64) {
+	var totalLoad float64
+	var totalWeight uint64
+	for index, node := range b.nodes {
+		totalLoad += b.loads[index]
+		totalWeight += uint64(node.Weight)
+	}
+	limit := totalLoad / float64(totalWeight) * (1 + tolerance)
+
+	maxMoves := len(b.owners) * len(b.nodes)
+	for i := 0; i < maxMoves; i++ {
+		src, dst := b.findMostAndLeastLoaded()
+		if b.ratio(src) <= limit {
+			return
+		}
+
+		id, ok := b.findShardToMove(src, dst)
+		if !ok {
+			return
+		}
+		b.unassign(id)
+		b.assign(dst, id)
+	}
+}
+
+func (b *loadBalancer) findMostAndLeastLoaded() (most int, least int) {
+	for index := 1; index < len(b.nodes); index++ {
+		if b.ratio(index) > b.ratio(most) {
+			most = index
+		}
+		if b.ratio(index) < b.ratio(least) {
+			least = index
+		}
+	}
+	return most, least
+}
+
+// findShardToMove returns the shard of the node src that minimizes the maximum ratio of both nodes after moved
+func (b *loadBalancer) findShardToMove(src, dst int) (ShardID, bool) {
+	srcWeight := float64(b.nodes[src].Weight)
+	dstWeight := float64(b.nodes[dst].Weight)
+
+	var result ShardID
+	found := false
+	bestRatio := b.ratio(src)
+
+	for id, owner := range b.owners {
+		if owner != src {
+			continue
+		}
+		if _, ok := b.pinned[ShardID(id)]; ok {
+			continue
+		}
+		cost := b.costs[id]
+		newRatio := max((b.loads[src]-cost)/srcWeight, (b.loads[dst]+cost)/dstWeight)
+		if newRatio < bestRatio {
+			result = ShardID(id)
+			bestRatio = newRatio
+			found = true
+		}
+	}
+	return result, found
+}
+
+func (b *loadBalancer) getResult() map[string][]ShardID {
+	result := make(map[string][]ShardID, len(b.nodes))
+	for _, node := range b.nodes {
+		result[node.ID] = nil
+	}
+	for id, owner := range b.owners {
+		if owner < 0 {
+			continue
+		}
+		nodeID := b.nodes[owner].ID
+		result[nodeID] = append(result[nodeID], ShardID(id))
+	}
+	return result
+}
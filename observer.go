@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"slices"
+	"sync"
+	"time"
 
 	"github.com/QuangTung97/zk"
 	"github.com/QuangTung97/zk/curator"
@@ -12,16 +14,61 @@ import (
 
 // Node information when observing changes
 type Node struct {
-	ID      string
-	Address string
-	Shards  []ShardID
-	MZxid   int64 // updated zxid
+	ID       string
+	Address  string
+	Weight   uint32    // the weight of WithNodeWeight, 1 if the node is not configured with it
+	Topology Topology  // empty if the node is not configured with WithNodeTopology
+	Shards   []ShardID // shards that this node is the primary
+	Replicas []ShardID // shards that this node is a replica, only when using WithReplicationFactor
+	MZxid    int64     // updated zxid
+
+	// TotalWeight is the summed weights of Shards, zero if the observer is not configured with shard weights
+	TotalWeight uint64
+
+	// Epochs are the fencing epochs of Shards, nil if the observer is not configured with fencing epochs.
+	// See Sharding.ShardEpoch.
+	Epochs map[ShardID]int64
+
+	// ActiveShards are shards in Shards that the node has confirmed serving, right after acquiring them,
+	// or after Sharding.MarkShardActive with WithShardActivation. PendingShards are the remaining ones.
+	// Both are empty if the observer is not configured to read shard status.
+	ActiveShards  []ShardID
+	PendingShards []ShardID
+}
+
+// ShardRole is the role of a node for a shard
+type ShardRole int
+
+const (
+	// ShardRolePrimary is the role of the primary owner of a shard
+	ShardRolePrimary ShardRole = iota + 1
+	// ShardRoleReplica is the role of a replica of a shard
+	ShardRoleReplica
+)
+
+// RoleOf returns the role of the node for the shard, returns false if the shard is NOT assigned to the node
+func (n Node) RoleOf(shardID ShardID) (ShardRole, bool) {
+	if slices.Contains(n.Shards, shardID) {
+		return ShardRolePrimary, true
+	}
+	if slices.Contains(n.Replicas, shardID) {
+		return ShardRoleReplica, true
+	}
+	return 0, false
 }
 
 // ChangeEvent happens every time zookeeper state changed
 type ChangeEvent struct {
 	Old []Node
 	New []Node
+
+	// Resize is not nil if the number of shards of New is different from Old,
+	// only when using WithDynamicNumShards or WithObserverDynamicNumShards
+	Resize *ShardResize
+
+	// Group is the name of the shard group that the event is for, empty for the default group.
+	// Events of different groups are notified separately, see WithShardGroup.
+	Group string
 }
 
 // ObserverFunc is the callback function of observer
@@ -29,46 +76,159 @@ type ObserverFunc func(event ChangeEvent)
 
 // Observer is for standalone observer, without participating on sharding allocation
 type Observer struct {
-	curator *curator.Curator
+	curator *sessionRunner
+	core    *observerCore
+
+	mut       sync.Mutex
+	configErr error
 }
 
 // NewObserver creates an Observer
-func NewObserver(parentPath string, numShards ShardID, observerFunc ObserverFunc) *Observer {
-	controller := newContainerNodeController(parentPath, "", "")
+func NewObserver(
+	parentPath string, numShards ShardID, observerFunc ObserverFunc,
+	options ...ObserverOption,
+) *Observer {
+	var opts observerOptions
+	for _, fn := range options {
+		fn(&opts)
+	}
+	if opts.dynamicNumShards && opts.nonResizable {
+		panic("Invalid shard func with dynamic number of shards")
+	}
+
+	var extraNames []string
+	if opts.shardStatus {
+		extraNames = append(extraNames, statusZNodeName)
+	}
+	extraNames = append(extraNames, getGroupContainerNames(opts.groups)...)
+
+	errs := newErrorHandler(parentPath, opts.errorFunc, opts.errorRetryDelay)
+
+	controller := newContainerNodeController(parentPath, "", nodeData{}, extraNames...)
+	controller.errs = errs
+	if opts.dynamicNumShards {
+		controller.config = configData{NumShards: numShards}.marshalJSON()
+	}
+
 	core := newObserverCore(parentPath, numShards, observerFunc)
-	return &Observer{
-		curator: curator.NewChain(
-			controller.onStart,
-			func(sess *curator.Session, _ func(sess *curator.Session)) {
-				core.onStart(sess)
-			},
-		),
+	core.shardStatus = opts.shardStatus
+	core.dynamicNumShards = opts.dynamicNumShards
+	if opts.shardFunc != nil {
+		core.shardFunc = opts.shardFunc
+	}
+	core.shardWeights = opts.shardWeights
+	core.fencingEpochs = opts.fencingEpochs
+	core.errs = errs
+
+	var groupCores []*observerCore
+	for _, g := range opts.groups {
+		c := newGroupObserverCore(parentPath, g, observerFunc)
+		c.errs = errs
+		groupCores = append(groupCores, c)
+	}
+
+	o := &Observer{core: core}
+	if opts.configValidation {
+		controller.checkConfig = func(config *configData) error {
+			err := validateObserverConfig(config, numShards, opts.dynamicNumShards)
+			o.mut.Lock()
+			o.configErr = err
+			o.mut.Unlock()
+			return err
+		}
 	}
+
+	o.curator = newSessionRunner(curator.NewChain(
+		func(sess *curator.Session, next func(sess *curator.Session)) {
+			errs.onSessionStart()
+			controller.onStart(sess, next)
+		},
+		func(sess *curator.Session, _ func(sess *curator.Session)) {
+			core.onStart(sess)
+			for _, c := range groupCores {
+				c.onStart(sess)
+			}
+		},
+	))
+	errs.restartSession = o.curator.restart
+	return o
 }
 
 // GetCurator ...
-func (o *Observer) GetCurator() *curator.Curator {
+func (o *Observer) GetCurator() curator.SessionRunner {
 	return o.curator
 }
 
+// ConfigError returns the error of validating the config znode in the latest zookeeper session,
+// the observer does NOT notify while it is not nil.
+// Always nil if not configured with WithObserverConfigValidation.
+func (o *Observer) ConfigError() error {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	return o.configErr
+}
+
 // ========================================
 // Observer Core Logic
 // ========================================
 
 type observerNodeData struct {
-	data   nodeData
-	shards []ShardID
-	mzxid  int64
+	data     nodeData
+	shards   []ShardID
+	replicas []ShardID
+	mzxid    int64
+	epochs   map[ShardID]int64
+
+	activeShards []ShardID
+	statusMzxid  int64
+
+	// numShards is the number of shards in the assign znode, only with dynamic number of shards
+	numShards ShardID
 }
 
 type observerCore struct {
 	parent       string
 	numShards    ShardID
 	observerFunc ObserverFunc
+	shardStatus  bool
+
+	dynamicNumShards bool
+	shardWeights     map[ShardID]uint32
+	fencingEpochs    bool
+
+	// group is the name of the observed shard group, empty for the default group
+	group string
+
+	errs *errorHandler
 
 	// state data
 	oldNotify []Node
 	nodes     map[string]*observerNodeData
+
+	// assignsListed is true after the children of the assigns znode are listed,
+	// assignsFetching is the number of in-flight requests reading assign znodes
+	assignsListed   bool
+	assignsFetching int
+
+	configFetched bool
+	resize        *ShardResize
+
+	// the latest event, for answering queries from other goroutines
+	mut               sync.Mutex
+	shardFunc         ShardFunc
+	hasNotified       bool
+	notified          []Node
+	notifiedNumShards ShardID
+
+	// ready is true after the first event with all shards assigned, changed is closed and replaced on every event.
+	// settled is false while some shards are not granted to any node or are still pending.
+	// afterFunc is used for the duration of WaitStable.
+	now        func() time.Time
+	afterFunc  func(d time.Duration, fn func())
+	ready      bool
+	settled    bool
+	changed    chan struct{}
+	lastChange time.Time
 }
 
 func newObserverCore(parent string, numShards ShardID, observerFunc ObserverFunc) *observerCore {
@@ -76,17 +236,43 @@ func newObserverCore(parent string, numShards ShardID, observerFunc ObserverFunc
 		parent:       parent,
 		numShards:    numShards,
 		observerFunc: observerFunc,
+
+		errs: newErrorHandler(parent, nil, 0),
+
+		shardFunc:         FNVModuloShardFunc,
+		notifiedNumShards: numShards,
+
+		now: time.Now,
+		afterFunc: func(d time.Duration, fn func()) {
+			time.AfterFunc(d, fn)
+		},
+		changed: make(chan struct{}),
 	}
 }
 
+func (c *observerCore) handleError(
+	sess *curator.Session, op string, pathVal string, err error,
+	retry func(sess *curator.Session), canSkip bool,
+) bool {
+	return c.errs.handle(sess, &UnexpectedError{Op: op, Path: pathVal, Err: err}, retry, canSkip)
+}
+
 func (c *observerCore) initState() {
 	c.nodes = map[string]*observerNodeData{}
+	// assignsFetching is NOT reset, requests of the previous session are completed with errors
+	c.assignsListed = false
 }
 
 func (c *observerCore) onStart(sess *curator.Session) {
 	c.initState()
 	c.listNodes(sess)
 	c.listAssigns(sess)
+	if c.shardStatus {
+		c.listStatus(sess)
+	}
+	if c.dynamicNumShards {
+		c.watchConfig(sess)
+	}
 }
 
 func (c *observerCore) listNodes(sess *curator.Session) {
@@ -97,7 +283,8 @@ func (c *observerCore) listNodes(sess *curator.Session) {
 					sess.AddRetry(c.listNodes)
 					return
 				}
-				panic(err)
+				c.handleError(sess, OpChildren, c.parent+nodeZNodeName, err, c.listNodes, false)
+				return
 			}
 			c.handleNodesChildren(sess, resp)
 		},
@@ -109,15 +296,23 @@ func (c *observerCore) listNodes(sess *curator.Session) {
 	)
 }
 
+func (c *observerCore) getAssignsPath() string {
+	if len(c.group) > 0 {
+		return c.parent + getGroupAssignsName(c.group)
+	}
+	return c.parent + assignZNodeName
+}
+
 func (c *observerCore) listAssigns(sess *curator.Session) {
-	sess.GetClient().ChildrenW(c.parent+assignZNodeName,
+	sess.GetClient().ChildrenW(c.getAssignsPath(),
 		func(resp zk.ChildrenResponse, err error) {
 			if err != nil {
 				if errors.Is(err, zk.ErrConnectionClosed) {
 					sess.AddRetry(c.listAssigns)
 					return
 				}
-				panic(err)
+				c.handleError(sess, OpChildren, c.getAssignsPath(), err, c.listAssigns, false)
+				return
 			}
 			c.handleAssignsChildren(sess, resp)
 		},
@@ -160,20 +355,31 @@ func (c *observerCore) handleNodesChildren(sess *curator.Session, resp zk.Childr
 }
 
 func (c *observerCore) getNodeData(sess *curator.Session, node string) {
-	sess.GetClient().Get(c.parent+nodeZNodeName+"/"+node, func(resp zk.GetResponse, err error) {
+	pathVal := c.parent + nodeZNodeName + "/" + node
+	retry := func(sess *curator.Session) {
+		c.getNodeData(sess, node)
+	}
+
+	sess.GetClient().Get(pathVal, func(resp zk.GetResponse, err error) {
 		if err != nil {
 			if errors.Is(err, zk.ErrConnectionClosed) {
-				sess.AddRetry(func(sess *curator.Session) {
-					c.getNodeData(sess, node)
-				})
+				sess.AddRetry(retry)
 				return
 			}
 			if errors.Is(err, zk.ErrNoNode) {
 				return
 			}
-			panic(err)
+			c.handleError(sess, OpGet, pathVal, err, retry, true)
+			return
 		}
-		c.handleNodeData(node, resp)
+
+		var data nodeData
+		if err := json.Unmarshal(resp.Data, &data); err != nil {
+			// a skipped node is ignored
+			c.handleError(sess, OpUnmarshal, pathVal, err, retry, true)
+			return
+		}
+		c.handleNodeData(node, data)
 	})
 }
 
@@ -184,7 +390,7 @@ func (c *observerCore) cleanUpUnusedNodes(newChildren []string, handler func(n *
 	for _, nodeID := range checkNodes {
 		info := c.getNode(nodeID)
 		handler(info)
-		if len(info.data.Address) == 0 && info.mzxid == 0 {
+		if len(info.data.Address) == 0 && info.mzxid == 0 && info.statusMzxid == 0 {
 			deletedNodes = append(deletedNodes, nodeID)
 		}
 	}
@@ -207,6 +413,8 @@ func (c *observerCore) handleAssignsChildren(sess *curator.Session, resp zk.Chil
 	c.cleanUpUnusedNodes(resp.Children, func(n *observerNodeData) {
 		n.mzxid = 0
 	})
+	c.assignsListed = true
+	c.notifyObserver()
 }
 
 type shardAssign struct {
@@ -224,17 +432,66 @@ func getKeys[K cmp.Ordered, V any](m map[K]V) []K {
 }
 
 func (c *observerCore) notifyObserver() {
-	shardAlloc := map[ShardID]shardAssign{}
+	if c.dynamicNumShards && !c.configFetched {
+		return
+	}
 
-	nodeIDList := getKeys(c.nodes)
+	shardAlloc := c.computeShardAlloc()
+	if len(shardAlloc) < int(c.numShards) && !c.isClusterEmpty() {
+		// some shards are not granted to any node, e.g. during a two-phase handoff
+		c.setSettled(false)
+		return
+	}
 
-	for _, nodeID := range nodeIDList {
-		info := c.nodes[nodeID]
+	newList := c.buildNodeList(shardAlloc)
+	c.setSettled(!hasPendingShards(newList))
 
-		if len(info.data.Address) == 0 {
-			continue
+	oldList := c.oldNotify
+	if slices.EqualFunc(oldList, newList, nodeEqual) {
+		return
+	}
+
+	c.oldNotify = slices.Clone(newList)
+	c.setNotified(slices.Clone(newList))
+	resize := c.resize
+	c.resize = nil
+	c.observerFunc(ChangeEvent{
+		Old:    oldList,
+		New:    newList,
+		Resize: resize,
+		Group:  c.group,
+	})
+}
+
+// isClusterEmpty returns true if there are no assign znodes, e.g. when no nodes exist,
+// then all shards are unassigned and the observer notifies an event with an empty list of nodes
+func (c *observerCore) isClusterEmpty() bool {
+	if !c.assignsListed || c.assignsFetching > 0 {
+		return false
+	}
+	for _, info := range c.nodes {
+		if info.mzxid > 0 {
+			return false
 		}
-		if info.mzxid <= 0 {
+	}
+	return true
+}
+
+// isNodeAssignable returns true if both the node znode and the assign znode of the node have been fetched
+func (c *observerCore) isNodeAssignable(info *observerNodeData) bool {
+	if len(info.data.Address) == 0 || info.mzxid <= 0 {
+		return false
+	}
+	return c.isAssignUpToDate(info)
+}
+
+// computeShardAlloc chooses the owner of each shard, the node with the latest assign znode wins
+func (c *observerCore) computeShardAlloc() map[ShardID]shardAssign {
+	shardAlloc := map[ShardID]shardAssign{}
+
+	for _, nodeID := range getKeys(c.nodes) {
+		info := c.nodes[nodeID]
+		if !c.isNodeAssignable(info) {
 			continue
 		}
 
@@ -249,7 +506,10 @@ func (c *observerCore) notifyObserver() {
 			}
 		}
 	}
+	return shardAlloc
+}
 
+func (c *observerCore) buildNodeList(shardAlloc map[ShardID]shardAssign) []Node {
 	var newList []Node
 	for nodeID, info := range c.nodes {
 		var newShards []ShardID
@@ -260,36 +520,86 @@ func (c *observerCore) notifyObserver() {
 			}
 		}
 
-		if len(newShards) == 0 {
+		var replicas []ShardID
+		if c.isNodeAssignable(info) {
+			replicas = info.replicas
+		}
+
+		if len(newShards) == 0 && len(replicas) == 0 {
 			continue
 		}
 
+		activeShards, pendingShards := c.splitActiveShards(info, newShards)
+
 		newList = append(newList, Node{
-			ID:      nodeID,
-			Address: info.data.Address,
-			Shards:  newShards,
-			MZxid:   info.mzxid,
+			ID:       nodeID,
+			Address:  info.data.Address,
+			Weight:   info.data.getWeight(),
+			Topology: info.data.getTopology(),
+			Shards:   newShards,
+			Replicas: replicas,
+			MZxid:    info.mzxid,
+
+			TotalWeight: c.computeTotalWeight(newShards),
+			Epochs:      c.getEpochs(info, newShards),
+
+			ActiveShards:  activeShards,
+			PendingShards: pendingShards,
 		})
 	}
 
-	if len(shardAlloc) < int(c.numShards) {
-		return
-	}
-
 	slices.SortFunc(newList, func(a, b Node) int {
 		return cmp.Compare(a.ID, b.ID)
 	})
+	return newList
+}
 
-	oldList := c.oldNotify
-	if slices.EqualFunc(oldList, newList, nodeEqual) {
-		return
+func (c *observerCore) getEpochs(info *observerNodeData, shards []ShardID) map[ShardID]int64 {
+	if !c.fencingEpochs {
+		return nil
 	}
+	epochs := make(map[ShardID]int64, len(shards))
+	for _, id := range shards {
+		epochs[id] = info.epochs[id]
+	}
+	return epochs
+}
 
-	c.oldNotify = slices.Clone(newList)
-	c.observerFunc(ChangeEvent{
-		Old: oldList,
-		New: newList,
-	})
+func (c *observerCore) computeTotalWeight(shards []ShardID) uint64 {
+	if c.shardWeights == nil {
+		return 0
+	}
+	var total uint64
+	for _, id := range shards {
+		total += uint64(getShardWeight(c.shardWeights, id))
+	}
+	return total
+}
+
+// splitActiveShards splits shards of a node into shards reported in its status znode and the others
+func (c *observerCore) splitActiveShards(
+	info *observerNodeData, shards []ShardID,
+) (activeShards []ShardID, pendingShards []ShardID) {
+	if !c.shardStatus {
+		return nil, nil
+	}
+	for _, shardID := range shards {
+		if slices.Contains(info.activeShards, shardID) {
+			activeShards = append(activeShards, shardID)
+		} else {
+			pendingShards = append(pendingShards, shardID)
+		}
+	}
+	return activeShards, pendingShards
+}
+
+// isAssignUpToDate returns false if the assign znode is computed for a different number of shards,
+// shards of such a node are ignored until the leader rewrites the assign znode
+func (c *observerCore) isAssignUpToDate(info *observerNodeData) bool {
+	if !c.dynamicNumShards || info.numShards == 0 {
+		return true
+	}
+	return info.numShards == c.numShards
 }
 
 func nodeEqual(a, b Node) bool {
@@ -299,27 +609,57 @@ func nodeEqual(a, b Node) bool {
 	if a.Address != b.Address {
 		return false
 	}
+	if a.Weight != b.Weight {
+		return false
+	}
+	if a.Topology != b.Topology {
+		return false
+	}
 	if a.MZxid != b.MZxid {
 		return false
 	}
+	if !slices.Equal(a.Replicas, b.Replicas) {
+		return false
+	}
+	if !slices.Equal(a.ActiveShards, b.ActiveShards) {
+		return false
+	}
+	if !slices.Equal(a.PendingShards, b.PendingShards) {
+		return false
+	}
 	return slices.Equal(a.Shards, b.Shards)
 }
 
 func (c *observerCore) getAssignNode(sess *curator.Session, nodeID string) {
-	sess.GetClient().GetW(c.parent+assignZNodeName+"/"+nodeID, func(resp zk.GetResponse, err error) {
+	pathVal := c.getAssignsPath() + "/" + nodeID
+	retry := func(sess *curator.Session) {
+		c.getAssignNode(sess, nodeID)
+	}
+
+	c.assignsFetching++
+	sess.GetClient().GetW(pathVal, func(resp zk.GetResponse, err error) {
+		c.assignsFetching--
+
 		if err != nil {
 			if errors.Is(err, zk.ErrConnectionClosed) {
-				sess.AddRetry(func(sess *curator.Session) {
-					c.getAssignNode(sess, nodeID)
-				})
+				sess.AddRetry(retry)
 				return
 			}
 			if errors.Is(err, zk.ErrNoNode) {
+				c.notifyObserver()
 				return
 			}
-			panic(err)
+			c.handleError(sess, OpGet, pathVal, err, retry, true)
+			return
+		}
+
+		var assignVal assignData
+		if err := json.Unmarshal(resp.Data, &assignVal); err != nil {
+			// a skipped assign znode is ignored until it is changed
+			c.handleError(sess, OpUnmarshal, pathVal, err, retry, true)
+			return
 		}
-		c.handleGetAssignData(nodeID, resp)
+		c.handleGetAssignData(nodeID, resp.Stat.Mzxid, assignVal)
 	}, func(ev zk.Event) {
 		if ev.Type == zk.EventNodeDataChanged {
 			c.getAssignNode(sess, nodeID)
@@ -330,14 +670,13 @@ func (c *observerCore) getAssignNode(sess *curator.Session, nodeID string) {
 	})
 }
 
-func (c *observerCore) handleGetAssignData(nodeID string, resp zk.GetResponse) {
+func (c *observerCore) handleGetAssignData(nodeID string, mzxid int64, assignVal assignData) {
 	n := c.getNode(nodeID)
-	n.mzxid = resp.Stat.Mzxid
-	var assignVal assignData
-	if err := json.Unmarshal(resp.Data, &assignVal); err != nil {
-		panic(err)
-	}
+	n.mzxid = mzxid
 	n.shards = assignVal.Shards
+	n.replicas = assignVal.Replicas
+	n.epochs = resolveEpochs(assignVal, mzxid)
+	n.numShards = assignVal.NumShards
 	c.notifyObserver()
 }
 
@@ -350,13 +689,90 @@ func (c *observerCore) getNode(nodeID string) *observerNodeData {
 	return n
 }
 
-func (c *observerCore) handleNodeData(nodeID string, resp zk.GetResponse) {
-	var data nodeData
-	err := json.Unmarshal(resp.Data, &data)
-	if err != nil {
-		panic(err)
-	}
+func (c *observerCore) handleNodeData(nodeID string, data nodeData) {
 	n := c.getNode(nodeID)
 	n.data = data
 	c.notifyObserver()
 }
+
+func (c *observerCore) listStatus(sess *curator.Session) {
+	sess.GetClient().ChildrenW(c.parent+statusZNodeName,
+		func(resp zk.ChildrenResponse, err error) {
+			if err != nil {
+				if errors.Is(err, zk.ErrConnectionClosed) {
+					sess.AddRetry(c.listStatus)
+					return
+				}
+				c.handleError(sess, OpChildren, c.parent+statusZNodeName, err, c.listStatus, false)
+				return
+			}
+			c.handleStatusChildren(sess, resp)
+		},
+		func(ev zk.Event) {
+			if ev.Type == zk.EventNodeChildrenChanged {
+				c.listStatus(sess)
+			}
+		},
+	)
+}
+
+func (c *observerCore) handleStatusChildren(sess *curator.Session, resp zk.ChildrenResponse) {
+	for _, child := range resp.Children {
+		n := c.getNode(child)
+		if n.statusMzxid > 0 {
+			continue
+		}
+		c.getStatusNode(sess, child)
+	}
+
+	c.cleanUpUnusedNodes(resp.Children, func(n *observerNodeData) {
+		n.statusMzxid = 0
+		n.activeShards = nil
+	})
+	c.notifyObserver()
+}
+
+func (c *observerCore) getStatusNode(sess *curator.Session, nodeID string) {
+	pathVal := c.parent + statusZNodeName + "/" + nodeID
+	retry := func(sess *curator.Session) {
+		c.getStatusNode(sess, nodeID)
+	}
+
+	sess.GetClient().GetW(pathVal, func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(retry)
+				return
+			}
+			if errors.Is(err, zk.ErrNoNode) {
+				return
+			}
+			c.handleError(sess, OpGet, pathVal, err, retry, true)
+			return
+		}
+
+		var status statusData
+		if err := json.Unmarshal(resp.Data, &status); err != nil {
+			// a skipped status znode is ignored until it is changed
+			c.handleError(sess, OpUnmarshal, pathVal, err, retry, true)
+			return
+		}
+		c.handleGetStatusData(nodeID, resp.Stat.Mzxid, status)
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDataChanged {
+			c.getStatusNode(sess, nodeID)
+		} else if ev.Type == zk.EventNodeDeleted {
+			n := c.getNode(nodeID)
+			n.statusMzxid = 0
+			n.activeShards = nil
+			c.notifyObserver()
+		}
+	})
+}
+
+func (c *observerCore) handleGetStatusData(nodeID string, mzxid int64, status statusData) {
+	n := c.getNode(nodeID)
+	n.statusMzxid = mzxid
+	n.activeShards = subtractShards(status.Shards, status.Inactive)
+	c.notifyObserver()
+}
@@ -1,16 +1,19 @@
 package sharding
 
 import (
+	"time"
+
 	"github.com/QuangTung97/zk"
 )
 
 // Option for sharding options
 type Option func(s *Sharding)
 
-// WithShardingObserver set observer function callback
+// WithShardingObserver set observer function callback.
+// If the current node is configured with WithShardStatus, the observer also reads status znodes of all nodes.
 func WithShardingObserver(fn ObserverFunc) Option {
 	return func(s *Sharding) {
-		s.obs = newObserverCore(s.parentPath, s.numShards, fn)
+		s.observerFunc = fn
 	}
 }
 
@@ -20,3 +23,393 @@ func WithLogger(l zk.Logger) Option {
 		s.logger = l
 	}
 }
+
+// WithAllocator changes the algorithm used by the leader to assign shards to nodes
+func WithAllocator(allocator Allocator) Option {
+	return func(s *Sharding) {
+		s.allocator = allocator
+	}
+}
+
+// WithNodeWeight sets the capacity of the current node, the default weight is 1.
+// The leader assigns shards to nodes proportionally to their weights.
+func WithNodeWeight(weight uint32) Option {
+	return func(s *Sharding) {
+		if weight == 0 {
+			panic("Invalid node weight")
+		}
+		s.nodeWeight = weight
+	}
+}
+
+// WithNodeTopology sets the zone, rack and host of the current node.
+// The default allocator balances shards between zones, and replicas of a shard are spread
+// to different zones, racks and hosts whenever possible.
+func WithNodeTopology(topology Topology) Option {
+	return func(s *Sharding) {
+		s.nodeTopology = &topology
+	}
+}
+
+// WithReplicationFactor sets the number of distinct nodes that each shard is assigned to, the default is 1.
+// One of these nodes is the primary of the shard, the others are its replicas.
+// If there are fewer active nodes than the replication factor, each shard is assigned to all of them.
+func WithReplicationFactor(factor int) Option {
+	return func(s *Sharding) {
+		if factor < 1 {
+			panic("Invalid replication factor")
+		}
+		s.replicationFactor = factor
+	}
+}
+
+// WithMaxMovesPerRound limits the number of shards moved between active nodes in one round of allocation.
+// The remaining moves are done in the next rounds, after the previous assignments are written.
+// Shards of removed nodes are NOT limited.
+func WithMaxMovesPerRound(n int) Option {
+	return func(s *Sharding) {
+		if n < 1 {
+			panic("Invalid max moves per round")
+		}
+		s.maxMovesPerRound = n
+	}
+}
+
+// WithMinRebalanceInterval sets the minimum duration between two rounds of allocation that move shards
+// between active nodes. Shards of removed nodes are still assigned immediately.
+func WithMinRebalanceInterval(d time.Duration) Option {
+	return func(s *Sharding) {
+		if d <= 0 {
+			panic("Invalid min rebalance interval")
+		}
+		s.minRebalanceInterval = d
+	}
+}
+
+// WithNodeGracePeriod reserves the shards of a disappeared node for the duration d,
+// so it gets them back if restarted with the same node id. Reserved shards are NOT served.
+func WithNodeGracePeriod(d time.Duration) Option {
+	return func(s *Sharding) {
+		if d <= 0 {
+			panic("Invalid node grace period")
+		}
+		s.nodeGracePeriod = d
+	}
+}
+
+// WithShardPinning makes the leader honor the shards pinned by PinShard.
+// A pinned shard is always assigned to its node while the node is active, the other shards are balanced as usual.
+// Pins to nodes that are not active are ignored.
+func WithShardPinning() Option {
+	return func(s *Sharding) {
+		s.shardPinning = true
+	}
+}
+
+// WithNodeDraining makes the leader honor the drains marked by DrainNode.
+// A draining node keeps its ephemeral znode but its shards and replicas are moved to other nodes,
+// so it can be shut down safely once its assignment is empty.
+func WithNodeDraining() Option {
+	return func(s *Sharding) {
+		s.nodeDraining = true
+	}
+}
+
+// WithDynamicNumShards stores the number of shards in the config znode, so it can be changed by SetNumShards.
+// The numShards argument of New is only used for creating the config znode, see ShardResize for the mapping.
+func WithDynamicNumShards() Option {
+	return func(s *Sharding) {
+		s.dynamicNumShards = true
+	}
+}
+
+// WithConfigValidation does nothing, every node stores its configuration in the config znode,
+// and refuses to join the cluster if it is different from the stored one, see Sharding.ConfigError.
+//
+// Deprecated: the config znode is always validated.
+func WithConfigValidation() Option {
+	return func(s *Sharding) {}
+}
+
+// WithShardFunc changes the function used by ShardForKey and NodeForKey, the default is FNVModuloShardFunc.
+// With WithDynamicNumShards, fn must follow the split and merge mapping of ShardResize,
+// otherwise use WithNonResizableShardFunc.
+func WithShardFunc(fn ShardFunc) Option {
+	return func(s *Sharding) {
+		if fn == nil {
+			panic("Invalid shard func")
+		}
+		s.shardFunc = fn
+		s.nonResizableShardFunc = false
+	}
+}
+
+// WithNonResizableShardFunc is WithShardFunc for a function that does NOT follow the split and merge mapping
+// of ShardResize when the number of shards changed, e.g. JumpHashShardFunc.
+// It can NOT be used with WithDynamicNumShards.
+func WithNonResizableShardFunc(fn ShardFunc) Option {
+	return func(s *Sharding) {
+		WithShardFunc(fn)(s)
+		s.nonResizableShardFunc = true
+	}
+}
+
+// WithLoadReporting makes the current node publish the loads returned by fn after every interval,
+// fn is called on a timer goroutine. A leader with this option passes the reports of all nodes
+// to AllocateInput.Loads, see NewLoadAwareAllocator.
+func WithLoadReporting(interval time.Duration, fn LoadReportFunc) Option {
+	return func(s *Sharding) {
+		if interval <= 0 {
+			panic("Invalid load report interval")
+		}
+		if fn == nil {
+			panic("Invalid load report func")
+		}
+		s.loadReportInterval = interval
+		s.loadReportFunc = fn
+	}
+}
+
+// WithShardWeights sets the static weights of shards, missing shards have weight 1.
+// The default allocator balances the summed weights of shards instead of their numbers.
+func WithShardWeights(weights map[ShardID]uint32) Option {
+	return func(s *Sharding) {
+		s.shardWeights = cloneShardWeights(weights)
+	}
+}
+
+// WithShardGroup adds a named shard group with its own number of shards, assigned by the same leader
+// under the /groups/<name>/assigns znode, instead of under /assigns where the children are the node ids.
+// The shards of the group held by the current node are available by Sharding.GroupShards,
+// WithGroupShardListener and WithGroupShardHandler.
+//
+// Shard groups only support the allocator, node weights and node topologies. Shard pinning, node draining,
+// two-phase handoff, replicas, shard status and the other options only apply to the default group.
+// A leaving node of Shutdown is still removed from every shard group.
+func WithShardGroup(name string, numShards ShardID, options ...GroupOption) Option {
+	return func(s *Sharding) {
+		validateShardGroup(s.groupConfigs, name, numShards)
+		config := shardGroupConfig{
+			name:      name,
+			numShards: numShards,
+		}
+		for _, fn := range options {
+			fn(&config)
+		}
+		s.groupConfigs = append(s.groupConfigs, config)
+	}
+}
+
+// WithFencingEpochs makes the leader write a fencing epoch for each primary shard to the assign znodes,
+// it does NOT change while the node keeps the shard, see Sharding.ShardEpoch and Node.Epochs.
+func WithFencingEpochs() Option {
+	return func(s *Sharding) {
+		s.fencingEpochs = true
+	}
+}
+
+// WithDuplicateNodePolicy makes the current node check that its node znode is owned by its zookeeper session,
+// instead of treating an existing node znode as its own. See DuplicateNodePolicy for the policies.
+func WithDuplicateNodePolicy(policy DuplicateNodePolicy) Option {
+	return func(s *Sharding) {
+		validateDuplicateNodePolicy(policy)
+		s.duplicatePolicy = policy
+	}
+}
+
+// WithErrorHandler sets the handler for unexpected errors of zookeeper requests and invalid data of znodes,
+// instead of panicking on the goroutine of the zookeeper client. Failed requests are sent again
+// after an exponential backoff starting from retryDelay when fn returns ErrorActionRetry,
+// see ErrorAction for the other actions.
+func WithErrorHandler(fn ErrorHandler, retryDelay time.Duration) Option {
+	return func(s *Sharding) {
+		if fn == nil {
+			panic("Invalid error handler")
+		}
+		if retryDelay <= 0 {
+			panic("Invalid error retry delay")
+		}
+		s.errorFunc = fn
+		s.errorRetryDelay = retryDelay
+	}
+}
+
+// WithTwoPhaseHandoff makes the leader grant a moved shard only after its old owner no longer reports it
+// in its status znode. The status znode is gone when the session of the old owner expires, so use
+// WithSessionLossTimeout or WithFencingEpochs for a node that may still be running with an expired session.
+func WithTwoPhaseHandoff() Option {
+	return func(s *Sharding) {
+		s.twoPhaseHandoff = true
+		s.shardStatus = true
+	}
+}
+
+// WithShardStatus makes the current node report the shards it has activated to its status znode,
+// so observers can distinguish between active and pending shards.
+// It is implied by WithTwoPhaseHandoff.
+func WithShardStatus() Option {
+	return func(s *Sharding) {
+		s.shardStatus = true
+	}
+}
+
+// WithShardActivation makes the current node report a shard as active only after Sharding.MarkShardActive
+// is called for it, instead of right after acquiring it. It implies WithShardStatus.
+func WithShardActivation() Option {
+	return func(s *Sharding) {
+		s.shardActivation = true
+		s.shardStatus = true
+	}
+}
+
+// WithShardListener sets the listener notified when the current node acquires or releases shards,
+// see ShardListener. The current shards are also available by Sharding.MyShards.
+func WithShardListener(fn ShardListener) Option {
+	return func(s *Sharding) {
+		if fn == nil {
+			panic("Invalid shard listener")
+		}
+		s.shardListener = fn
+	}
+}
+
+// WithShardHandler makes the current node run the work of each shard it is holding, see ShardHandler.
+// A released shard is still reported in the status znode until OnRelease has returned.
+func WithShardHandler(handler ShardHandler) Option {
+	return func(s *Sharding) {
+		if handler == nil {
+			panic("Invalid shard handler")
+		}
+		s.shardHandler = handler
+	}
+}
+
+// WithSessionLossTimeout releases all shards of the current node and calls onLost (can be nil) on a timer goroutine
+// when none of its requests has succeeded within the timeout. The timeout plus a quarter of it
+// should be shorter than the session timeout, because the leader reassigns shards after the session expires.
+func WithSessionLossTimeout(timeout time.Duration, onLost func()) Option {
+	return func(s *Sharding) {
+		if timeout <= 0 {
+			panic("Invalid session loss timeout")
+		}
+		s.sessionLossTimeout = timeout
+		s.onSessionLost = onLost
+	}
+}
+
+// ObserverOption for options of standalone observer
+type ObserverOption func(opts *observerOptions)
+
+type observerOptions struct {
+	shardStatus      bool
+	dynamicNumShards bool
+	configValidation bool
+	shardFunc        ShardFunc
+	nonResizable     bool
+	shardWeights     map[ShardID]uint32
+	groups           []shardGroupConfig
+	fencingEpochs    bool
+
+	errorFunc       ErrorHandler
+	errorRetryDelay time.Duration
+}
+
+// WithObserverShardStatus makes the observer read status znodes of nodes,
+// for computing Node.ActiveShards and Node.PendingShards.
+// Nodes should be configured with WithShardStatus or WithTwoPhaseHandoff.
+func WithObserverShardStatus() ObserverOption {
+	return func(opts *observerOptions) {
+		opts.shardStatus = true
+	}
+}
+
+// WithObserverDynamicNumShards makes the observer read the number of shards from the config znode,
+// the numShards argument of NewObserver is only used for creating the config znode if it does not exist.
+// Nodes should be configured with WithDynamicNumShards.
+func WithObserverDynamicNumShards() ObserverOption {
+	return func(opts *observerOptions) {
+		opts.dynamicNumShards = true
+	}
+}
+
+// WithObserverConfigValidation makes the observer compare its number of shards with the config znode,
+// the observer does NOT start observing on mismatch and the error is returned by Observer.ConfigError.
+// Nothing is compared if the config znode does not exist yet.
+func WithObserverConfigValidation() ObserverOption {
+	return func(opts *observerOptions) {
+		opts.configValidation = true
+	}
+}
+
+// WithObserverShardFunc changes the function used by ShardForKey and NodeForKey of the observer,
+// the default is FNVModuloShardFunc, see WithShardFunc
+func WithObserverShardFunc(fn ShardFunc) ObserverOption {
+	return func(opts *observerOptions) {
+		if fn == nil {
+			panic("Invalid shard func")
+		}
+		opts.shardFunc = fn
+		opts.nonResizable = false
+	}
+}
+
+// WithObserverNonResizableShardFunc is WithObserverShardFunc for a function that does NOT follow
+// the split and merge mapping of ShardResize, see WithNonResizableShardFunc
+func WithObserverNonResizableShardFunc(fn ShardFunc) ObserverOption {
+	return func(opts *observerOptions) {
+		WithObserverShardFunc(fn)(opts)
+		opts.nonResizable = true
+	}
+}
+
+// WithObserverShardWeights sets the static weights of shards for computing Node.TotalWeight,
+// should be the same as the weights of nodes configured with WithShardWeights
+func WithObserverShardWeights(weights map[ShardID]uint32) ObserverOption {
+	return func(opts *observerOptions) {
+		opts.shardWeights = cloneShardWeights(weights)
+	}
+}
+
+// WithObserverShardGroup makes the observer also observe the shard group, see WithShardGroup
+func WithObserverShardGroup(name string, numShards ShardID) ObserverOption {
+	return func(opts *observerOptions) {
+		validateShardGroup(opts.groups, name, numShards)
+		opts.groups = append(opts.groups, shardGroupConfig{
+			name:      name,
+			numShards: numShards,
+		})
+	}
+}
+
+// WithObserverFencingEpochs makes the observer read the fencing epochs of shards into Node.Epochs,
+// nodes should be configured with WithFencingEpochs
+func WithObserverFencingEpochs() ObserverOption {
+	return func(opts *observerOptions) {
+		opts.fencingEpochs = true
+	}
+}
+
+// WithObserverErrorHandler sets the handler for unexpected errors of the observer, see WithErrorHandler
+func WithObserverErrorHandler(fn ErrorHandler, retryDelay time.Duration) ObserverOption {
+	return func(opts *observerOptions) {
+		if fn == nil {
+			panic("Invalid error handler")
+		}
+		if retryDelay <= 0 {
+			panic("Invalid error retry delay")
+		}
+		opts.errorFunc = fn
+		opts.errorRetryDelay = retryDelay
+	}
+}
+
+func cloneShardWeights(weights map[ShardID]uint32) map[ShardID]uint32 {
+	result := make(map[ShardID]uint32, len(weights))
+	for id, w := range weights {
+		if w == 0 {
+			panic("Invalid shard weight")
+		}
+		result[id] = w
+	}
+	return result
+}
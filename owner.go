@@ -0,0 +1,515 @@
+package sharding
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+// shardOwner watches the assign znode of the current node, notifies the changes of its shards to the listener
+// and the runner, and reports the shards that the current node is holding to its status znode if reportStatus is true.
+// With a runner, a released shard is still reported until its worker has stopped.
+// With two-phase handoff, assignments written before the status znode of the current session was created
+// are ignored, because they could be computed from the state of a previous session.
+type shardOwner struct {
+	parent string
+	nodeID string
+
+	// assignsPath is the container of the assign znodes, of the default group or a shard group
+	assignsPath string
+
+	ignoreOutdated bool
+	reportStatus   bool
+	activation     bool
+	errs           *errorHandler
+	listener       ShardListener
+	runner         *shardRunner
+	afterFunc      func(d time.Duration, fn func())
+
+	// state data
+	watching bool
+	assign   ownerAssign
+	status   *statusWriter
+
+	// notifyMut serializes the changes of shards, they are made on the goroutine of the zookeeper client,
+	// or on a timer goroutine when the session is considered lost
+	notifyMut sync.Mutex
+
+	// shards are the shards the current node is holding and epochs are their fencing epochs,
+	// for answering queries from other goroutines.
+	// While suspended, the current node is holding no shards, but the assignment is kept for resuming.
+	// activated are the held shards marked active by the application, only with activation.
+	mut       sync.Mutex
+	shards    []ShardID
+	epochs    map[ShardID]int64
+	activated map[ShardID]struct{}
+	sess      *curator.Session
+	suspended bool
+}
+
+type ownerAssign struct {
+	shards    []ShardID
+	numShards ShardID
+	mzxid     int64
+	epochs    map[ShardID]int64
+}
+
+func newShardOwner(
+	parent string, nodeID string,
+	ignoreOutdated bool, reportStatus bool, errs *errorHandler,
+) *shardOwner {
+	return &shardOwner{
+		parent:         parent,
+		nodeID:         nodeID,
+		assignsPath:    parent + assignZNodeName,
+		ignoreOutdated: ignoreOutdated,
+		reportStatus:   reportStatus,
+		errs:           errs,
+	}
+}
+
+func (o *shardOwner) setHandler(handler ShardHandler) {
+	o.runner = newShardRunner(handler, o.onWorkerStopped)
+}
+
+func (o *shardOwner) reset() {
+	o.watching = false
+	o.assign = ownerAssign{}
+
+	o.mut.Lock()
+	o.suspended = false
+	o.mut.Unlock()
+
+	o.setShards(nil, nil)
+}
+
+// suspend releases all shards when the session is considered lost, it is called on a timer goroutine
+func (o *shardOwner) suspend() {
+	o.mut.Lock()
+	o.suspended = true
+	o.mut.Unlock()
+
+	o.setShards(nil, nil)
+}
+
+func (o *shardOwner) resume(sess *curator.Session) {
+	o.mut.Lock()
+	o.suspended = false
+	o.mut.Unlock()
+
+	o.applyAssign(sess)
+}
+
+// setShards changes the holding shards, notifies the listener and the runner outside the lock,
+// and returns the shards that are actually held
+func (o *shardOwner) setShards(shards []ShardID, epochs map[ShardID]int64) []ShardID {
+	o.notifyMut.Lock()
+	defer o.notifyMut.Unlock()
+
+	o.mut.Lock()
+	if o.suspended {
+		shards = nil
+		epochs = nil
+	}
+	acquired := subtractShards(shards, o.shards)
+	released := subtractShards(o.shards, shards)
+	o.shards = shards
+	o.epochs = epochs
+	for _, id := range released {
+		delete(o.activated, id)
+	}
+	o.mut.Unlock()
+
+	if len(acquired) == 0 && len(released) == 0 {
+		return shards
+	}
+	if o.listener != nil {
+		o.listener(acquired, released)
+	}
+	if o.runner != nil {
+		for _, id := range released {
+			o.runner.release(id)
+		}
+		for _, id := range acquired {
+			o.runner.acquire(id)
+		}
+	}
+	return shards
+}
+
+func (o *shardOwner) getShards() []ShardID {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	if o.shards == nil {
+		return []ShardID{}
+	}
+	return slices.Clone(o.shards)
+}
+
+func (o *shardOwner) getSession() *curator.Session {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	return o.sess
+}
+
+// markActive records that the application is serving the held shard, and reports it to the status znode
+func (o *shardOwner) markActive(id ShardID) {
+	o.mut.Lock()
+	_, existed := o.activated[id]
+	if existed || !slices.Contains(o.shards, id) {
+		o.mut.Unlock()
+		return
+	}
+	if o.activated == nil {
+		o.activated = map[ShardID]struct{}{}
+	}
+	o.activated[id] = struct{}{}
+	o.mut.Unlock()
+
+	o.scheduleApplyAssign()
+}
+
+// getInactiveShards returns the shards that are NOT marked active by the application, only with activation
+func (o *shardOwner) getInactiveShards(shards []ShardID) []ShardID {
+	if !o.activation {
+		return nil
+	}
+
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	var result []ShardID
+	for _, id := range shards {
+		if _, ok := o.activated[id]; !ok {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func (o *shardOwner) getEpoch(id ShardID) (int64, bool) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	epoch, ok := o.epochs[id]
+	return epoch, ok
+}
+
+func (o *shardOwner) onStart(sess *curator.Session) {
+	o.reset()
+
+	o.mut.Lock()
+	o.sess = sess
+	o.mut.Unlock()
+
+	o.status = nil
+	if o.reportStatus {
+		o.status = newStatusWriter(o.parent+statusZNodeName+"/"+o.nodeID, NewNodeID(), o.errs, func() {
+			o.applyAssign(sess)
+		})
+		o.status.write(sess, statusData{})
+	}
+	o.listAssigns(sess)
+}
+
+func (o *shardOwner) getAssignPath() string {
+	return o.assignsPath + "/" + o.nodeID
+}
+
+func (o *shardOwner) listAssigns(sess *curator.Session) {
+	sess.GetClient().ChildrenW(o.assignsPath,
+		func(resp zk.ChildrenResponse, err error) {
+			if err != nil {
+				if errors.Is(err, zk.ErrConnectionClosed) {
+					sess.AddRetry(o.listAssigns)
+					return
+				}
+				o.errs.handle(sess, &UnexpectedError{Op: OpChildren, Path: o.assignsPath, Err: err},
+					o.listAssigns, false)
+				return
+			}
+			if o.watching {
+				return
+			}
+			if slices.Contains(resp.Children, o.nodeID) {
+				o.watching = true
+				o.getAssign(sess)
+			}
+		},
+		func(ev zk.Event) {
+			if ev.Type == zk.EventNodeChildrenChanged {
+				o.listAssigns(sess)
+			}
+		},
+	)
+}
+
+func (o *shardOwner) getAssign(sess *curator.Session) {
+	sess.GetClient().GetW(o.getAssignPath(), func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(o.getAssign)
+				return
+			}
+			if errors.Is(err, zk.ErrNoNode) {
+				o.watching = false
+				o.setAssign(sess, ownerAssign{})
+				return
+			}
+			o.errs.handle(sess, &UnexpectedError{Op: OpGet, Path: o.getAssignPath(), Err: err}, o.getAssign, false)
+			return
+		}
+
+		var assign assignData
+		if err := json.Unmarshal(resp.Data, &assign); err != nil {
+			// a skipped assign znode is treated as holding no shards
+			if !o.errs.handle(sess, &UnexpectedError{Op: OpUnmarshal, Path: o.getAssignPath(), Err: err},
+				o.getAssign, true) {
+				return
+			}
+			assign = assignData{}
+		}
+		o.setAssign(sess, ownerAssign{
+			shards:    assign.Shards,
+			numShards: assign.NumShards,
+			mzxid:     resp.Stat.Mzxid,
+			epochs:    resolveEpochs(assign, resp.Stat.Mzxid),
+		})
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDataChanged {
+			o.getAssign(sess)
+		} else if ev.Type == zk.EventNodeDeleted {
+			o.watching = false
+			o.setAssign(sess, ownerAssign{})
+		}
+	})
+}
+
+func (o *shardOwner) setAssign(sess *curator.Session, assign ownerAssign) {
+	o.assign = assign
+	o.applyAssign(sess)
+}
+
+func (o *shardOwner) applyAssign(sess *curator.Session) {
+	if o.status != nil && o.status.czxid == 0 {
+		return
+	}
+
+	// ignoreOutdated is only used with reportStatus
+	var shards []ShardID
+	if !o.ignoreOutdated || o.assign.mzxid > o.status.czxid {
+		shards = slices.Clone(o.assign.shards)
+	}
+	slices.Sort(shards)
+
+	epochs := make(map[ShardID]int64, len(shards))
+	for _, id := range shards {
+		epochs[id] = o.assign.epochs[id]
+	}
+
+	shards = o.setShards(shards, epochs)
+
+	if o.status == nil {
+		return
+	}
+
+	statusShards := shards
+	if o.runner != nil {
+		statusShards = o.runner.getRunningShards()
+	}
+
+	inactive := o.getInactiveShards(statusShards)
+
+	// the status is also written when only the mzxid changed, for acknowledging the assign znode
+	if slices.Equal(o.status.data.Shards, statusShards) && slices.Equal(o.status.data.Inactive, inactive) &&
+		o.status.data.AssignZxid == o.assign.mzxid {
+		return
+	}
+	o.status.write(sess, statusData{
+		Shards:     statusShards,
+		Inactive:   inactive,
+		AssignZxid: o.assign.mzxid,
+		NumShards:  o.assign.numShards,
+	})
+}
+
+// onWorkerStopped is called on the goroutine of a stopped worker, for reporting that the shard is no longer held
+func (o *shardOwner) onWorkerStopped() {
+	if !o.reportStatus {
+		return
+	}
+	o.scheduleApplyAssign()
+}
+
+// scheduleApplyAssign moves to the goroutine of the zookeeper client by runOnSession, for writing the status znode
+func (o *shardOwner) scheduleApplyAssign() {
+	sess := o.getSession()
+	o.afterFunc(0, func() {
+		runOnSession(sess, o.parent, func(sess *curator.Session) {
+			if o.getSession() != sess {
+				return
+			}
+			o.applyAssign(sess)
+		})
+	})
+}
+
+// statusWriter writes the status znode of the current node, at most one request is in flight at a time.
+// The status znode contains the session token of the writer, an existing status znode with a different token
+// was created by a previous session of the current node, and is only replaced after it is deleted.
+type statusWriter struct {
+	path      string
+	token     string
+	errs      *errorHandler
+	onCreated func()
+
+	created bool
+	version int32
+	czxid   int64
+
+	writing bool
+	dirty   bool
+	data    statusData
+}
+
+func newStatusWriter(pathVal string, token string, errs *errorHandler, onCreated func()) *statusWriter {
+	return &statusWriter{
+		path:      pathVal,
+		token:     token,
+		errs:      errs,
+		onCreated: onCreated,
+	}
+}
+
+func (w *statusWriter) write(sess *curator.Session, data statusData) {
+	if data.Shards == nil {
+		data.Shards = []ShardID{}
+	}
+	data.Session = w.token
+	w.data = data
+	if w.writing {
+		w.dirty = true
+		return
+	}
+	w.doWrite(sess)
+}
+
+func (w *statusWriter) doWrite(sess *curator.Session) {
+	w.writing = true
+	w.dirty = false
+
+	data := w.data.marshalJSON()
+
+	if !w.created {
+		sess.GetClient().Create(w.path, data, zk.FlagEphemeral, func(resp zk.CreateResponse, err error) {
+			if errors.Is(err, zk.ErrNodeExists) {
+				w.refreshVersion(sess)
+				return
+			}
+			if err == nil {
+				w.setCzxid(resp.Zxid)
+			}
+			w.handleResponse(sess, 0, err)
+		})
+		return
+	}
+
+	sess.GetClient().Set(w.path, data, w.version, func(resp zk.SetResponse, err error) {
+		if errors.Is(err, zk.ErrNoNode) {
+			w.created = false
+			w.doWrite(sess)
+			return
+		}
+		if errors.Is(err, zk.ErrBadVersion) {
+			w.refreshVersion(sess)
+			return
+		}
+		w.handleResponse(sess, resp.Stat.Version, err)
+	})
+}
+
+// refreshVersion is called when the previous request is applied but its response is lost,
+// or when the status znode was created by another session
+func (w *statusWriter) refreshVersion(sess *curator.Session) {
+	sess.GetClient().Get(w.path, func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(w.doWrite)
+				return
+			}
+			if errors.Is(err, zk.ErrNoNode) {
+				w.created = false
+				w.doWrite(sess)
+				return
+			}
+			w.errs.handle(sess, &UnexpectedError{Op: OpGet, Path: w.path, Err: err}, w.doWrite, false)
+			return
+		}
+
+		var data statusData
+		if err := json.Unmarshal(resp.Data, &data); err != nil || data.Session != w.token {
+			w.created = false
+			w.waitOtherStatusDeleted(sess)
+			return
+		}
+		w.created = true
+		w.version = resp.Stat.Version
+		w.setCzxid(resp.Stat.Czxid)
+		w.doWrite(sess)
+	})
+}
+
+// waitOtherStatusDeleted waits until the status znode of another session is deleted, then creates it again
+func (w *statusWriter) waitOtherStatusDeleted(sess *curator.Session) {
+	sess.GetClient().GetW(w.path, func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(w.waitOtherStatusDeleted)
+				return
+			}
+			if errors.Is(err, zk.ErrNoNode) {
+				w.doWrite(sess)
+				return
+			}
+			w.errs.handle(sess, &UnexpectedError{Op: OpGet, Path: w.path, Err: err}, w.waitOtherStatusDeleted, false)
+		}
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDeleted {
+			w.doWrite(sess)
+		} else if ev.Type == zk.EventNodeDataChanged {
+			w.waitOtherStatusDeleted(sess)
+		}
+	})
+}
+
+// setCzxid is called whenever the status znode is created by the current session,
+// the czxid of a recreated status znode replaces the previous one
+func (w *statusWriter) setCzxid(czxid int64) {
+	if w.czxid == czxid {
+		return
+	}
+	w.czxid = czxid
+	w.onCreated()
+}
+
+func (w *statusWriter) handleResponse(sess *curator.Session, version int32, err error) {
+	if err != nil {
+		if errors.Is(err, zk.ErrConnectionClosed) {
+			sess.AddRetry(w.doWrite)
+			return
+		}
+		w.errs.handle(sess, &UnexpectedError{Op: OpSet, Path: w.path, Err: err}, w.doWrite, false)
+		return
+	}
+
+	w.created = true
+	w.version = version
+	w.writing = false
+	if w.dirty {
+		w.doWrite(sess)
+	}
+}
@@ -0,0 +1,54 @@
+package sharding
+
+import (
+	"slices"
+)
+
+// ShardListener is notified with the shards acquired and released by the current node, both sorted.
+// It is called on the goroutine of the zookeeper client, or on a timer goroutine with WithSessionLossTimeout,
+// the calls are never concurrent, and it should NOT block.
+// All shards of the current node are released when its zookeeper session is restarted.
+type ShardListener func(acquired []ShardID, released []ShardID)
+
+// MyShards returns a sorted snapshot of the shards that the current node is holding,
+// computed from the assign znode of the current node. It is safe to call from any goroutine.
+// Returns an empty slice if the current node is holding no shards, and nil only for a coordinator.
+// With WithTwoPhaseHandoff, assignments written before the current zookeeper session are NOT included.
+func (s *Sharding) MyShards() []ShardID {
+	if s.owner == nil {
+		return nil
+	}
+	return s.owner.getShards()
+}
+
+// GroupShards is MyShards for the shard group added by WithShardGroup.
+// Returns nil for a coordinator or a group that is NOT added.
+func (s *Sharding) GroupShards(group string) []ShardID {
+	for _, g := range s.groups {
+		if g.group == group && g.owner != nil {
+			return g.owner.getShards()
+		}
+	}
+	return nil
+}
+
+// MarkShardActive reports that the current node has started serving the shard, only with WithShardActivation.
+// It is safe to call from any goroutine, e.g. from ShardHandler.OnAcquire. Shards that are NOT held are ignored,
+// and a shard should be marked again every time it is acquired.
+func (s *Sharding) MarkShardActive(id ShardID) {
+	if s.owner == nil || !s.shardActivation {
+		return
+	}
+	s.owner.markActive(id)
+}
+
+// subtractShards returns the shards of a that are not in b, keeping the order of a
+func subtractShards(a []ShardID, b []ShardID) []ShardID {
+	var result []ShardID
+	for _, id := range a {
+		if !slices.Contains(b, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,233 @@
+package sharding
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+func unmarshalOverrideData(data []byte) (overrideData, error) {
+	var result overrideData
+	if len(data) == 0 {
+		return result, nil
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return overrideData{}, err
+	}
+	return result, nil
+}
+
+func (s *Sharding) getOverridesPath() string {
+	return s.parentPath + overridesZNodeName
+}
+
+// PinShard pins the shard to the node, the node does NOT need to be active.
+// Pins are only honored by leaders configured with WithShardPinning.
+// The callback is called on the goroutine of the zookeeper client, or on a new goroutine with ErrNoSession.
+func (s *Sharding) PinShard(shardID ShardID, nodeID string, callback func(err error)) {
+	s.updateOverrides(func(data *overrideData) error {
+		if !s.dynamicNumShards && shardID >= s.numShards {
+			return ErrInvalidShardID
+		}
+		if data.Pins == nil {
+			data.Pins = map[ShardID]string{}
+		}
+		data.Pins[shardID] = nodeID
+		return nil
+	}, callback)
+}
+
+// UnpinShard removes the pin of the shard, if any.
+// The callback is called on the goroutine of the zookeeper client, or on a new goroutine with ErrNoSession.
+func (s *Sharding) UnpinShard(shardID ShardID, callback func(err error)) {
+	s.updateOverrides(func(data *overrideData) error {
+		delete(data.Pins, shardID)
+		return nil
+	}, callback)
+}
+
+// ListPins returns the pinned shards and their nodes.
+// The callback is called on the goroutine of the zookeeper client, or on a new goroutine with ErrNoSession.
+func (s *Sharding) ListPins(callback func(pins map[ShardID]string, err error)) {
+	sess := s.getCurrentSession()
+	if sess == nil {
+		go callback(nil, ErrNoSession)
+		return
+	}
+
+	sess.GetClient().Get(s.getOverridesPath(), func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrNoNode) {
+				callback(map[ShardID]string{}, nil)
+				return
+			}
+			callback(nil, err)
+			return
+		}
+
+		data, err := unmarshalOverrideData(resp.Data)
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		pins := data.Pins
+		if pins == nil {
+			pins = map[ShardID]string{}
+		}
+		callback(pins, nil)
+	})
+}
+
+// updateOverrides does a compare-and-set loop on the overrides znode, stops if modify returns an error
+func (s *Sharding) updateOverrides(modify func(data *overrideData) error, callback func(err error)) {
+	sess := s.getCurrentSession()
+	if sess == nil {
+		go callback(ErrNoSession)
+		return
+	}
+	client := sess.GetClient()
+	pathVal := s.getOverridesPath()
+
+	var loop func()
+	loop = func() {
+		client.Get(pathVal, func(resp zk.GetResponse, err error) {
+			if errors.Is(err, zk.ErrNoNode) {
+				createOverrides(client, pathVal, modify, loop, callback)
+				return
+			}
+			if err != nil {
+				callback(err)
+				return
+			}
+
+			setOverrides(client, pathVal, resp, modify, loop, callback)
+		})
+	}
+	loop()
+}
+
+func setOverrides(
+	client curator.Client, pathVal string, resp zk.GetResponse,
+	modify func(data *overrideData) error, retry func(), callback func(err error),
+) {
+	data, err := unmarshalOverrideData(resp.Data)
+	if err != nil {
+		callback(err)
+		return
+	}
+	data.Pins = maps.Clone(data.Pins)
+	if err := modify(&data); err != nil {
+		callback(err)
+		return
+	}
+	client.Set(pathVal, data.marshalJSON(), resp.Stat.Version, func(resp zk.SetResponse, err error) {
+		if errors.Is(err, zk.ErrBadVersion) || errors.Is(err, zk.ErrNoNode) {
+			retry()
+			return
+		}
+		callback(err)
+	})
+}
+
+func createOverrides(
+	client curator.Client, pathVal string,
+	modify func(data *overrideData) error, retry func(), callback func(err error),
+) {
+	var data overrideData
+	if err := modify(&data); err != nil {
+		callback(err)
+		return
+	}
+	client.Create(pathVal, data.marshalJSON(), 0, func(resp zk.CreateResponse, err error) {
+		if errors.Is(err, zk.ErrNodeExists) {
+			retry()
+			return
+		}
+		callback(err)
+	})
+}
+
+// ========================================
+// Leader Logic
+// ========================================
+
+func (s *Sharding) watchOverrides(sess *curator.Session) {
+	sess.GetClient().GetW(s.getOverridesPath(), func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(s.watchOverrides)
+				return
+			}
+			if errors.Is(err, zk.ErrNoNode) {
+				// a deleted overrides znode is treated as empty, and is created again for watching
+				s.applyOverrides(sess, overrideData{})
+				s.createEmptyOverrides(sess)
+				return
+			}
+			s.handleError(sess, OpGet, s.getOverridesPath(), err, s.watchOverrides, false)
+			return
+		}
+
+		data, err := unmarshalOverrideData(resp.Data)
+		if err != nil && !s.handleError(sess, OpUnmarshal, s.getOverridesPath(), err, s.watchOverrides, true) {
+			return
+		}
+		// a skipped overrides znode is treated as empty
+		s.applyOverrides(sess, data)
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDataChanged || ev.Type == zk.EventNodeDeleted {
+			s.watchOverrides(sess)
+		}
+	})
+}
+
+func (s *Sharding) applyOverrides(sess *curator.Session, data overrideData) {
+	s.state.pins = data.Pins
+	s.state.overridesFetched = true
+	s.startHandleNodeChanges(sess)
+}
+
+func (s *Sharding) createEmptyOverrides(sess *curator.Session) {
+	sess.GetClient().Create(s.getOverridesPath(), nil, 0, func(resp zk.CreateResponse, err error) {
+		if err == nil || errors.Is(err, zk.ErrNodeExists) {
+			s.watchOverrides(sess)
+			return
+		}
+		if errors.Is(err, zk.ErrConnectionClosed) {
+			sess.AddRetry(s.watchOverrides)
+			return
+		}
+		s.handleError(sess, OpCreate, s.getOverridesPath(), err, s.watchOverrides, false)
+	})
+}
+
+func (s *Sharding) isOverridesReady() bool {
+	if !s.shardPinning {
+		return true
+	}
+	return s.state.overridesFetched
+}
+
+// getActivePins returns the pins to active nodes, pins to other nodes and draining nodes are ignored
+func (s *Sharding) getActivePins() map[ShardID]string {
+	if len(s.state.pins) == 0 {
+		return nil
+	}
+
+	assignable := s.getAssignableNodes()
+	result := map[ShardID]string{}
+	for id, nodeID := range s.state.pins {
+		if id >= s.state.numShards {
+			continue
+		}
+		if !slices.Contains(assignable, nodeID) {
+			continue
+		}
+		result[id] = nodeID
+	}
+	return result
+}
@@ -0,0 +1,134 @@
+package sharding
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+)
+
+// limitMoves reverts the moves of shards between active nodes that exceed the limits
+// configured by WithMaxMovesPerRound and WithMinRebalanceInterval.
+// A reverted shard stays on its current node, and the leader tries to move it again in the next rounds.
+// Shards that are not assigned to any active node and pinned shards are never limited.
+func (s *Sharding) limitMoves(
+	sess *curator.Session, desired map[string][]ShardID, pinned map[ShardID]string,
+) map[string][]ShardID {
+	if s.maxMovesPerRound <= 0 && s.minRebalanceInterval <= 0 {
+		return desired
+	}
+
+	now := s.now()
+	waitDuration := s.computeRebalanceWait(now)
+
+	result := make(map[string][]ShardID, len(desired))
+	for nodeID, shards := range desired {
+		result[nodeID] = slices.Clone(shards)
+	}
+
+	moves := s.computeShardMoves(desired, pinned)
+
+	numAllowed := len(moves)
+	if waitDuration > 0 {
+		numAllowed = 0
+	} else if s.maxMovesPerRound > 0 && numAllowed > s.maxMovesPerRound {
+		numAllowed = s.maxMovesPerRound
+	}
+
+	for _, m := range moves[numAllowed:] {
+		result[m.to] = slices.DeleteFunc(result[m.to], func(id ShardID) bool {
+			return id == m.id
+		})
+		result[m.from] = append(result[m.from], m.id)
+	}
+
+	if numAllowed > 0 {
+		s.lastRebalanceTime = now
+	}
+
+	if numAllowed < len(moves) && s.minRebalanceInterval > 0 {
+		if waitDuration == 0 {
+			waitDuration = s.minRebalanceInterval
+		}
+		s.scheduleRebalance(sess, waitDuration)
+	}
+
+	return result
+}
+
+// computeRebalanceWait returns the remaining duration until shards can be moved again
+func (s *Sharding) computeRebalanceWait(now time.Time) time.Duration {
+	if s.minRebalanceInterval <= 0 || s.lastRebalanceTime.IsZero() {
+		return 0
+	}
+	elapsed := now.Sub(s.lastRebalanceTime)
+	if elapsed < s.minRebalanceInterval {
+		return s.minRebalanceInterval - elapsed
+	}
+	return 0
+}
+
+type shardMove struct {
+	id   ShardID
+	from string
+	to   string
+}
+
+// computeShardMoves returns the moves of shards between active nodes, sorted by shard id
+func (s *Sharding) computeShardMoves(desired map[string][]ShardID, pinned map[ShardID]string) []shardMove {
+	owners := map[ShardID]string{}
+	for _, nodeID := range s.state.nodes {
+		for _, id := range s.state.currentAssignMap[nodeID].shards {
+			owners[id] = nodeID
+		}
+	}
+
+	var moves []shardMove
+	for _, nodeID := range getKeys(desired) {
+		for _, id := range desired[nodeID] {
+			owner, ok := owners[id]
+			if !ok || owner == nodeID {
+				continue
+			}
+			if _, isPinned := pinned[id]; isPinned {
+				continue
+			}
+			moves = append(moves, shardMove{id: id, from: owner, to: nodeID})
+		}
+	}
+	slices.SortFunc(moves, func(a, b shardMove) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return moves
+}
+
+// scheduleRebalance runs the shard allocation again after the duration d,
+// unless another run is already scheduled before that.
+// The timer callback is moved to the goroutine of the session by runOnSession,
+// and is ignored if the leader session has changed.
+func (s *Sharding) scheduleRebalance(sess *curator.Session, d time.Duration) {
+	at := s.now().Add(d)
+	if !s.state.rebalanceAt.IsZero() && !s.state.rebalanceAt.After(at) {
+		return
+	}
+	s.state.rebalanceAt = at
+
+	seq := s.sessionSeq
+	state := s.state
+	s.afterFunc(d, func() {
+		runOnSession(sess, s.parentPath, func(sess *curator.Session) {
+			s.runRebalance(sess, seq, state, at)
+		})
+	})
+}
+
+func (s *Sharding) runRebalance(sess *curator.Session, seq int, state *sessionState, at time.Time) {
+	if s.sessionSeq != seq || s.state != state {
+		return
+	}
+	if state.rebalanceAt.Equal(at) {
+		state.rebalanceAt = time.Time{}
+	}
+	s.startHandleNodeChanges(sess)
+}
@@ -0,0 +1,230 @@
+package sharding
+
+import (
+	"slices"
+)
+
+// placeReplicas assigns every shard to (replicationFactor - 1) active nodes other than its primary node
+func (s *Sharding) placeReplicas(primaries map[string][]ShardID) map[string][]ShardID {
+	if s.replicationFactor <= 1 {
+		return nil
+	}
+
+	current := make(map[string][]ShardID, len(s.state.currentAssignMap))
+	for nodeID, assign := range s.state.currentAssignMap {
+		current[nodeID] = assign.replicas
+	}
+
+	return allocateReplicas(replicaInput{
+		nodes:             s.getAllocateNodes(),
+		primaries:         primaries,
+		current:           current,
+		numShards:         s.state.numShards,
+		replicationFactor: s.replicationFactor,
+	})
+}
+
+type replicaInput struct {
+	nodes             []AllocateNode
+	primaries         map[string][]ShardID
+	current           map[string][]ShardID
+	numShards         ShardID
+	replicationFactor int
+}
+
+// allocateReplicas keeps the current replicas as much as possible
+// and balances the number of replicas between nodes proportionally to their weights.
+// Holders of a shard are spread to different zones, racks and hosts whenever possible,
+// a current replica is only kept if no other node is in a better location for the shard.
+func allocateReplicas(input replicaInput) map[string][]ShardID {
+	numReplicas := min(input.replicationFactor-1, len(input.nodes)-1)
+	if numReplicas <= 0 {
+		return nil
+	}
+
+	a := newReplicaAllocator(input, numReplicas)
+	a.keepCurrentReplicas()
+	a.allocateMissingReplicas()
+	return a.result
+}
+
+type replicaAllocator struct {
+	input       replicaInput
+	numReplicas int
+
+	nodeIndex map[string]int
+	primaryOf map[ShardID]string
+	quotas    []ShardID
+
+	counts  []int
+	holders [][]int
+	result  map[string][]ShardID
+}
+
+func newReplicaAllocator(input replicaInput, numReplicas int) *replicaAllocator {
+	nodeIndex := map[string]int{}
+	for index, node := range input.nodes {
+		nodeIndex[node.ID] = index
+	}
+
+	primaryOf := map[ShardID]string{}
+	for nodeID, shards := range input.primaries {
+		for _, id := range shards {
+			primaryOf[id] = nodeID
+		}
+	}
+
+	return &replicaAllocator{
+		input:       input,
+		numReplicas: numReplicas,
+
+		nodeIndex: nodeIndex,
+		primaryOf: primaryOf,
+		quotas:    computeNodeQuotas(input.nodes, input.numShards*ShardID(numReplicas)),
+
+		counts:  make([]int, len(input.nodes)),
+		holders: make([][]int, input.numShards),
+		result:  map[string][]ShardID{},
+	}
+}
+
+func (a *replicaAllocator) canHold(index int, id ShardID) bool {
+	if a.primaryOf[id] == a.input.nodes[index].ID {
+		return false
+	}
+	return !slices.Contains(a.holders[id], index)
+}
+
+func (a *replicaAllocator) overlapOf(index int, id ShardID) topologyOverlap {
+	nodes := a.input.nodes
+
+	var result topologyOverlap
+	if primaryIndex, ok := a.nodeIndex[a.primaryOf[id]]; ok {
+		result = result.add(nodes[index].Topology, nodes[primaryIndex].Topology)
+	}
+	for _, holder := range a.holders[id] {
+		result = result.add(nodes[index].Topology, nodes[holder].Topology)
+	}
+	return result
+}
+
+func (a *replicaAllocator) minOverlapOf(id ShardID) topologyOverlap {
+	var result topologyOverlap
+	found := false
+	for index := range a.input.nodes {
+		if !a.canHold(index, id) {
+			continue
+		}
+		overlap := a.overlapOf(index, id)
+		if !found || overlap.less(result) {
+			result = overlap
+			found = true
+		}
+	}
+	return result
+}
+
+func (a *replicaAllocator) addReplica(index int, id ShardID) {
+	a.counts[index]++
+	a.holders[id] = append(a.holders[id], index)
+
+	nodeID := a.input.nodes[index].ID
+	a.result[nodeID] = append(a.result[nodeID], id)
+}
+
+func (a *replicaAllocator) canKeepReplica(index int, id ShardID) bool {
+	if id >= a.input.numShards || len(a.holders[id]) >= a.numReplicas {
+		return false
+	}
+	if a.counts[index] >= int(a.quotas[index]) || !a.canHold(index, id) {
+		return false
+	}
+	return !a.minOverlapOf(id).less(a.overlapOf(index, id))
+}
+
+func (a *replicaAllocator) keepCurrentReplicas() {
+	for index, node := range a.input.nodes {
+		currentShards := slices.Clone(a.input.current[node.ID])
+		slices.Sort(currentShards)
+
+		for _, id := range currentShards {
+			if a.canKeepReplica(index, id) {
+				a.addReplica(index, id)
+			}
+		}
+	}
+}
+
+// findBestNode returns the node with the minimum overlap for the shard, then the less loaded one
+func (a *replicaAllocator) findBestNode(id ShardID) int {
+	best := -1
+	var bestOverlap topologyOverlap
+	for index := range a.input.nodes {
+		if !a.canHold(index, id) {
+			continue
+		}
+		overlap := a.overlapOf(index, id)
+		if best >= 0 && bestOverlap.less(overlap) {
+			continue
+		}
+		if best < 0 || overlap.less(bestOverlap) ||
+			lessLoadedReplicaNode(a.input.nodes, a.quotas, a.counts, index, best) {
+			best = index
+			bestOverlap = overlap
+		}
+	}
+	return best
+}
+
+func (a *replicaAllocator) allocateMissingReplicas() {
+	for id := ShardID(0); id < a.input.numShards; id++ {
+		for len(a.holders[id]) < a.numReplicas {
+			best := a.findBestNode(id)
+			if best < 0 {
+				break
+			}
+			a.addReplica(best, id)
+		}
+	}
+}
+
+// lessLoadedReplicaNode returns true if the node at index a should receive a new replica before the node at index b
+func lessLoadedReplicaNode(nodes []AllocateNode, quotas []ShardID, counts []int, a, b int) bool {
+	underQuotaA := counts[a] < int(quotas[a])
+	underQuotaB := counts[b] < int(quotas[b])
+	if underQuotaA != underQuotaB {
+		return underQuotaA
+	}
+	// compare counts[a] / weight[a] with counts[b] / weight[b]
+	return uint64(counts[a])*uint64(nodes[b].Weight) < uint64(counts[b])*uint64(nodes[a].Weight)
+}
+
+// topologyOverlap counts the holders of a shard that are in the same zone, rack or host with a node
+type topologyOverlap struct {
+	zone int
+	rack int
+	host int
+}
+
+func (o topologyOverlap) add(a, b Topology) topologyOverlap {
+	if a.Zone != "" && a.Zone == b.Zone {
+		o.zone++
+	}
+	if a.Rack != "" && a.Rack == b.Rack {
+		o.rack++
+	}
+	if a.Host != "" && a.Host == b.Host {
+		o.host++
+	}
+	return o
+}
+
+func (o topologyOverlap) less(other topologyOverlap) bool {
+	if o.zone != other.zone {
+		return o.zone < other.zone
+	}
+	if o.rack != other.rack {
+		return o.rack < other.rack
+	}
+	return o.host < other.host
+}
@@ -0,0 +1,214 @@
+package sharding
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocateReplicas(t *testing.T) {
+	t.Run("replication factor 2", func(t *testing.T) {
+		result := allocateReplicas(replicaInput{
+			nodes: newAllocateNodes("node01", "node02", "node03"),
+			primaries: map[string][]ShardID{
+				"node01": {0, 1, 2},
+				"node02": {3, 4, 5},
+				"node03": {6, 7},
+			},
+			numShards:         8,
+			replicationFactor: 2,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {3, 4, 6},
+			"node02": {0, 2, 7},
+			"node03": {1, 5},
+		}, result)
+	})
+
+	t.Run("keep current replicas", func(t *testing.T) {
+		result := allocateReplicas(replicaInput{
+			nodes: newAllocateNodes("node01", "node02", "node03"),
+			primaries: map[string][]ShardID{
+				"node01": {0, 1, 2},
+				"node02": {3, 4, 5},
+				"node03": {6, 7},
+			},
+			current: map[string][]ShardID{
+				"node01": {3, 4, 5, 6},
+				"node02": {6, 7},
+			},
+			numShards:         8,
+			replicationFactor: 2,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {3, 4, 5},
+			"node02": {6, 7, 2},
+			"node03": {0, 1},
+		}, result)
+	})
+
+	t.Run("spread to other zones", func(t *testing.T) {
+		result := allocateReplicas(replicaInput{
+			nodes: newZoneAllocateNodes(map[string]string{
+				"node01": "zone-a",
+				"node02": "zone-a",
+				"node03": "zone-b",
+				"node04": "zone-b",
+			}),
+			primaries: map[string][]ShardID{
+				"node01": {0, 1},
+				"node02": {2, 3},
+				"node03": {4, 5},
+				"node04": {6, 7},
+			},
+			current: map[string][]ShardID{
+				"node02": {0, 1},
+			},
+			numShards:         8,
+			replicationFactor: 2,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {4, 6},
+			"node02": {5, 7},
+			"node03": {0, 2},
+			"node04": {1, 3},
+		}, result)
+	})
+
+	t.Run("not enough nodes", func(t *testing.T) {
+		result := allocateReplicas(replicaInput{
+			nodes: newAllocateNodes("node01", "node02"),
+			primaries: map[string][]ShardID{
+				"node01": {0, 1},
+				"node02": {2, 3},
+			},
+			numShards:         4,
+			replicationFactor: 3,
+		})
+		assert.Equal(t, map[string][]ShardID{
+			"node01": {2, 3},
+			"node02": {0, 1},
+		}, result)
+	})
+
+	t.Run("single node", func(t *testing.T) {
+		result := allocateReplicas(replicaInput{
+			nodes: newAllocateNodes("node01"),
+			primaries: map[string][]ShardID{
+				"node01": {0, 1, 2, 3},
+			},
+			numShards:         4,
+			replicationFactor: 3,
+		})
+		assert.Equal(t, 0, len(result))
+	})
+}
+
+func TestSharding_Three_Nodes_With_Replication_Factor(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithReplicationFactor(2))
+	startSharding(store, client2, "node02", WithReplicationFactor(2))
+	startSharding(store, client3, "node03", WithReplicationFactor(2))
+
+	store.Begin(client1)
+	store.Begin(client2)
+	store.Begin(client3)
+
+	initContainerNodes(store, client1)
+	initContainerNodes(store, client2)
+	initContainerNodes(store, client3)
+
+	lockGranted(store, client1)
+	lockBlocked(store, client2)
+	lockBlocked(store, client3)
+
+	store.ChildrenApply(client1)
+	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 3)
+
+	store.CreateApply(client1)
+	store.CreateApply(client1)
+	store.CreateApply(client1)
+	assignsListed(store, client1)
+
+	assert.Equal(t, []string{}, store.PendingCalls(client1))
+
+	children := store.Root.Children[0].Children[2].Children
+	assert.Equal(t, 3, len(children))
+
+	assert.Equal(t, `{"shards":[0,1,2],"replicas":[3,4,6]}`, string(children[0].Data))
+	assert.Equal(t, `{"shards":[3,4,5],"replicas":[0,2,7]}`, string(children[1].Data))
+	assert.Equal(t, `{"shards":[6,7],"replicas":[1,5]}`, string(children[2].Data))
+
+	// Node 3 Session Expired
+	store.SessionExpired(client3)
+	store.ChildrenApply(client1)
+
+	store.SetApply(client1)
+	store.SetApply(client1)
+	store.DeleteApply(client1)
+	assert.Equal(t, []string{"get-w", "children-w"}, store.PendingCalls(client1))
+
+	store.GetApply(client1)      // owner get assigns/node01
+	store.ChildrenApply(client1) // owner list assigns
+	assert.Equal(t, []string{}, store.PendingCalls(client1))
+
+	children = store.Root.Children[0].Children[2].Children
+	assert.Equal(t, 2, len(children))
+
+	assert.Equal(t, `{"shards":[0,1,2,3],"replicas":[4,6,5,7]}`, string(children[0].Data))
+	assert.Equal(t, `{"shards":[4,5,6,7],"replicas":[0,2,1,3]}`, string(children[1].Data))
+}
+
+func TestSharding_With_Replication_Factor__With_Observer__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		var lastEvent ChangeEvent
+
+		startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithReplicationFactor(2))
+		startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithReplicationFactor(2))
+		startSharding(store, client3, "node03", WithLogger(&noopLogger{}), WithReplicationFactor(2),
+			WithShardingObserver(func(event ChangeEvent) {
+				lastEvent = event
+			}),
+		)
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3},
+			int64(k),
+		)
+
+		tester.Begin()
+		runTesterWithExactSteps(tester, 5, 2000, curator.WithRunOperationErrorPercentage(5))
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+		checkObserverShards(t, store, lastEvent)
+
+		holders := map[ShardID][]string{}
+		for _, node := range lastEvent.New {
+			for _, id := range node.Replicas {
+				role, ok := node.RoleOf(id)
+				assert.Equal(t, true, ok)
+				assert.Equal(t, ShardRoleReplica, role)
+				holders[id] = append(holders[id], node.ID)
+			}
+			for _, id := range node.Shards {
+				role, _ := node.RoleOf(id)
+				assert.Equal(t, ShardRolePrimary, role)
+				holders[id] = append(holders[id], node.ID)
+			}
+		}
+
+		for id := ShardID(0); id < numShards; id++ {
+			nodes := holders[id]
+			assert.Equal(t, 2, len(nodes))
+			slices.Sort(nodes)
+			assert.Equal(t, 2, len(slices.Compact(nodes)))
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package sharding
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+// ShardResize describes a change of the number of shards, configured by SetNumShards.
+// A key of an old shard k belongs to one of the new shards in Mapping[k]:
+// when splitting from S to m*S shards, shard k is mapped to k, k+S, ..., k+(m-1)*S;
+// when merging from m*S to S shards, shard k is mapped to k mod S.
+type ShardResize struct {
+	OldNumShards ShardID
+	NewNumShards ShardID
+	Mapping      map[ShardID][]ShardID
+}
+
+func newShardResize(oldNumShards ShardID, newNumShards ShardID) *ShardResize {
+	mapping := make(map[ShardID][]ShardID, oldNumShards)
+	for id := ShardID(0); id < oldNumShards; id++ {
+		mapping[id] = mapShards([]ShardID{id}, oldNumShards, newNumShards)
+	}
+	return &ShardResize{
+		OldNumShards: oldNumShards,
+		NewNumShards: newNumShards,
+		Mapping:      mapping,
+	}
+}
+
+func gcdShards(a, b ShardID) ShardID {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// mapShards maps shards from the number of shards `from` to `to`.
+// With keys mapped to shards by hash modulo the number of shards, the keys of the shard k
+// are in the shards j with j = k (mod gcd(from, to)).
+// Shards are returned unchanged if `from` is zero, meaning unknown.
+func mapShards(shards []ShardID, from ShardID, to ShardID) []ShardID {
+	if from == 0 || from == to {
+		return shards
+	}
+
+	g := gcdShards(from, to)
+	var result []ShardID
+	for _, k := range shards {
+		if k >= from {
+			continue
+		}
+		for j := k % g; j < to; j += g {
+			if !slices.Contains(result, j) {
+				result = append(result, j)
+			}
+		}
+	}
+	return result
+}
+
+func isValidResize(from ShardID, to ShardID) bool {
+	if from == 0 || to == 0 {
+		return false
+	}
+	return to%from == 0 || from%to == 0
+}
+
+func (s *Sharding) getConfigPath() string {
+	return s.parentPath + configZNodeName
+}
+
+// SetNumShards changes the number of shards stored in the config znode.
+// The new number must be a multiple (split) or a divisor (merge) of the current number.
+// Only used with WithDynamicNumShards.
+// The callback is called on the goroutine of the zookeeper client,
+// or on a new goroutine if the request is NOT sent, with ErrNoSession or for a zero numShards.
+func (s *Sharding) SetNumShards(numShards ShardID, callback func(err error)) {
+	if numShards == 0 {
+		go callback(ErrInvalidNumShards)
+		return
+	}
+
+	sess := s.getCurrentSession()
+	if sess == nil {
+		go callback(ErrNoSession)
+		return
+	}
+	client := sess.GetClient()
+	pathVal := s.getConfigPath()
+
+	var loop func()
+	loop = func() {
+		client.Get(pathVal, func(resp zk.GetResponse, err error) {
+			if err != nil {
+				callback(err)
+				return
+			}
+
+			config, changed, err := resizeConfigData(resp.Data, numShards)
+			if err != nil || !changed {
+				callback(err)
+				return
+			}
+
+			client.Set(pathVal, config.marshalJSON(), resp.Stat.Version, func(resp zk.SetResponse, err error) {
+				if errors.Is(err, zk.ErrBadVersion) {
+					loop()
+					return
+				}
+				callback(err)
+			})
+		})
+	}
+	loop()
+}
+
+// resizeConfigData returns the config data with the new number of shards, changed is false if it is the same
+func resizeConfigData(data []byte, numShards ShardID) (config configData, changed bool, err error) {
+	config, err = unmarshalConfigData(data)
+	if err != nil {
+		return configData{}, false, err
+	}
+	if config.NumShards == numShards {
+		return config, false, nil
+	}
+	if !isValidResize(config.NumShards, numShards) {
+		return configData{}, false, ErrInvalidNumShards
+	}
+	config.NumShards = numShards
+	return config, true, nil
+}
+
+func unmarshalConfigData(data []byte) (configData, error) {
+	var result configData
+	if err := json.Unmarshal(data, &result); err != nil {
+		return configData{}, err
+	}
+	return result, nil
+}
+
+// ========================================
+// Leader Logic
+// ========================================
+
+func (s *Sharding) watchConfig(sess *curator.Session) {
+	sess.GetClient().GetW(s.getConfigPath(), func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(s.watchConfig)
+				return
+			}
+			s.handleError(sess, OpGet, s.getConfigPath(), err, s.watchConfig, false)
+			return
+		}
+
+		config, err := unmarshalConfigData(resp.Data)
+		if err != nil {
+			s.handleError(sess, OpUnmarshal, s.getConfigPath(), err, s.watchConfig, false)
+			return
+		}
+		s.setLeaderNumShards(config.NumShards)
+		s.startHandleNodeChanges(sess)
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDataChanged {
+			s.watchConfig(sess)
+		}
+	})
+}
+
+// setLeaderNumShards converts the current assignments to the new number of shards
+func (s *Sharding) setLeaderNumShards(numShards ShardID) {
+	s.state.configFetched = true
+	if numShards == s.state.numShards {
+		return
+	}
+
+	s.logger.Infof("Number of shards changed from %d to %d", s.state.numShards, numShards)
+
+	for nodeID, assign := range s.state.currentAssignMap {
+		assign.shards = mapShards(assign.shards, assign.basis, numShards)
+		assign.replicas = mapShards(assign.replicas, assign.basis, numShards)
+		assign.basis = numShards
+		assign.epochs = nil
+		s.state.currentAssignMap[nodeID] = assign
+	}
+	s.state.numShards = numShards
+}
+
+// watchKnownNumShards keeps the number of shards used by ShardForKey up-to-date, on every node
+func (s *Sharding) watchKnownNumShards(sess *curator.Session) {
+	sess.GetClient().GetW(s.getConfigPath(), func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(s.watchKnownNumShards)
+				return
+			}
+			s.handleError(sess, OpGet, s.getConfigPath(), err, s.watchKnownNumShards, false)
+			return
+		}
+
+		config, err := unmarshalConfigData(resp.Data)
+		if err != nil {
+			s.handleError(sess, OpUnmarshal, s.getConfigPath(), err, s.watchKnownNumShards, false)
+			return
+		}
+		s.setKnownNumShards(config.NumShards)
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDataChanged {
+			s.watchKnownNumShards(sess)
+		}
+	})
+}
+
+func (s *Sharding) isConfigReady() bool {
+	if !s.dynamicNumShards {
+		return true
+	}
+	return s.state.configFetched
+}
+
+// getAssignNumShards returns the number of shards written to assign znodes
+func (s *Sharding) getAssignNumShards() ShardID {
+	if !s.dynamicNumShards {
+		return 0
+	}
+	return s.state.numShards
+}
+
+// ========================================
+// Observer Logic
+// ========================================
+
+func (c *observerCore) watchConfig(sess *curator.Session) {
+	sess.GetClient().GetW(c.parent+configZNodeName, func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(c.watchConfig)
+				return
+			}
+			c.errs.handle(sess, &UnexpectedError{Op: OpGet, Path: c.parent + configZNodeName, Err: err},
+				c.watchConfig, false)
+			return
+		}
+
+		config, err := unmarshalConfigData(resp.Data)
+		if err != nil {
+			c.errs.handle(sess, &UnexpectedError{Op: OpUnmarshal, Path: c.parent + configZNodeName, Err: err},
+				c.watchConfig, false)
+			return
+		}
+		numShards := config.NumShards
+		c.configFetched = true
+		if numShards != c.numShards {
+			// the resize is computed from the number of shards of the last notified event
+			oldNumShards := c.numShards
+			if c.resize != nil {
+				oldNumShards = c.resize.OldNumShards
+			}
+			c.resize = nil
+			if oldNumShards != numShards {
+				c.resize = newShardResize(oldNumShards, numShards)
+			}
+			c.numShards = numShards
+		}
+		c.notifyObserver()
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDataChanged {
+			c.watchConfig(sess)
+		}
+	})
+}
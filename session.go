@@ -0,0 +1,258 @@
+package sharding
+
+import (
+	"errors"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+// sessionRunner is passed to the client factory instead of the curator. It wraps the client of every session,
+// so that the library can drop the current session and begin a new one with the same zookeeper client,
+// see ErrorActionRestartSession. All methods are called on the goroutine of the zookeeper client.
+type sessionRunner struct {
+	cur *curator.Curator
+
+	// beforeBegin deletes the ephemeral znodes of the dropped session before the new session begins, can be nil
+	beforeBegin func(client curator.Client, callback func(err error))
+
+	client     curator.Client
+	active     *sessionClient
+	restarting bool
+}
+
+func newSessionRunner(cur *curator.Curator) *sessionRunner {
+	return &sessionRunner{cur: cur}
+}
+
+// Begin is called by the client factory when a new zookeeper session is established
+func (r *sessionRunner) Begin(client curator.Client) {
+	r.client = client
+	r.restarting = false
+	r.beginSession()
+}
+
+// Retry is called by the client factory when the connection is re-established
+func (r *sessionRunner) Retry() {
+	if r.restarting {
+		r.deleteAndBegin()
+		return
+	}
+	r.cur.Retry()
+}
+
+// End is called by the client factory when the zookeeper session is expired
+func (r *sessionRunner) End() {
+	r.closeActive()
+	r.client = nil
+	r.restarting = false
+	r.cur.End()
+}
+
+// restart drops the current session, same as a session expiry, then begins a new one with the same client
+func (r *sessionRunner) restart() {
+	if r.client == nil || r.restarting {
+		return
+	}
+	r.closeActive()
+	r.restarting = true
+	r.deleteAndBegin()
+}
+
+func (r *sessionRunner) deleteAndBegin() {
+	if r.beforeBegin == nil {
+		r.restarting = false
+		r.beginSession()
+		return
+	}
+
+	client := r.client
+	r.beforeBegin(client, func(err error) {
+		if r.client != client || !r.restarting {
+			return
+		}
+		if errors.Is(err, zk.ErrConnectionClosed) {
+			// continued by Retry
+			return
+		}
+		r.restarting = false
+		r.beginSession()
+	})
+}
+
+func (r *sessionRunner) beginSession() {
+	r.closeActive()
+	r.active = &sessionClient{client: r.client}
+	r.cur.Begin(r.active)
+}
+
+func (r *sessionRunner) closeActive() {
+	if r.active != nil {
+		r.active.closed = true
+		r.active = nil
+	}
+}
+
+// sessionClient is the client of a session begun by sessionRunner. After the session is dropped,
+// new requests are NOT sent, requests in flight are responded with zk.ErrConnectionClosed,
+// and watch events are ignored, same as the requests of an expired session.
+type sessionClient struct {
+	client curator.Client
+	closed bool
+}
+
+var _ curator.Client = &sessionClient{}
+
+func (c *sessionClient) watch(watcher func(ev zk.Event)) func(ev zk.Event) {
+	return func(ev zk.Event) {
+		if c.closed {
+			return
+		}
+		watcher(ev)
+	}
+}
+
+func (c *sessionClient) Get(path string, callback func(resp zk.GetResponse, err error)) {
+	if c.closed {
+		return
+	}
+	c.client.Get(path, func(resp zk.GetResponse, err error) {
+		if c.closed {
+			callback(zk.GetResponse{}, zk.ErrConnectionClosed)
+			return
+		}
+		callback(resp, err)
+	})
+}
+
+func (c *sessionClient) GetW(path string,
+	callback func(resp zk.GetResponse, err error),
+	watcher func(ev zk.Event),
+) {
+	if c.closed {
+		return
+	}
+	c.client.GetW(path, func(resp zk.GetResponse, err error) {
+		if c.closed {
+			callback(zk.GetResponse{}, zk.ErrConnectionClosed)
+			return
+		}
+		callback(resp, err)
+	}, c.watch(watcher))
+}
+
+func (c *sessionClient) Children(path string, callback func(resp zk.ChildrenResponse, err error)) {
+	if c.closed {
+		return
+	}
+	c.client.Children(path, func(resp zk.ChildrenResponse, err error) {
+		if c.closed {
+			callback(zk.ChildrenResponse{}, zk.ErrConnectionClosed)
+			return
+		}
+		callback(resp, err)
+	})
+}
+
+func (c *sessionClient) ChildrenW(path string,
+	callback func(resp zk.ChildrenResponse, err error),
+	watcher func(ev zk.Event),
+) {
+	if c.closed {
+		return
+	}
+	c.client.ChildrenW(path, func(resp zk.ChildrenResponse, err error) {
+		if c.closed {
+			callback(zk.ChildrenResponse{}, zk.ErrConnectionClosed)
+			return
+		}
+		callback(resp, err)
+	}, c.watch(watcher))
+}
+
+func (c *sessionClient) Create(
+	path string, data []byte, flags int32,
+	callback func(resp zk.CreateResponse, err error),
+) {
+	if c.closed {
+		return
+	}
+	c.client.Create(path, data, flags, func(resp zk.CreateResponse, err error) {
+		if c.closed {
+			callback(zk.CreateResponse{}, zk.ErrConnectionClosed)
+			return
+		}
+		callback(resp, err)
+	})
+}
+
+func (c *sessionClient) Set(
+	path string, data []byte, version int32,
+	callback func(resp zk.SetResponse, err error),
+) {
+	if c.closed {
+		return
+	}
+	c.client.Set(path, data, version, func(resp zk.SetResponse, err error) {
+		if c.closed {
+			callback(zk.SetResponse{}, zk.ErrConnectionClosed)
+			return
+		}
+		callback(resp, err)
+	})
+}
+
+func (c *sessionClient) Delete(path string, version int32, callback func(resp zk.DeleteResponse, err error)) {
+	if c.closed {
+		return
+	}
+	c.client.Delete(path, version, func(resp zk.DeleteResponse, err error) {
+		if c.closed {
+			callback(zk.DeleteResponse{}, zk.ErrConnectionClosed)
+			return
+		}
+		callback(resp, err)
+	})
+}
+
+// dropSession releases the shards of the current node and deletes its ephemeral znodes,
+// before the new session begun by ErrorActionRestartSession.
+// The other znodes are created only after the node znode, so they are NOT deleted if it is NOT owned.
+func (s *Sharding) dropSession(
+	controller *containerNodeController, client curator.Client, callback func(err error),
+) {
+	s.setCurrentSession(nil)
+	for _, o := range s.getOwners() {
+		o.reset()
+	}
+
+	controller.deleteOwnedNode(client, func(owned bool, err error) {
+		if err != nil || !owned {
+			callback(err)
+			return
+		}
+
+		var firstErr error
+		counter := newCallbackCounter(func() {
+			callback(firstErr)
+		})
+		onDeleted := func(done func()) func(err error) {
+			return func(err error) {
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				done()
+			}
+		}
+
+		done := counter.begin()
+		if s.owner != nil && s.shardStatus {
+			deleteIfExists(client, s.getStatusPath()+"/"+s.nodeID, onDeleted(counter.begin()))
+		}
+		if s.loadReportFunc != nil && !s.coordinator {
+			deleteIfExists(client, s.getLoadsPath()+"/"+s.nodeID, onDeleted(counter.begin()))
+		}
+		s.deleteLockNodes(client, onDeleted(counter.begin()))
+		done()
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"slices"
+	"sync"
+	"time"
 
 	"github.com/QuangTung97/zk"
 	"github.com/QuangTung97/zk/concurrency"
@@ -18,31 +20,158 @@ type Sharding struct {
 	nodeID     string
 	numShards  ShardID
 	nodeAddr   string
+	nodeWeight uint32
+
+	nodeTopology *Topology
+
+	// coordinator is true if created by NewCoordinator
+	coordinator bool
+
+	replicationFactor int
+
+	twoPhaseHandoff bool
+	shardStatus     bool
+	shardActivation bool
+	owner           *shardOwner
+
+	shardListener ShardListener
+	shardHandler  ShardHandler
+
+	sessionLossTimeout time.Duration
+	onSessionLost      func()
+	lease              *sessionLease
+
+	// shutdownStarted, shutdownFinished and shutdown are written under sessMut.
+	// While Shutdown is running, shutdown and its fields except timedOut are accessed
+	// on the goroutine of the zookeeper client without the lock.
+	shutdownStarted  bool
+	shutdownFinished bool
+	shutdown         *shutdownState
+
+	// joinedSeq is the sessionSeq of the latest session whose node znode has been created, see onJoined
+	joinedSeq int
+
+	// leaderStopped is true after Shutdown, the leader no longer writes assignments
+	leaderStopped bool
 
 	logger zk.Logger
 
-	cur *curator.Curator
+	cur *sessionRunner
 
-	obs *observerCore
+	observerFunc ObserverFunc
+	obs          *observerCore
 
 	state *sessionState
 
+	allocator Allocator
+
+	maxMovesPerRound     int
+	minRebalanceInterval time.Duration
+	lastRebalanceTime    time.Time
+
+	nodeGracePeriod time.Duration
+
+	shardPinning bool
+	nodeDraining bool
+
+	dynamicNumShards bool
+
+	loadReportInterval time.Duration
+	loadReportFunc     LoadReportFunc
+
+	shardWeights map[ShardID]uint32
+
+	fencingEpochs bool
+
+	duplicatePolicy DuplicateNodePolicy
+	duplicateErr    error
+
+	// groups are the named shard groups added by WithShardGroup, group is the name of the current group
+	groupConfigs []shardGroupConfig
+	groups       []*Sharding
+	group        string
+
+	now       func() time.Time
+	afterFunc func(d time.Duration, fn func())
+
+	// sessionSeq is increased on every new zookeeper session, for ignoring timers of previous sessions
+	sessionSeq int
+
+	// currentSess is the latest zookeeper session, used by the methods that can be called from other goroutines
+	sessMut        sync.Mutex
+	currentSess    *curator.Session
+	configErr      error
+	knownNumShards ShardID
+
+	shardFunc             ShardFunc
+	nonResizableShardFunc bool // set by WithNonResizableShardFunc
+
+	errorFunc       ErrorHandler
+	errorRetryDelay time.Duration
+	errs            *errorHandler
+
 	lockBegin func(sess *curator.Session)
 
 	clientID curator.FakeClientID
 }
 
 type assignState struct {
-	version int32
-	shards  []ShardID
+	version  int32
+	mzxid    int64
+	shards   []ShardID
+	replicas []ShardID
+
+	// numShards is the number of shards written in the assign znode,
+	// basis is the number of shards that the field shards is computed for, after converted by the leader
+	numShards ShardID
+	basis     ShardID
+
+	// epochs are the fencing epochs of shards, only with WithFencingEpochs
+	epochs map[ShardID]int64
 }
 
 type sessionState struct {
 	nodes            []string
 	currentAssignMap map[string]assignState
+	nodeDataMap      map[string]*leaderNodeData
+	statusMap        map[string]*leaderNodeStatus
 
 	getAssignNodesCompleted  bool
 	listActiveNodesCompleted bool
+	listStatusCompleted      bool
+
+	// assignWritesInFlight and assignsRelisting are used to know whether currentAssignMap is up-to-date
+	assignWritesInFlight int
+	assignsRelisting     bool
+
+	// assignHistory is NOT reset when listing the assign znodes again, only with WithTwoPhaseHandoff
+	assignHistory map[string]*assignHistory
+
+	// rebalanceAt is the time of the next scheduled run of handleNodesChanged
+	rebalanceAt time.Time
+
+	// vanishedAt is the time that the leader first saw an assigned node disappeared
+	vanishedAt map[string]time.Time
+
+	// pins is the content of the overrides znode, only fetched when configured with WithShardPinning
+	pins             map[ShardID]string
+	overridesFetched bool
+
+	// draining is the set of children of the drains znode, only fetched when configured with WithNodeDraining
+	draining      map[string]struct{}
+	drainsFetched bool
+
+	// numShards is the number of shards in the config znode when configured with WithDynamicNumShards
+	numShards     ShardID
+	configFetched bool
+
+	// loads are the load reports of nodes, only read when configured with WithLoadReporting
+	loads map[string]loadData
+}
+
+type leaderNodeData struct {
+	data    nodeData
+	fetched bool
 }
 
 // NewNodeID creates a random node id with hex encoding and length = 16 bytes
@@ -67,40 +196,238 @@ func New(
 	if len(nodeAddr) == 0 {
 		panic("Invalid node address")
 	}
+	return newSharding(parentPath, nodeID, numShards, nodeAddr, false, options...)
+}
+
+// NewCoordinator creates a Sharding object that only competes for the leader lock with the nodes,
+// without registering itself as a node, so no shards are ever assigned to it.
+// Options about the current node are ignored, the coordinatorID is only used for the leader lock.
+func NewCoordinator(
+	parentPath string, coordinatorID string,
+	numShards ShardID, options ...Option,
+) *Sharding {
+	if len(coordinatorID) == 0 {
+		panic("Invalid coordinator id")
+	}
+	return newSharding(parentPath, coordinatorID, numShards, "", true, options...)
+}
 
+func newSharding(
+	parentPath string, nodeID string,
+	numShards ShardID, nodeAddr string,
+	coordinator bool, options ...Option,
+) *Sharding {
 	s := &Sharding{
 		parentPath: parentPath,
 		nodeID:     nodeID,
 		numShards:  numShards,
 		nodeAddr:   nodeAddr,
 
-		logger: &defaultLoggerImpl{},
+		coordinator: coordinator,
+
+		logger:    &defaultLoggerImpl{},
+		allocator: NewDefaultAllocator(),
+
+		replicationFactor: 1,
+
+		knownNumShards: numShards,
+		shardFunc:      FNVModuloShardFunc,
+
+		now: time.Now,
+		afterFunc: func(d time.Duration, fn func()) {
+			time.AfterFunc(d, fn)
+		},
 	}
 
 	for _, fn := range options {
 		fn(s)
 	}
+	s.validateOptions()
+
+	s.errs = newErrorHandler(parentPath, s.errorFunc, s.errorRetryDelay)
+	s.errs.now = func() time.Time {
+		return s.now()
+	}
+	s.errs.afterFunc = func(d time.Duration, fn func()) {
+		s.afterFunc(d, fn)
+	}
+
+	s.initShardOwner()
+	s.initSessionLease()
 
-	controller := newContainerNodeController(parentPath, nodeID, nodeAddr)
+	s.initShardGroups()
+
+	s.initObserver()
+
+	controller := newContainerNodeController(parentPath, s.getNodeZNodeName(), nodeData{
+		Address:  nodeAddr,
+		Weight:   s.nodeWeight,
+		Topology: s.nodeTopology,
+	}, s.getExtraContainerNames()...)
+	controller.errs = s.errs
+	controller.duplicatePolicy = s.duplicatePolicy
+	controller.onDuplicate = s.setDuplicateNodeError
+	controller.isLeaving = s.isShutdownStarted
+	s.setupConfigController(controller)
 
 	lock := concurrency.NewLock(s.getLockPath(), nodeID)
 
 	startLeader := func(sess *curator.Session, next func(sess *curator.Session)) {
 		lock.Start(sess, next)
+		if s.dynamicNumShards {
+			s.watchKnownNumShards(sess)
+		}
 		if s.obs != nil {
 			s.obs.onStart(sess)
 		}
+		for _, g := range s.groups {
+			if g.obs != nil {
+				g.obs.onStart(sess)
+			}
+		}
+		for _, o := range s.getOwners() {
+			o.onStart(sess)
+		}
+		if s.loadReportFunc != nil && !s.coordinator {
+			s.startLoadReporting(sess)
+		}
+		s.onJoined(sess)
 	}
 
-	s.cur = curator.NewChain(
-		controller.onStart,
+	startInit := func(sess *curator.Session, next func(sess *curator.Session)) {
+		s.sessionSeq++
+		s.errs.onSessionStart()
+		s.setCurrentSession(sess)
+		// the shards of the previous session are no longer owned
+		for _, o := range s.getOwners() {
+			o.reset()
+		}
+		if s.lease != nil {
+			s.lease.start(sess)
+		}
+		controller.onStart(sess, next)
+	}
+
+	s.cur = newSessionRunner(curator.NewChain(
+		startInit,
 		startLeader,
 		s.onLeaderCallback,
-	)
+	))
+	s.cur.beforeBegin = func(client curator.Client, callback func(err error)) {
+		s.dropSession(controller, client, callback)
+	}
+	s.errs.restartSession = s.cur.restart
 
 	return s
 }
 
+// initShardOwner creates the owner watching the assign znode of the current node, except for a coordinator
+func (s *Sharding) initShardOwner() {
+	if s.coordinator {
+		return
+	}
+	s.owner = newShardOwner(s.parentPath, s.nodeID, s.twoPhaseHandoff, s.shardStatus, s.errs)
+	s.owner.listener = s.shardListener
+	s.owner.activation = s.shardActivation
+	s.owner.afterFunc = func(d time.Duration, fn func()) {
+		s.afterFunc(d, fn)
+	}
+	if s.shardHandler != nil {
+		s.owner.setHandler(s.shardHandler)
+	}
+}
+
+// initObserver creates the observer of WithShardingObserver
+func (s *Sharding) initObserver() {
+	if s.observerFunc == nil {
+		return
+	}
+	s.obs = newObserverCore(s.parentPath, s.numShards, s.observerFunc)
+	s.obs.shardStatus = s.shardStatus
+	s.obs.dynamicNumShards = s.dynamicNumShards
+	s.obs.shardFunc = s.shardFunc
+	s.obs.shardWeights = s.shardWeights
+	s.obs.fencingEpochs = s.fencingEpochs
+	s.obs.errs = s.errs
+	s.obs.now = func() time.Time {
+		return s.now()
+	}
+	s.obs.afterFunc = func(d time.Duration, fn func()) {
+		s.afterFunc(d, fn)
+	}
+}
+
+// initSessionLease creates the lease for WithSessionLossTimeout, suspending the owners when the session is lost
+func (s *Sharding) initSessionLease() {
+	if s.sessionLossTimeout == 0 {
+		return
+	}
+
+	s.lease = newSessionLease(s.parentPath, s.sessionLossTimeout, s.onSessionLost)
+	s.lease.now = func() time.Time {
+		return s.now()
+	}
+	s.lease.afterFunc = func(d time.Duration, fn func()) {
+		s.afterFunc(d, fn)
+	}
+
+	s.lease.suspend = func() {
+		for _, o := range s.getOwners() {
+			o.suspend()
+		}
+	}
+	s.lease.resume = func(sess *curator.Session) {
+		for _, o := range s.getOwners() {
+			o.resume(sess)
+		}
+	}
+}
+
+// getNodeZNodeName returns the name of the node znode of the current node, empty for a coordinator
+func (s *Sharding) getNodeZNodeName() string {
+	if s.coordinator {
+		return ""
+	}
+	return s.nodeID
+}
+
+// validateOptions checks the combinations of options that can NOT be used together
+func (s *Sharding) validateOptions() {
+	if s.dynamicNumShards && s.nonResizableShardFunc {
+		panic("Invalid shard func with dynamic number of shards")
+	}
+}
+
+// getExtraContainerNames returns the optional container nodes needed by the configured options
+func (s *Sharding) getExtraContainerNames() []string {
+	var extraNames []string
+	if s.shardStatus {
+		extraNames = append(extraNames, statusZNodeName)
+	}
+	if s.shardPinning {
+		extraNames = append(extraNames, overridesZNodeName)
+	}
+	if s.nodeDraining {
+		extraNames = append(extraNames, drainsZNodeName)
+	}
+	if s.loadReportFunc != nil {
+		extraNames = append(extraNames, loadsZNodeName)
+	}
+	return append(extraNames, getGroupContainerNames(s.groupConfigs)...)
+}
+
+func (s *Sharding) setCurrentSession(sess *curator.Session) {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	s.currentSess = sess
+}
+
+func (s *Sharding) getCurrentSession() *curator.Session {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	return s.currentSess
+}
+
 func (s *Sharding) getLockPath() string {
 	return s.parentPath + lockZNodeName
 }
@@ -110,22 +437,61 @@ func (s *Sharding) getNodesPath() string {
 }
 
 func (s *Sharding) getAssignsPath() string {
+	if len(s.group) > 0 {
+		return s.parentPath + getGroupAssignsName(s.group)
+	}
 	return s.parentPath + assignZNodeName
 }
 
 func (s *Sharding) onLeaderCallback(sess *curator.Session, _ func(sess *curator.Session)) {
+	if s.leaderStopped {
+		// the lock is granted again after Shutdown, e.g. the lock znode is recreated by the lock recipe
+		s.deleteLockNodes(sess.GetClient(), func(err error) {})
+		return
+	}
+
 	s.logger.Infof("Leader Started")
 
+	s.startLeaderState(sess)
+	for _, g := range s.groups {
+		g.startLeaderState(sess)
+	}
+}
+
+func (s *Sharding) startLeaderState(sess *curator.Session) {
 	s.state = &sessionState{
 		currentAssignMap: map[string]assignState{},
+		nodeDataMap:      map[string]*leaderNodeData{},
+		statusMap:        map[string]*leaderNodeStatus{},
+		assignHistory:    map[string]*assignHistory{},
+		vanishedAt:       map[string]time.Time{},
+		numShards:        s.numShards,
+		loads:            map[string]loadData{},
 	}
 	s.listAssignNodes(sess)
 	s.listActiveNodes(sess)
+	if s.twoPhaseHandoff {
+		s.listNodeStatuses(sess)
+	}
+	if s.shardPinning {
+		s.watchOverrides(sess)
+	}
+	if s.nodeDraining {
+		s.listDrainingNodes(sess)
+	}
+	if s.dynamicNumShards {
+		s.watchConfig(sess)
+	}
+	if s.loadReportFunc != nil {
+		s.scheduleLoadRefresh(sess)
+	}
 }
 
 func (s *Sharding) getAssignNodeData(sess *curator.Session, nodeID string, counter *callbackCounter) {
+	pathVal := s.getNodeAssignPath(nodeID)
+
 	finish := counter.begin()
-	sess.GetClient().Get(s.getAssignsPath()+"/"+nodeID, func(resp zk.GetResponse, err error) {
+	sess.GetClient().Get(pathVal, func(resp zk.GetResponse, err error) {
 		defer finish()
 
 		if err != nil {
@@ -136,33 +502,70 @@ func (s *Sharding) getAssignNodeData(sess *curator.Session, nodeID string, count
 			if errors.Is(err, zk.ErrNoNode) {
 				return
 			}
-			panic(err)
+			s.handleError(sess, OpGet, pathVal, err, s.listAssignNodes, false)
+			counter.cancel()
+			return
 		}
-
 		var assign assignData
 		if err := json.Unmarshal(resp.Data, &assign); err != nil {
-			panic(err)
+			// a skipped assign znode is treated as empty, and will be overwritten by the leader
+			if !s.handleError(sess, OpUnmarshal, pathVal, err, s.listAssignNodes, true) {
+				counter.cancel()
+				return
+			}
+			assign = assignData{}
 		}
-		s.putNodeAssignState(nodeID, resp.Stat.Version, assign.Shards)
+		err = s.putNodeAssignState(nodeID, resp.Stat.Version, resp.Stat.Mzxid, assign)
+		s.retryListAssignsIfErr(sess, OpGet, pathVal, err, counter)
 	})
 }
 
-func (s *Sharding) putNodeAssignState(nodeID string, version int32, shards []ShardID) {
+// putNodeAssignState returns ErrOutOfOrderResponse if the version is older than the known one,
+// then the assign znodes should be listed again
+func (s *Sharding) putNodeAssignState(nodeID string, version int32, mzxid int64, assign assignData) error {
 	old := s.state.currentAssignMap[nodeID]
 	if old.version > version {
-		panic("out of order responses")
+		return ErrOutOfOrderResponse
 	}
 
-	s.state.currentAssignMap[nodeID] = assignState{
-		version: version,
-		shards:  shards,
+	state := assignState{
+		version:  version,
+		mzxid:    mzxid,
+		shards:   assign.Shards,
+		replicas: assign.Replicas,
+
+		numShards: assign.NumShards,
+		basis:     assign.NumShards,
+	}
+	if s.fencingEpochs {
+		state.epochs = resolveEpochs(assign, mzxid)
+	}
+	if s.state.configFetched {
+		if state.basis != s.state.numShards {
+			// shards of a different number of shards get new epochs
+			state.epochs = nil
+		}
+		state.shards = mapShards(state.shards, state.basis, s.state.numShards)
+		state.replicas = mapShards(state.replicas, state.basis, s.state.numShards)
+		state.basis = s.state.numShards
 	}
+	s.state.currentAssignMap[nodeID] = state
+	s.trackRevokedShards(nodeID, state)
+	return nil
 }
 
 func (s *Sharding) startHandleNodeChanges(sess *curator.Session) {
-	if s.state.listActiveNodesCompleted && s.state.getAssignNodesCompleted {
-		s.handleNodesChanged(sess)
+	if s.leaderStopped {
+		return
+	}
+	if !s.state.listActiveNodesCompleted || !s.state.getAssignNodesCompleted {
+		return
+	}
+	if !s.isNodeDataReady() || !s.isNodeStatusReady() || !s.isOverridesReady() || !s.isDrainsReady() ||
+		!s.isConfigReady() {
+		return
 	}
+	s.handleNodesChanged(sess)
 }
 
 type callbackCounter struct {
@@ -186,6 +589,11 @@ func (c *callbackCounter) begin() func() {
 	}
 }
 
+// cancel disables the callback, the work is retried by the error handler instead
+func (c *callbackCounter) cancel() {
+	c.callback = func() {}
+}
+
 func (c *callbackCounter) addRetry(sess *curator.Session, fn func(sess *curator.Session)) {
 	c.callback = func() {
 		sess.AddRetry(fn)
@@ -193,11 +601,13 @@ func (c *callbackCounter) addRetry(sess *curator.Session, fn func(sess *curator.
 }
 
 func (s *Sharding) listAssignNodes(sess *curator.Session) {
-	sessMustChildren(sess, s.getAssignsPath(), func(resp zk.ChildrenResponse) {
+	s.state.assignsRelisting = true
+	sessMustChildren(sess, s.errs, s.getAssignsPath(), func(resp zk.ChildrenResponse) {
 		s.state.currentAssignMap = map[string]assignState{}
 
 		counter := newCallbackCounter(func() {
 			s.state.getAssignNodesCompleted = true
+			s.state.assignsRelisting = false
 			s.startHandleNodeChanges(sess)
 		})
 
@@ -205,6 +615,7 @@ func (s *Sharding) listAssignNodes(sess *curator.Session) {
 		for _, nodeID := range resp.Children {
 			s.getAssignNodeData(sess, nodeID, counter)
 		}
+		s.listLoadReports(sess, counter)
 		fn()
 	})
 }
@@ -216,12 +627,15 @@ func (s *Sharding) listActiveNodes(sess *curator.Session) {
 				sess.AddRetry(s.listActiveNodes)
 				return
 			}
-			panic(err)
+			s.handleError(sess, OpChildren, s.getNodesPath(), err, s.listActiveNodes, false)
+			return
 		}
 
 		s.state.nodes = resp.Children
 		slices.Sort(s.state.nodes)
 
+		s.fetchActiveNodesData(sess)
+
 		s.state.listActiveNodesCompleted = true
 		s.startHandleNodeChanges(sess)
 	}, func(ev zk.Event) {
@@ -231,6 +645,103 @@ func (s *Sharding) listActiveNodes(sess *curator.Session) {
 	})
 }
 
+func (s *Sharding) fetchActiveNodesData(sess *curator.Session) {
+	for _, nodeID := range s.state.nodes {
+		_, ok := s.state.nodeDataMap[nodeID]
+		if ok {
+			continue
+		}
+		s.state.nodeDataMap[nodeID] = &leaderNodeData{}
+		s.getActiveNodeData(sess, nodeID)
+	}
+}
+
+func (s *Sharding) getActiveNodeData(sess *curator.Session, nodeID string) {
+	pathVal := s.getNodesPath() + "/" + nodeID
+	retry := func(sess *curator.Session) {
+		s.getActiveNodeData(sess, nodeID)
+	}
+
+	sess.GetClient().GetW(pathVal, func(resp zk.GetResponse, err error) {
+		if err != nil {
+			s.handleActiveNodeDataError(sess, nodeID, pathVal, err, retry)
+			return
+		}
+
+		var data nodeData
+		if err := json.Unmarshal(resp.Data, &data); err != nil {
+			if !s.handleError(sess, OpUnmarshal, pathVal, err, retry, true) {
+				return
+			}
+			data = nodeData{}
+		}
+		s.putActiveNodeData(sess, nodeID, data)
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDataChanged {
+			s.getActiveNodeData(sess, nodeID)
+		} else if ev.Type == zk.EventNodeDeleted {
+			delete(s.state.nodeDataMap, nodeID)
+		}
+	})
+}
+
+func (s *Sharding) handleActiveNodeDataError(
+	sess *curator.Session, nodeID string, pathVal string,
+	err error, retry func(sess *curator.Session),
+) {
+	if errors.Is(err, zk.ErrConnectionClosed) {
+		sess.AddRetry(retry)
+		return
+	}
+	if errors.Is(err, zk.ErrNoNode) {
+		// will be fetched again after the list of nodes changed
+		delete(s.state.nodeDataMap, nodeID)
+		return
+	}
+	if s.handleError(sess, OpGet, pathVal, err, retry, true) {
+		// a skipped node has the default weight and topology
+		s.putActiveNodeData(sess, nodeID, nodeData{})
+	}
+}
+
+func (s *Sharding) putActiveNodeData(sess *curator.Session, nodeID string, data nodeData) {
+	info, ok := s.state.nodeDataMap[nodeID]
+	if !ok {
+		return
+	}
+	info.data = data
+	info.fetched = true
+	s.startHandleNodeChanges(sess)
+}
+
+func (s *Sharding) isNodeDataReady() bool {
+	for _, nodeID := range s.state.nodes {
+		info, ok := s.state.nodeDataMap[nodeID]
+		if !ok || !info.fetched {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Sharding) getAllocateNodes() []AllocateNode {
+	assignable := s.getAssignableNodes()
+	nodes := make([]AllocateNode, 0, len(assignable))
+	for _, nodeID := range assignable {
+		var data nodeData
+		info, ok := s.state.nodeDataMap[nodeID]
+		if ok {
+			data = info.data
+		}
+		nodes = append(nodes, AllocateNode{
+			ID:       nodeID,
+			Weight:   data.getWeight(),
+			Topology: data.getTopology(),
+		})
+	}
+	return nodes
+}
+
 func (s *Sharding) getNodesSorted() []string {
 	nodes := slices.Clone(s.state.nodes)
 	slices.SortStableFunc(nodes, func(a, b string) int {
@@ -248,7 +759,7 @@ func (s *Sharding) computeFreeShards() (map[ShardID]struct{}, []string) {
 	}
 
 	freeShards := map[ShardID]struct{}{}
-	for id := 0; id < int(s.numShards); id++ {
+	for id := 0; id < int(s.state.numShards); id++ {
 		freeShards[ShardID(id)] = struct{}{}
 	}
 
@@ -264,27 +775,63 @@ func (s *Sharding) computeFreeShards() (map[ShardID]struct{}, []string) {
 	return freeShards, deleted
 }
 
+func (s *Sharding) getCurrentShards() map[string][]ShardID {
+	current := make(map[string][]ShardID, len(s.state.currentAssignMap))
+	for nodeID, assign := range s.state.currentAssignMap {
+		current[nodeID] = slices.Clone(assign.shards)
+	}
+	return current
+}
+
 func (s *Sharding) handleNodesChanged(sess *curator.Session) {
-	n := ShardID(len(s.state.nodes))
-	minShardsPerNode := s.numShards / n
-	maxShardsPerNode := (s.numShards + n - 1) / n
-	numMax := s.numShards - minShardsPerNode*n
+	if s.leaderStopped {
+		return
+	}
+
+	graceNodes := s.computeGraceNodes(sess)
+	pinned := s.getActivePins()
+	reserved := slices.DeleteFunc(s.getReservedShards(graceNodes), func(id ShardID) bool {
+		_, ok := pinned[id]
+		return ok
+	})
+
+	desired, ok := s.allocate(sess, AllocateInput{
+		Nodes:     s.getAllocateNodes(),
+		Current:   s.getCurrentShards(),
+		NumShards: s.state.numShards,
+		Reserved:  reserved,
+		Pinned:    pinned,
+		Loads:     s.getShardLoads(),
+
+		ShardWeights: s.shardWeights,
+	})
+	if !ok {
+		return
+	}
+	desired = s.limitMoves(sess, desired, pinned)
 
 	nodes := s.getNodesSorted()
 
 	_, deletedNodes := s.computeFreeShards()
-	allocated := map[ShardID]struct{}{}
+	deletedNodes = slices.DeleteFunc(deletedNodes, func(nodeID string) bool {
+		_, ok := graceNodes[nodeID]
+		return ok
+	})
 
 	counter := newCallbackCounter(func() {
 		s.handleNodesChanged(sess)
 	})
 
-	for i := 0; i < int(numMax); i++ {
-		s.updateIfChanged(sess, nodes[i], int(maxShardsPerNode), allocated, counter)
-	}
+	replicas := s.placeReplicas(desired)
+	granted := s.computeGrantedShards(desired)
 
-	for i := int(numMax); i < int(n); i++ {
-		s.updateIfChanged(sess, nodes[i], int(minShardsPerNode), allocated, counter)
+	for _, nodeID := range nodes {
+		s.updateIfChanged(sess, nodeID, assignData{
+			Shards:    granted[nodeID],
+			Replicas:  replicas[nodeID],
+			NumShards: s.getAssignNumShards(),
+			Epochs:    s.getKeptEpochs(nodeID, granted[nodeID]),
+		}, counter)
 	}
 
 	slices.Sort(deletedNodes)
@@ -293,76 +840,41 @@ func (s *Sharding) handleNodesChanged(sess *curator.Session) {
 	}
 }
 
-func computeFreeShards(allocated map[ShardID]struct{}, numShards ShardID) []ShardID {
-	var list []ShardID
-	for id := ShardID(0); id < numShards; id++ {
-		_, ok := allocated[id]
-		if ok {
-			continue
-		}
-		list = append(list, id)
+// allocate runs the configured allocator. An invalid result is reported to the error handler,
+// and is replaced by the result of the default allocator if skipped.
+func (s *Sharding) allocate(sess *curator.Session, input AllocateInput) (map[string][]ShardID, bool) {
+	desired := s.allocator.Allocate(input)
+	err := validateAllocation(input, desired)
+	if err == nil {
+		return desired, true
 	}
-	return list
+	if !s.handleError(sess, OpAllocate, s.getAssignsPath(), err, s.handleNodesChanged, true) {
+		return nil, false
+	}
+	return NewDefaultAllocator().Allocate(input), true
 }
 
 func (s *Sharding) updateIfChanged(
-	sess *curator.Session, nodeID string, expectLen int,
-	allocatedShards map[ShardID]struct{},
+	sess *curator.Session, nodeID string, assign assignData,
 	counter *callbackCounter,
 ) {
-	oldShards := s.state.currentAssignMap[nodeID].shards
-	current := make([]ShardID, 0, len(oldShards))
-	for _, id := range oldShards {
-		_, existed := allocatedShards[id]
-		if existed {
-			continue
-		}
-		current = append(current, id)
-	}
-	slices.Sort(current)
-
-	addToAllocated := func(curr []ShardID) {
-		for _, id := range curr {
-			allocatedShards[id] = struct{}{}
+	old := s.state.currentAssignMap[nodeID]
+	if shardsEqualUnordered(old.shards, assign.Shards) && shardsEqualUnordered(old.replicas, assign.Replicas) {
+		if !s.isAssignOutdated(nodeID) && old.numShards == assign.NumShards {
+			return
 		}
 	}
+	s.upsertAssigns(sess, nodeID, assign, counter)
+}
 
-	if len(current) > expectLen {
-		current = slices.Clone(current[:expectLen])
-		addToAllocated(current)
-		s.upsertAssigns(sess, nodeID, current, counter)
-		return
-	}
-	if len(current) < expectLen {
-		addToAllocated(current)
-
-		list := computeFreeShards(allocatedShards, s.numShards)
-		missing := expectLen - len(current)
-
-		addToAllocated(list[:missing])
-
-		current = slices.Clone(append(current, list[:missing]...))
-		s.upsertAssigns(sess, nodeID, current, counter)
-		return
-	}
+func shardsEqualUnordered(a, b []ShardID) bool {
+	a = slices.Clone(a)
+	slices.Sort(a)
 
-	// No Change
-	addToAllocated(current)
-	oldShards = slices.Clone(oldShards)
-	slices.Sort(oldShards)
-	if !slices.Equal(oldShards, current) {
-		s.upsertAssigns(sess, nodeID, current, counter)
-	}
-}
+	b = slices.Clone(b)
+	slices.Sort(b)
 
-func marshalAssignNodeData(shards []ShardID) []byte {
-	data, err := json.Marshal(assignData{
-		Shards: shards,
-	})
-	if err != nil {
-		panic(err)
-	}
-	return data
+	return slices.Equal(a, b)
 }
 
 func (s *Sharding) getNodeAssignPath(nodeID string) string {
@@ -370,25 +882,28 @@ func (s *Sharding) getNodeAssignPath(nodeID string) string {
 }
 
 func (s *Sharding) upsertAssigns(
-	sess *curator.Session, nodeID string, shards []ShardID,
+	sess *curator.Session, nodeID string, assign assignData,
 	counter *callbackCounter,
 ) {
 	prev, ok := s.state.currentAssignMap[nodeID]
 	if ok {
-		s.updateAssignNode(sess, nodeID, shards, prev, counter)
+		s.updateAssignNode(sess, nodeID, assign, prev, counter)
 	} else {
-		s.createAssignNode(sess, nodeID, shards, counter)
+		s.createAssignNode(sess, nodeID, assign, counter)
 	}
 }
 
 func (s *Sharding) retryListAssignsIfErr(
-	sess *curator.Session, err error, counter *callbackCounter,
+	sess *curator.Session, op string, pathVal string,
+	err error, counter *callbackCounter,
 ) bool {
 	if err == nil {
 		return false
 	}
 
 	if errors.Is(err, zk.ErrConnectionClosed) {
+		// the request might be applied, currentAssignMap is outdated until listing again
+		s.state.assignsRelisting = true
 		counter.addRetry(sess, s.listAssignNodes)
 		return true
 	}
@@ -399,7 +914,11 @@ func (s *Sharding) retryListAssignsIfErr(
 		return true
 	}
 
-	panic(err)
+	// the assign znodes are listed again after the retry delay
+	s.state.assignsRelisting = true
+	counter.cancel()
+	s.handleError(sess, op, pathVal, err, s.listAssignNodes, false)
+	return true
 }
 
 func isOneOfErrors(err error, errorList ...error) bool {
@@ -413,48 +932,61 @@ func isOneOfErrors(err error, errorList ...error) bool {
 
 func (s *Sharding) updateAssignNode(
 	sess *curator.Session, nodeID string,
-	shards []ShardID, prev assignState,
+	assign assignData, prev assignState,
 	counter *callbackCounter,
 ) {
 	pathVal := s.getNodeAssignPath(nodeID)
-	data := marshalAssignNodeData(shards)
+	data := assign.marshalJSON()
 
-	finish := counter.begin()
+	finish := s.beginAssignWrite(counter)
 	sess.GetClient().Set(pathVal, data, prev.version, func(resp zk.SetResponse, err error) {
 		defer finish()
 
-		if s.retryListAssignsIfErr(sess, err, counter) {
+		if s.retryListAssignsIfErr(sess, OpSet, pathVal, err, counter) {
 			return
 		}
-		s.putNodeAssignState(nodeID, resp.Stat.Version, shards)
+		err = s.putNodeAssignState(nodeID, resp.Stat.Version, resp.Stat.Mzxid, assign)
+		s.retryListAssignsIfErr(sess, OpSet, pathVal, err, counter)
 	})
 }
 
 func (s *Sharding) createAssignNode(
 	sess *curator.Session, nodeID string,
-	shards []ShardID, counter *callbackCounter,
+	assign assignData, counter *callbackCounter,
 ) {
 	pathVal := s.getNodeAssignPath(nodeID)
-	data := marshalAssignNodeData(shards)
+	data := assign.marshalJSON()
 
-	finish := counter.begin()
+	finish := s.beginAssignWrite(counter)
 	sess.GetClient().Create(pathVal, data, 0, func(resp zk.CreateResponse, err error) {
 		defer finish()
-		if s.retryListAssignsIfErr(sess, err, counter) {
+		if s.retryListAssignsIfErr(sess, OpCreate, pathVal, err, counter) {
 			return
 		}
-		s.putNodeAssignState(nodeID, 0, shards)
+		err = s.putNodeAssignState(nodeID, 0, resp.Zxid, assign)
+		s.retryListAssignsIfErr(sess, OpCreate, pathVal, err, counter)
 	})
 }
 
+func (s *Sharding) beginAssignWrite(counter *callbackCounter) func() {
+	s.state.assignWritesInFlight++
+	finish := counter.begin()
+	return func() {
+		s.state.assignWritesInFlight--
+		finish()
+	}
+}
+
 func (s *Sharding) deleteAssignNode(sess *curator.Session, nodeID string, counter *callbackCounter) {
 	version := s.state.currentAssignMap[nodeID].version
 
-	finish := counter.begin()
-	sess.GetClient().Delete(s.getAssignsPath()+"/"+nodeID, version, func(resp zk.DeleteResponse, err error) {
+	pathVal := s.getNodeAssignPath(nodeID)
+
+	finish := s.beginAssignWrite(counter)
+	sess.GetClient().Delete(pathVal, version, func(resp zk.DeleteResponse, err error) {
 		defer finish()
 
-		if s.retryListAssignsIfErr(sess, err, counter) {
+		if s.retryListAssignsIfErr(sess, OpDelete, pathVal, err, counter) {
 			return
 		}
 		delete(s.state.currentAssignMap, nodeID)
@@ -462,7 +994,7 @@ func (s *Sharding) deleteAssignNode(sess *curator.Session, nodeID string, counte
 }
 
 // GetCurator is used for input of the curator.Client.Start() method
-func (s *Sharding) GetCurator() *curator.Curator {
+func (s *Sharding) GetCurator() curator.SessionRunner {
 	return s.cur
 }
 
@@ -474,68 +1006,244 @@ type containerNodeController struct {
 	parentPath string
 	next       func(sess *curator.Session)
 
-	nodeID   string
-	nodeAddr string
+	nodeID string
+	data   nodeData
+
+	// extraNames are names of optional container nodes, e.g. statusZNodeName
+	extraNames []string
+
+	errs *errorHandler
+
+	// config is the initial data of the config znode, it is only created if not empty
+	config []byte
+
+	// checkConfig validates the data of the config znode before joining, config is nil if the znode does not exist.
+	// The current node does NOT join and the chain is stopped if it returns an error.
+	checkConfig func(config *configData) error
+
+	// completeConfig returns the data of the config znode with its unknown fields filled in, written before joining.
+	// It returns nil if nothing is unknown.
+	completeConfig func(config configData) []byte
+
+	// duplicatePolicy is zero if an existing node znode is treated as created by the current session,
+	// onDuplicate is called with the error of DuplicateNodeFail, or nil after the node znode is created
+	duplicatePolicy DuplicateNodePolicy
+	onDuplicate     func(err error)
+
+	// isLeaving returns true if the node znode should be created with the leaving mark, can be nil
+	isLeaving func() bool
 }
 
 type nodeControllerState struct {
 	lockCreated    bool
 	nodesCreated   bool
 	assignsCreated bool
+
+	numExtraCreated int
+	configCreated   bool
+
+	nodesContainerCreated bool
+	configChecked         bool
+
+	// configReads is increased on every read of the config znode, only the watch of the latest read is used
+	configReads int
+
+	// sessionToken is written to the node znode, only with duplicatePolicy
+	sessionToken string
 }
 
 func newContainerNodeController(
 	parent string,
-	nodeID string, nodeAddr string,
+	nodeID string, data nodeData,
+	extraNames ...string,
 ) *containerNodeController {
 	return &containerNodeController{
 		parentPath: parent,
 		nodeID:     nodeID,
-		nodeAddr:   nodeAddr,
+		data:       data,
+		extraNames: extraNames,
+		errs:       newErrorHandler(parent, nil, 0),
 	}
 }
 
 func (c *containerNodeController) onStart(sess *curator.Session, next func(sess *curator.Session)) {
 	c.next = next
 	c.state = &nodeControllerState{}
+	if c.duplicatePolicy != 0 {
+		c.state.sessionToken = NewNodeID()
+	}
 	c.createInitNodes(sess)
 }
 
 func (c *containerNodeController) createInitNodes(sess *curator.Session) {
-	sessMustCreatePersistence(sess, c.getLockPath(), func(resp zk.CreateResponse) {
+	sessMustCreatePersistence(sess, c.errs, c.getLockPath(), func(resp zk.CreateResponse) {
 		c.state.lockCreated = true
 		c.createCompleted(sess)
 	})
 
-	sessMustCreatePersistence(sess, c.getNodesPath(), func(resp zk.CreateResponse) {
-		if len(c.nodeID) > 0 {
-			c.createEphemeralNode(sess)
-		} else {
-			c.state.nodesCreated = true
-			c.createCompleted(sess)
-		}
+	sessMustCreatePersistence(sess, c.errs, c.getNodesPath(), func(resp zk.CreateResponse) {
+		c.state.nodesContainerCreated = true
+		c.joinIfReady(sess)
 	})
 
-	sessMustCreatePersistence(sess, c.getAssignsPath(), func(resp zk.CreateResponse) {
+	sessMustCreatePersistence(sess, c.errs, c.getAssignsPath(), func(resp zk.CreateResponse) {
 		c.state.assignsCreated = true
 		c.createCompleted(sess)
 	})
+
+	if len(c.config) > 0 {
+		sessMustCreateWithData(sess, c.errs, c.parentPath+configZNodeName, 0, c.config, func(resp zk.CreateResponse) {
+			c.state.configCreated = true
+			if c.checkConfig != nil {
+				c.readConfig(sess)
+			}
+			c.createCompleted(sess)
+		})
+	} else if c.checkConfig != nil {
+		c.readConfig(sess)
+	}
+
+	for _, name := range c.extraNames {
+		sessMustCreatePersistence(sess, c.errs, c.parentPath+name, func(resp zk.CreateResponse) {
+			c.state.numExtraCreated++
+			c.createCompleted(sess)
+		})
+	}
+}
+
+// readConfig validates the config znode before joining, and watches it for validating again when it is changed
+func (c *containerNodeController) readConfig(sess *curator.Session) {
+	state := c.state
+	state.configReads++
+	reads := state.configReads
+	pathVal := c.parentPath + configZNodeName
+
+	sess.GetClient().GetW(pathVal, func(resp zk.GetResponse, err error) {
+		var config *configData
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(c.readConfig)
+				return
+			}
+			if !errors.Is(err, zk.ErrNoNode) {
+				c.errs.handle(sess, &UnexpectedError{Op: OpGet, Path: pathVal, Err: err}, c.readConfig, false)
+				return
+			}
+		} else {
+			data, err := unmarshalConfigData(resp.Data)
+			if err != nil {
+				c.errs.handle(sess, &UnexpectedError{Op: OpUnmarshal, Path: pathVal, Err: err}, c.readConfig, false)
+				return
+			}
+			config = &data
+		}
+		c.onConfigRead(sess, config, resp.Stat.Version)
+	}, func(ev zk.Event) {
+		if c.state != state || state.configReads != reads {
+			return
+		}
+		if ev.Type == zk.EventNodeDataChanged || ev.Type == zk.EventNodeDeleted {
+			c.readConfig(sess)
+		}
+	})
+}
+
+// onConfigRead joins the cluster if the config znode is valid. If it becomes invalid after joined,
+// the session is restarted, so the current node leaves the cluster until the config znode is changed back.
+func (c *containerNodeController) onConfigRead(sess *curator.Session, config *configData, version int32) {
+	if err := c.checkConfig(config); err != nil {
+		if c.state.configChecked {
+			c.errs.restartSession()
+		}
+		return
+	}
+	if c.state.configChecked {
+		return
+	}
+
+	if config != nil && c.completeConfig != nil {
+		if data := c.completeConfig(*config); data != nil {
+			c.writeConfig(sess, data, version)
+			return
+		}
+	}
+
+	c.state.configChecked = true
+	c.joinIfReady(sess)
+}
+
+// writeConfig fills the unknown fields of the config znode, e.g. created by an observer or an older version.
+// The config znode is read again by the watch after it is changed.
+func (c *containerNodeController) writeConfig(sess *curator.Session, data []byte, version int32) {
+	pathVal := c.parentPath + configZNodeName
+
+	sess.GetClient().Set(pathVal, data, version, func(_ zk.SetResponse, err error) {
+		if err == nil || errors.Is(err, zk.ErrBadVersion) || errors.Is(err, zk.ErrNoNode) {
+			return
+		}
+		if errors.Is(err, zk.ErrConnectionClosed) {
+			sess.AddRetry(c.readConfig)
+			return
+		}
+		c.errs.handle(sess, &UnexpectedError{Op: OpSet, Path: pathVal, Err: err}, c.readConfig, false)
+	})
+}
+
+// joinIfReady creates the ephemeral node after the config znode is validated
+func (c *containerNodeController) joinIfReady(sess *curator.Session) {
+	if !c.state.nodesContainerCreated {
+		return
+	}
+	if c.checkConfig != nil && !c.state.configChecked {
+		return
+	}
+
+	if len(c.nodeID) > 0 {
+		c.createEphemeralNode(sess)
+	} else {
+		c.state.nodesCreated = true
+		c.createCompleted(sess)
+	}
 }
 
 func (c *containerNodeController) createEphemeralNode(sess *curator.Session) {
+	if c.duplicatePolicy != 0 {
+		c.createOwnedEphemeralNode(sess)
+		return
+	}
+
 	pathVal := c.getNodesPath() + "/" + c.nodeID
-	data := nodeData{Address: c.nodeAddr}.marshalJSON()
+	data := c.getNodeData().marshalJSON()
 
-	sessMustCreateWithData(sess, pathVal, zk.FlagEphemeral, data, func(resp zk.CreateResponse) {
+	sessMustCreateWithData(sess, c.errs, pathVal, zk.FlagEphemeral, data, func(resp zk.CreateResponse) {
 		c.state.nodesCreated = true
 		c.createCompleted(sess)
 	})
 }
 
+// getNodeData returns the data of the node znode, with the leaving mark after Shutdown is called
+func (c *containerNodeController) getNodeData() nodeData {
+	data := c.data
+	if c.isLeaving != nil && c.isLeaving() {
+		data.Leaving = true
+	}
+	return data
+}
+
 func (c *containerNodeController) createCompleted(sess *curator.Session) {
-	if c.state.lockCreated && c.state.nodesCreated && c.state.assignsCreated {
-		c.next(sess)
+	if !c.state.lockCreated || !c.state.nodesCreated || !c.state.assignsCreated {
+		return
+	}
+	if c.state.numExtraCreated < len(c.extraNames) {
+		return
+	}
+	if len(c.config) > 0 && !c.state.configCreated {
+		return
+	}
+	if c.checkConfig != nil && !c.state.configChecked {
+		return
 	}
+	c.next(sess)
 }
 
 func (c *containerNodeController) getLockPath() string {
@@ -0,0 +1,255 @@
+package sharding
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func getStoreConfig(store *curator.FakeZookeeper) configData {
+	config, err := unmarshalConfigData(findStoreZNode(store, "sharding", "config").Data)
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+// setStoreConfig changes the config znode by another zookeeper client, so the watches of the nodes are notified
+func setStoreConfig(store *curator.FakeZookeeper, client curator.FakeClientID, config configData) {
+	version := findStoreZNode(store, "sharding", "config").Stat.Version
+	factory := curator.NewFakeClientFactory(store, client)
+	factory.Start(curator.New(func(sess *curator.Session) {
+		sess.GetClient().Set(parentPath+configZNodeName, config.marshalJSON(), version,
+			func(resp zk.SetResponse, err error) {
+				if err != nil {
+					panic(err)
+				}
+			},
+		)
+	}))
+	store.Begin(client)
+	store.SetApply(client)
+}
+
+func TestSharding_Config_Validation__Num_Shards_Mismatch(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	factory := curator.NewFakeClientFactory(store, client2)
+	s2 := New(parentPath, "node02", 16, "node02-addr:4001", WithLogger(&noopLogger{}))
+	factory.Start(s2.GetCurator())
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, nil, s1.ConfigError())
+	assert.Equal(t, true, errors.Is(s2.ConfigError(), ErrConfigMismatch))
+	assert.Equal(t,
+		"sharding: config mismatch: num_shards is 8 in zookeeper, but 16 in the current process",
+		s2.ConfigError().Error(),
+	)
+
+	assert.Equal(t, s1.newConfigData(), getStoreConfig(store))
+
+	// node02 does NOT join
+	nodes := store.Root.Children[0].Children[1]
+	assert.Equal(t, "nodes", nodes.Name)
+	assert.Equal(t, 1, len(nodes.Children))
+	assert.Equal(t, "node01", nodes.Children[0].Name)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Config_Validation__Replication_Factor_Mismatch(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	s2 := startSharding(store, client2, "node02",
+		WithLogger(&noopLogger{}), WithReplicationFactor(2),
+	)
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, nil, s1.ConfigError())
+	assert.Equal(t,
+		"sharding: config mismatch: replication_factor is 1 in zookeeper, but 2 in the current process",
+		s2.ConfigError().Error(),
+	)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Config_Validation__Settings_Mismatch(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	s2 := startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithShardPinning())
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t,
+		"sharding: config mismatch: shard_pinning is false in zookeeper, but true in the current process",
+		s2.ConfigError().Error(),
+	)
+	assert.Equal(t, []string{"node01"}, getStoreChildNames(store, "sharding", "nodes"))
+}
+
+func TestSharding_Config_Validation__Fill_Unknown_Settings(t *testing.T) {
+	store := initStore()
+
+	// the config znode is created by an observer, without the settings of nodes
+	factory := curator.NewFakeClientFactory(store, observer1)
+	observer := NewObserver(parentPath, numShards, func(event ChangeEvent) {}, WithObserverDynamicNumShards())
+	factory.Start(observer.GetCurator())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{observer1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+	assert.Equal(t, configData{NumShards: numShards}, getStoreConfig(store))
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithNodeDraining())
+	store.Begin(client1)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, observer1}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, nil, s1.ConfigError())
+	assert.Equal(t, s1.newConfigData(), getStoreConfig(store))
+
+	// the filled settings are compared with other nodes
+	s2 := startSharding(store, client2, "node02", WithLogger(&noopLogger{}))
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, observer1}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t,
+		"sharding: config mismatch: node_draining is true in zookeeper, but false in the current process",
+		s2.ConfigError().Error(),
+	)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Config_Validation__Config_Changed_After_Joined(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+	s2 := startSharding(store, client2, "node02", WithLogger(&noopLogger{}))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	// both nodes leave the cluster
+	config := s1.newConfigData()
+	config.ReplicationFactor = 2
+	setStoreConfig(store, client4, config)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, true, errors.Is(s1.ConfigError(), ErrConfigMismatch))
+	assert.Equal(t, true, errors.Is(s2.ConfigError(), ErrConfigMismatch))
+	assert.Equal(t, 0, len(getStoreChildNames(store, "sharding", "nodes")))
+	assert.Equal(t, 0, len(getStoreChildNames(store, "sharding", "locks")))
+
+	// changed back, both nodes join again
+	config.ReplicationFactor = 1
+	setStoreConfig(store, client4, config)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, nil, s1.ConfigError())
+	assert.Equal(t, nil, s2.ConfigError())
+	assert.Equal(t, []string{"node01", "node02"}, getStoreChildNames(store, "sharding", "nodes"))
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Config_Validation__Observer_Mismatch(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	var events []ChangeEvent
+	factory := curator.NewFakeClientFactory(store, observer1)
+	observer := NewObserver(parentPath, 4, func(event ChangeEvent) {
+		events = append(events, event)
+	}, WithObserverConfigValidation())
+	factory.Start(observer.GetCurator())
+	store.Begin(observer1)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, observer1}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t,
+		"sharding: config mismatch: num_shards is 8 in zookeeper, but 4 in the current process",
+		observer.ConfigError().Error(),
+	)
+	assert.Equal(t, 0, len(events))
+}
+
+func TestSharding_Config_Validation__Matched__Using_Tester__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		var events []ChangeEvent
+		factory := curator.NewFakeClientFactory(store, observer1)
+		observer := NewObserver(parentPath, numShards, func(event ChangeEvent) {
+			events = append(events, event)
+		}, WithObserverConfigValidation())
+		factory.Start(observer.GetCurator())
+
+		startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+		startSharding(store, client2, "node02", WithLogger(&noopLogger{}))
+		startSharding(store, client3, "node03", WithLogger(&noopLogger{}))
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3, observer1},
+			int64(k),
+		)
+		tester.Begin()
+		runTesterWithExactSteps(tester, 5, 2000)
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+		assert.Equal(t, nil, observer.ConfigError())
+		checkObserverShards(t, store, events[len(events)-1])
+	}
+}
@@ -0,0 +1,136 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func startCoordinator(
+	store *curator.FakeZookeeper,
+	client curator.FakeClientID,
+	options ...Option,
+) *Sharding {
+	factory := curator.NewFakeClientFactory(store, client)
+	s := NewCoordinator(parentPath, "coord", numShards, options...)
+	s.clientID = client
+	factory.Start(s.GetCurator())
+	return s
+}
+
+func TestSharding_Coordinator(t *testing.T) {
+	store := initStore()
+
+	var lastEvent ChangeEvent
+	startCoordinator(store, client1,
+		WithLogger(&noopLogger{}), WithShardStatus(),
+		WithShardingObserver(func(event ChangeEvent) {
+			lastEvent = event
+		}),
+	)
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	// no nodes, all shards are unassigned
+	assert.Equal(t, 0, len(findStoreZNode(store, "sharding", "nodes").Children))
+	assert.Equal(t, map[string][]ShardID{}, getStoreAssigns(store))
+
+	startSharding(store, client2, "node01", WithLogger(&noopLogger{}), WithShardStatus())
+	startSharding(store, client3, "node02", WithLogger(&noopLogger{}), WithShardStatus())
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, client3}, 123)
+	store.Begin(client2)
+	store.Begin(client3)
+	runTesterWithoutErrors(tester)
+
+	// the coordinator holds the leader lock without owning any shards
+	assert.Equal(t, 2, len(findStoreZNode(store, "sharding", "nodes").Children))
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {4, 5, 6, 7},
+		"node02": {0, 1, 2, 3},
+	}, getStoreAssigns(store))
+
+	assert.Equal(t, 2, len(lastEvent.New))
+	assert.Equal(t, "node01", lastEvent.New[0].ID)
+	assert.Equal(t, "node02", lastEvent.New[1].ID)
+}
+
+func TestSharding_Coordinator__All_Nodes_Expired(t *testing.T) {
+	store := initStore()
+
+	var events []ChangeEvent
+	startCoordinator(store, client1, WithLogger(&noopLogger{}))
+	startSharding(store, client2, "node01", WithLogger(&noopLogger{}))
+
+	obs := NewObserver(parentPath, numShards, func(event ChangeEvent) {
+		events = append(events, event)
+	})
+	curator.NewFakeClientFactory(store, observer1).Start(obs.GetCurator())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, observer1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	last := events[len(events)-1]
+	assert.Equal(t, 1, len(last.New))
+
+	// the only node expired
+	store.SessionExpired(client2)
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, observer1}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{}, getStoreAssigns(store))
+
+	last = events[len(events)-1]
+	assert.Equal(t, 1, len(last.Old))
+	assert.Equal(t, 0, len(last.New))
+
+	// a new node joined
+	startSharding(store, client3, "node02", WithLogger(&noopLogger{}))
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client3, observer1}, 123)
+	store.Begin(client3)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node02": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	last = events[len(events)-1]
+	assert.Equal(t, 0, len(last.Old))
+	assert.Equal(t, 1, len(last.New))
+	assert.Equal(t, "node02", last.New[0].ID)
+}
+
+func TestSharding_Coordinator__With_Errors(t *testing.T) {
+	for k := 0; k < 100; k++ {
+		store := initStore()
+
+		startCoordinator(store, client1, WithLogger(&noopLogger{}))
+		clients := []curator.FakeClientID{client2, client3}
+		for i, client := range clients {
+			nodeID := fmt.Sprintf("node%02d", i+1)
+			startSharding(store, client, nodeID, WithLogger(&noopLogger{}))
+		}
+
+		tester := curator.NewFakeZookeeperTester(store, append(clients, client1), int64(5000+k))
+		tester.Begin()
+		runTesterWithExactSteps(tester, 0.2, 500)
+		runTesterWithoutErrors(tester)
+
+		nodes := findStoreZNode(store, "sharding", "nodes")
+		assert.Equal(t, 2, len(nodes.Children))
+		checkFinalShards(t, store)
+	}
+}
+
+func TestSharding_Coordinator__Invalid(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid coordinator id", func() {
+		NewCoordinator(parentPath, "", numShards)
+	})
+}
@@ -0,0 +1,124 @@
+package sharding
+
+import (
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharding_Drain_Node(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithNodeDraining())
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithNodeDraining())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	var errors []error
+	s1.DrainNode("node02", func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{nil}, errors)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+		"node02": nil,
+	}, getStoreAssigns(store))
+
+	// drain again
+	s1.DrainNode("node02", func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+	assert.Equal(t, []error{nil, nil}, errors)
+
+	s1.UndrainNode("node02", func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{nil, nil, nil}, errors)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Drain_Node__All_Nodes_Draining(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithNodeDraining())
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithNodeDraining())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	s1.DrainNode("node01", func(err error) {})
+	s1.DrainNode("node02", func(err error) {})
+	runTesterWithoutErrors(tester)
+
+	// drains are ignored when both nodes are draining
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Drain_Node__Without_Session(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithNodeDraining())
+
+	// called on a new goroutine
+	errCh := make(chan error, 2)
+	s1.DrainNode("node01", func(err error) {
+		errCh <- err
+	})
+	s1.UndrainNode("node01", func(err error) {
+		errCh <- err
+	})
+	assert.Equal(t, ErrNoSession, <-errCh)
+	assert.Equal(t, ErrNoSession, <-errCh)
+}
+
+func TestSharding_Drain_Node__Using_Tester__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithNodeDraining())
+		startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithNodeDraining())
+		startSharding(store, client3, "node03", WithLogger(&noopLogger{}), WithNodeDraining())
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3},
+			int64(k),
+		)
+		tester.Begin()
+		runTesterWithoutErrors(tester)
+
+		var drainErr error
+		s1.DrainNode("node03", func(err error) {
+			drainErr = err
+		})
+
+		runTesterWithExactSteps(tester, 5, 2000)
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+
+		// drain can fail because of connection errors
+		if drainErr == nil {
+			assert.Equal(t, 0, len(getStoreAssigns(store)["node03"]))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+type duplicateNodeTest struct {
+	store  *curator.FakeZookeeper
+	tester *curator.FakeZookeeperTester
+
+	second *Sharding
+}
+
+// newDuplicateNodeTest starts node01 on client1, then starts another process with the same node id on client2
+func newDuplicateNodeTest(policy DuplicateNodePolicy) *duplicateNodeTest {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	second := startSharding(store, client2, "node01", WithLogger(&noopLogger{}), WithDuplicateNodePolicy(policy))
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	return &duplicateNodeTest{
+		store:  store,
+		tester: tester,
+		second: second,
+	}
+}
+
+func (d *duplicateNodeTest) nodeOwner() curator.FakeClientID {
+	node := findStoreZNode(d.store, "sharding", "nodes", "node01")
+	if node == nil {
+		return ""
+	}
+	for client, state := range d.store.States {
+		if state.SessionID == node.SessionID {
+			return client
+		}
+	}
+	return ""
+}
+
+func TestSharding_Duplicate_Node__Wait(t *testing.T) {
+	d := newDuplicateNodeTest(DuplicateNodeWait)
+
+	assert.Equal(t, client1, d.nodeOwner())
+	assert.Equal(t, nil, d.second.DuplicateNodeError())
+
+	// the previous session expired
+	d.store.SessionExpired(client1)
+	d.tester = curator.NewFakeZookeeperTester(d.store, []curator.FakeClientID{client2}, 123)
+	runTesterWithoutErrors(d.tester)
+
+	assert.Equal(t, client2, d.nodeOwner())
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(d.store))
+}
+
+func TestSharding_Duplicate_Node__Fail(t *testing.T) {
+	d := newDuplicateNodeTest(DuplicateNodeFail)
+
+	assert.Equal(t, client1, d.nodeOwner())
+	assert.True(t, errors.Is(d.second.DuplicateNodeError(), ErrDuplicateNodeID))
+	assert.Equal(t, "sharding: duplicate node id: node01", d.second.DuplicateNodeError().Error())
+
+	// does NOT join in the same session
+	d.store.SessionExpired(client1)
+	d.tester = curator.NewFakeZookeeperTester(d.store, []curator.FakeClientID{client2}, 123)
+	runTesterWithoutErrors(d.tester)
+	assert.Equal(t, curator.FakeClientID(""), d.nodeOwner())
+
+	// joins in a new session
+	d.store.SessionExpired(client2)
+	d.store.Begin(client2)
+	runTesterWithoutErrors(d.tester)
+
+	assert.Equal(t, client2, d.nodeOwner())
+	assert.Equal(t, nil, d.second.DuplicateNodeError())
+}
+
+func TestSharding_Duplicate_Node__Take_Over(t *testing.T) {
+	d := newDuplicateNodeTest(DuplicateNodeTakeOver)
+
+	assert.Equal(t, client2, d.nodeOwner())
+	assert.Equal(t, nil, d.second.DuplicateNodeError())
+
+	d.store.SessionExpired(client1)
+	runTesterWithoutErrors(d.tester)
+
+	assert.Equal(t, client2, d.nodeOwner())
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(d.store))
+}
+
+func TestSharding_Duplicate_Node__With_Errors(t *testing.T) {
+	for k := 0; k < 100; k++ {
+		store := initStore()
+
+		clients := []curator.FakeClientID{client1, client2, client3}
+		for i, client := range clients {
+			nodeID := fmt.Sprintf("node%02d", i+1)
+			startSharding(store, client, nodeID, WithLogger(&noopLogger{}), WithDuplicateNodePolicy(DuplicateNodeFail))
+		}
+
+		tester := curator.NewFakeZookeeperTester(store, clients, int64(4000+k))
+		tester.Begin()
+		runTesterWithExactSteps(tester, 0.2, 500)
+		runTesterWithoutErrors(tester)
+
+		// the create requests of the current sessions with lost responses are NOT duplicates
+		nodes := findStoreZNode(store, "sharding", "nodes")
+		assert.Equal(t, 3, len(nodes.Children))
+		checkFinalShards(t, store)
+	}
+}
+
+func TestSharding_Duplicate_Node__Invalid(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid duplicate node policy", func() {
+		New(parentPath, "node01", numShards, "addr", WithDuplicateNodePolicy(0))
+	})
+}
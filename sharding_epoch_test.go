@@ -0,0 +1,185 @@
+package sharding
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+type shardEpoch struct {
+	nodeID string
+	epoch  int64
+}
+
+func getStoreEpochs(store *curator.FakeZookeeper) map[ShardID]shardEpoch {
+	result := map[ShardID]shardEpoch{}
+	for _, node := range findStoreZNode(store, "sharding", "assigns").Children {
+		var d assignData
+		if err := json.Unmarshal(node.Data, &d); err != nil {
+			panic(err)
+		}
+		for id, epoch := range resolveEpochs(d, node.Stat.Mzxid) {
+			result[id] = shardEpoch{nodeID: node.Name, epoch: epoch}
+		}
+	}
+	return result
+}
+
+func TestSharding_Fencing_Epochs(t *testing.T) {
+	store := initStore()
+
+	var lastEvent ChangeEvent
+	var observerEvent ChangeEvent
+
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}), WithFencingEpochs(), WithShardStatus(),
+		WithShardingObserver(func(event ChangeEvent) {
+			lastEvent = event
+		}),
+	)
+
+	obs := NewObserver(parentPath, numShards, func(event ChangeEvent) {
+		observerEvent = event
+	}, WithObserverFencingEpochs())
+	curator.NewFakeClientFactory(store, observer1).Start(obs.GetCurator())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, observer1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	node01 := findStoreZNode(store, "sharding", "assigns", "node01")
+	first := node01.Stat.Mzxid
+	assert.Equal(t, `{"shards":[0,1,2,3,4,5,6,7]}`, string(node01.Data))
+
+	epoch, ok := s1.ShardEpoch(5)
+	assert.True(t, ok)
+	assert.Equal(t, first, epoch)
+
+	// node02 joined
+	s2 := startSharding(store, client2, "node02",
+		WithLogger(&noopLogger{}), WithFencingEpochs(), WithShardStatus(),
+	)
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, observer1}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	node02 := findStoreZNode(store, "sharding", "assigns", "node02")
+	second := node02.Stat.Mzxid
+	assert.Greater(t, second, first)
+	assert.Equal(t,
+		fmt.Sprintf(`{"shards":[0,1,2,3],"epochs":{"0":%d,"1":%d,"2":%d,"3":%d}}`, first, first, first, first),
+		string(node01.Data),
+	)
+
+	// kept shards have the same epochs
+	epoch, ok = s1.ShardEpoch(0)
+	assert.True(t, ok)
+	assert.Equal(t, first, epoch)
+
+	_, ok = s1.ShardEpoch(5)
+	assert.False(t, ok)
+
+	epoch, ok = s2.ShardEpoch(5)
+	assert.True(t, ok)
+	assert.Equal(t, second, epoch)
+
+	expected := map[ShardID]int64{0: first, 1: first, 2: first, 3: first}
+	assert.Equal(t, expected, lastEvent.New[0].Epochs)
+	assert.Equal(t, expected, observerEvent.New[0].Epochs)
+
+	expected = map[ShardID]int64{4: second, 5: second, 6: second, 7: second}
+	assert.Equal(t, expected, lastEvent.New[1].Epochs)
+	assert.Equal(t, expected, observerEvent.New[1].Epochs)
+
+	// node02 expired, its shards move back to node01 with higher epochs
+	store.SessionExpired(client2)
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, observer1}, 123)
+	runTesterWithoutErrors(tester)
+
+	third := node01.Stat.Mzxid
+	assert.Greater(t, third, second)
+
+	assert.Equal(t, map[ShardID]int64{
+		0: first, 1: first, 2: first, 3: first,
+		4: third, 5: third, 6: third, 7: third,
+	}, lastEvent.New[0].Epochs)
+	assert.Equal(t, lastEvent.New[0].Epochs, observerEvent.New[0].Epochs)
+
+	epoch, ok = s1.ShardEpoch(5)
+	assert.True(t, ok)
+	assert.Equal(t, third, epoch)
+}
+
+func TestSharding_Fencing_Epochs__Without_Other_Options(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithFencingEpochs())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	node01 := findStoreZNode(store, "sharding", "assigns", "node01")
+
+	epoch, ok := s1.ShardEpoch(5)
+	assert.True(t, ok)
+	assert.Equal(t, node01.Stat.Mzxid, epoch)
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, s1.MyShards())
+}
+
+func TestSharding_Fencing_Epochs__Not_Configured(t *testing.T) {
+	store := initStore()
+
+	var lastEvent ChangeEvent
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}), WithShardStatus(),
+		WithShardingObserver(func(event ChangeEvent) {
+			lastEvent = event
+		}),
+	)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	_, ok := s1.ShardEpoch(0)
+	assert.False(t, ok)
+	assert.Nil(t, lastEvent.New[0].Epochs)
+}
+
+func TestSharding_Fencing_Epochs__With_Errors(t *testing.T) {
+	for k := 0; k < 100; k++ {
+		store := initStore()
+
+		clients := []curator.FakeClientID{client1, client2, client3}
+		for i, client := range clients {
+			nodeID := fmt.Sprintf("node%02d", i+1)
+			startSharding(store, client, nodeID, WithLogger(&noopLogger{}), WithFencingEpochs())
+		}
+
+		tester := curator.NewFakeZookeeperTester(store, clients, int64(3000+k))
+		tester.Begin()
+
+		// the epoch of a shard increases every time it is assigned to another node
+		last := map[ShardID]shardEpoch{}
+		for i := 0; i < 20; i++ {
+			runTesterWithExactSteps(tester, 0.2, 30)
+
+			for id, current := range getStoreEpochs(store) {
+				prev, ok := last[id]
+				if ok && prev.nodeID != current.nodeID {
+					assert.Greater(t, current.epoch, prev.epoch)
+				}
+				if ok && prev.nodeID == current.nodeID {
+					assert.GreaterOrEqual(t, current.epoch, prev.epoch)
+				}
+				last[id] = current
+			}
+		}
+		runTesterWithoutErrors(tester)
+		checkFinalShards(t, store)
+	}
+}
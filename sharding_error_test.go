@@ -0,0 +1,285 @@
+package sharding
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func findStoreZNode(store *curator.FakeZookeeper, names ...string) *curator.ZNode {
+	node := store.Root
+	for _, name := range names {
+		var next *curator.ZNode
+		for _, child := range node.Children {
+			if child.Name == name {
+				next = child
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+type errorHandlerTest struct {
+	store *curator.FakeZookeeper
+	timer *fakeTimer
+
+	tester *curator.FakeZookeeperTester
+	errs   []*UnexpectedError
+}
+
+// newErrorHandlerTest makes node01 owning all shards, then replaces its assign znode with an invalid data,
+// and expires its session, so that node02 reads the invalid znode when it becomes the leader
+func newErrorHandlerTest() *errorHandlerTest {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	findStoreZNode(store, "sharding", "assigns", "node01").Data = []byte("invalid")
+	store.SessionExpired(client1)
+
+	return &errorHandlerTest{
+		store: store,
+		timer: newFakeTimer(),
+	}
+}
+
+// startNode02 starts node02 with the error handler returning the actions in order, the last one is repeated
+func (e *errorHandlerTest) startNode02(actions ...ErrorAction) {
+	s := startSharding(e.store, client2, "node02",
+		WithLogger(&noopLogger{}),
+		WithErrorHandler(func(err *UnexpectedError) ErrorAction {
+			e.errs = append(e.errs, err)
+			return actions[min(len(e.errs), len(actions))-1]
+		}, 5*time.Second),
+	)
+	e.timer.install(s)
+
+	e.tester = curator.NewFakeZookeeperTester(e.store, []curator.FakeClientID{client2}, 123)
+	e.store.Begin(client2)
+	runTesterWithoutErrors(e.tester)
+}
+
+func TestSharding_Error_Handler__Default_Panic(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	findStoreZNode(store, "sharding", "assigns", "node01").Data = []byte("invalid")
+	store.SessionExpired(client1)
+
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}))
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client2}, 123)
+	store.Begin(client2)
+
+	assert.Panics(t, func() {
+		runTesterWithoutErrors(tester)
+	})
+}
+
+func TestSharding_Error_Handler__Skip(t *testing.T) {
+	e := newErrorHandlerTest()
+	e.startNode02(ErrorActionSkip)
+
+	assert.Equal(t, 1, len(e.errs))
+	assert.Equal(t, OpUnmarshal, e.errs[0].Op)
+	assert.Equal(t, "/sharding/assigns/node01", e.errs[0].Path)
+
+	var syntaxErr *json.SyntaxError
+	assert.True(t, errors.As(e.errs[0], &syntaxErr))
+
+	// the invalid assign znode is treated as empty and deleted
+	assert.Equal(t, map[string][]ShardID{
+		"node02": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(e.store))
+	assert.Equal(t, 0, len(e.timer.pending))
+}
+
+func TestSharding_Error_Handler__Retry(t *testing.T) {
+	e := newErrorHandlerTest()
+	e.startNode02(ErrorActionRetry)
+
+	assert.Equal(t, 1, len(e.errs))
+	assert.Equal(t, []time.Duration{5 * time.Second}, e.timer.durations)
+
+	// no assignment until the assign znode is read successfully
+	assert.Equal(t, 1, len(findStoreZNode(e.store, "sharding", "assigns").Children))
+
+	// still invalid
+	e.timer.fireAll()
+	runTesterWithoutErrors(e.tester)
+	assert.Equal(t, 2, len(e.errs))
+	assert.Equal(t, 1, len(e.timer.pending))
+	assert.Equal(t, []time.Duration{5 * time.Second, 10 * time.Second}, e.timer.durations)
+
+	// fixed
+	findStoreZNode(e.store, "sharding", "assigns", "node01").Data = assignData{}.marshalJSON()
+	e.timer.fireAll()
+	runTesterWithoutErrors(e.tester)
+
+	assert.Equal(t, 2, len(e.errs))
+	assert.Equal(t, map[string][]ShardID{
+		"node02": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(e.store))
+	assert.Equal(t, 0, len(e.timer.pending))
+}
+
+func TestSharding_Error_Handler__Retry_Backoff(t *testing.T) {
+	e := newErrorHandlerTest()
+	e.startNode02(ErrorActionRetry)
+
+	for i := 0; i < 7; i++ {
+		e.timer.fireAll()
+		runTesterWithoutErrors(e.tester)
+	}
+	assert.Equal(t, 8, len(e.errs))
+	assert.Equal(t, []time.Duration{
+		5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second,
+		80 * time.Second, 160 * time.Second, 160 * time.Second, 160 * time.Second,
+	}, e.timer.durations)
+
+	// a failure long after the previous one starts from the retry delay again
+	e.timer.now = e.timer.now.Add(10 * time.Minute)
+	e.timer.fireAll()
+	runTesterWithoutErrors(e.tester)
+	assert.Equal(t, 9, len(e.errs))
+	assert.Equal(t, 5*time.Second, e.timer.durations[8])
+}
+
+func TestSharding_Error_Handler__Invalid_Action(t *testing.T) {
+	e := newErrorHandlerTest()
+	assert.PanicsWithValue(t, "Invalid error action 10", func() {
+		e.startNode02(ErrorAction(10))
+	})
+}
+
+func TestSharding_Put_Node_Assign_State__Out_Of_Order(t *testing.T) {
+	s := New(parentPath, "node01", numShards, "addr")
+	s.state = &sessionState{
+		currentAssignMap: map[string]assignState{
+			"node01": {version: 3, shards: []ShardID{1, 2}},
+		},
+	}
+
+	err := s.putNodeAssignState("node01", 2, 100, assignData{Shards: []ShardID{3}})
+	assert.Equal(t, ErrOutOfOrderResponse, err)
+	assert.Equal(t, []ShardID{1, 2}, s.state.currentAssignMap["node01"].shards)
+
+	err = s.putNodeAssignState("node01", 4, 101, assignData{Shards: []ShardID{3}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []ShardID{3}, s.state.currentAssignMap["node01"].shards)
+}
+
+func TestSharding_Error_Handler__Retry_Session_Expired(t *testing.T) {
+	e := newErrorHandlerTest()
+	e.startNode02(ErrorActionRetry)
+	assert.Equal(t, 1, len(e.errs))
+
+	// the retry of the previous session is ignored
+	findStoreZNode(e.store, "sharding", "assigns", "node01").Data = assignData{}.marshalJSON()
+	e.store.SessionExpired(client2)
+	e.store.Begin(client2)
+	runTesterWithoutErrors(e.tester)
+
+	e.timer.fireAll()
+	runTesterWithoutErrors(e.tester)
+
+	assert.Equal(t, 1, len(e.errs))
+	assert.Equal(t, map[string][]ShardID{
+		"node02": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(e.store))
+}
+
+func TestSharding_Error_Handler__Restart_Session(t *testing.T) {
+	e := newErrorHandlerTest()
+	e.startNode02(ErrorActionRestartSession, ErrorActionSkip)
+
+	assert.Equal(t, 2, len(e.errs))
+	assert.Equal(t, OpUnmarshal, e.errs[1].Op)
+	assert.Equal(t, "/sharding/assigns/node01", e.errs[1].Path)
+
+	// the lock znode of the dropped session is deleted
+	var lockNodes []string
+	for _, child := range findStoreZNode(e.store, "sharding", "locks").Children {
+		lockNodes = append(lockNodes, child.Name)
+	}
+	assert.Equal(t, []string{"node:node02-0000000002"}, lockNodes)
+	assert.NotNil(t, findStoreZNode(e.store, "sharding", "nodes", "node02"))
+
+	assert.Equal(t, map[string][]ShardID{
+		"node02": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(e.store))
+	assert.Equal(t, 0, len(e.timer.pending))
+}
+
+func TestObserver_Error_Handler__Skip(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	findStoreZNode(store, "sharding", "assigns", "node01").Data = []byte("invalid")
+
+	var errs []*UnexpectedError
+	var events []ChangeEvent
+	obs := NewObserver(parentPath, numShards, func(event ChangeEvent) {
+		events = append(events, event)
+	}, WithObserverErrorHandler(func(err *UnexpectedError) ErrorAction {
+		errs = append(errs, err)
+		return ErrorActionSkip
+	}, time.Second))
+	curator.NewFakeClientFactory(store, observer1).Start(obs.GetCurator())
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, observer1}, 123)
+	store.Begin(observer1)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, OpUnmarshal, errs[0].Op)
+	assert.Equal(t, "/sharding/assigns/node01", errs[0].Path)
+
+	// the shards of node01 are unknown
+	assert.Equal(t, 0, len(events))
+
+	// the assign znode of node01 is rewritten by the leader
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}))
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, observer1}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, 1, len(errs))
+	last := events[len(events)-1]
+	assert.Equal(t, 2, len(last.New))
+	assert.Equal(t, []ShardID{0, 1, 2, 3}, last.New[0].Shards)
+	assert.Equal(t, []ShardID{4, 5, 6, 7}, last.New[1].Shards)
+}
+
+func TestSharding_Error_Handler__Invalid(t *testing.T) {
+	handler := func(err *UnexpectedError) ErrorAction {
+		return ErrorActionRetry
+	}
+	assert.PanicsWithValue(t, "Invalid error handler", func() {
+		New(parentPath, "node01", numShards, "addr", WithErrorHandler(nil, time.Second))
+	})
+	assert.PanicsWithValue(t, "Invalid error retry delay", func() {
+		New(parentPath, "node01", numShards, "addr", WithErrorHandler(handler, 0))
+	})
+	assert.PanicsWithValue(t, "Invalid error handler", func() {
+		NewObserver(parentPath, numShards, func(event ChangeEvent) {}, WithObserverErrorHandler(nil, time.Second))
+	})
+}
@@ -0,0 +1,136 @@
+package sharding
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func startTwoNodesWithGracePeriod(store *curator.FakeZookeeper, timer *fakeTimer) {
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		WithNodeGracePeriod(10*time.Second),
+	)
+	timer.install(s1)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	startSharding(store, client2, "node02",
+		WithLogger(&noopLogger{}),
+		WithNodeGracePeriod(10*time.Second),
+	)
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+}
+
+func TestSharding_Node_Grace_Period__Restarted_Within_Grace_Period(t *testing.T) {
+	store := initStore()
+	timer := newFakeTimer()
+
+	startTwoNodesWithGracePeriod(store, timer)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	store.SessionExpired(client2)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	runTesterWithoutErrors(tester)
+
+	// shards of node02 are reserved
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, []time.Duration{10 * time.Second}, timer.durations)
+
+	// node02 restarted after 5 seconds
+	timer.now = timer.now.Add(5 * time.Second)
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	timer.now = timer.now.Add(5 * time.Second)
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, 0, len(timer.pending))
+}
+
+func TestSharding_Node_Grace_Period__Expired(t *testing.T) {
+	store := initStore()
+	timer := newFakeTimer()
+
+	startTwoNodesWithGracePeriod(store, timer)
+
+	store.SessionExpired(client2)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	timer.now = timer.now.Add(10 * time.Second)
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, 0, len(timer.pending))
+	assert.Equal(t, []time.Duration{10 * time.Second}, timer.durations)
+}
+
+func TestSharding_Node_Grace_Period__Using_Tester__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		timer := newFakeTimer()
+		options := []Option{
+			WithLogger(&noopLogger{}),
+			WithNodeGracePeriod(10 * time.Second),
+		}
+
+		timer.install(startSharding(store, client1, "node01", options...))
+		timer.install(startSharding(store, client2, "node02", options...))
+		timer.install(startSharding(store, client3, "node03", options...))
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3},
+			int64(k),
+		)
+
+		tester.Begin()
+		runTesterWithExactSteps(tester, 5, 2000)
+		runTesterWithoutErrors(tester)
+
+		for len(timer.pending) > 0 {
+			timer.now = timer.now.Add(10 * time.Second)
+			timer.fireAll()
+			runTesterWithoutErrors(tester)
+		}
+
+		checkFinalShards(t, store)
+	}
+}
@@ -0,0 +1,221 @@
+package sharding
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func getStoreGroupAssigns(store *curator.FakeZookeeper, group string) map[string][]ShardID {
+	result := map[string][]ShardID{}
+	for _, node := range findStoreZNode(store, "sharding", "groups", group, "assigns").Children {
+		var d assignData
+		if err := json.Unmarshal(node.Data, &d); err != nil {
+			panic(err)
+		}
+		result[node.Name] = d.Shards
+	}
+	return result
+}
+
+func TestSharding_Shard_Groups(t *testing.T) {
+	store := initStore()
+
+	events := map[string]ChangeEvent{}
+	observerEvents := map[string]ChangeEvent{}
+
+	groupOptions := []Option{
+		WithLogger(&noopLogger{}),
+		WithShardGroup("ingest", 4),
+		WithShardGroup("compaction", 6),
+	}
+
+	startSharding(store, client1, "node01", groupOptions...)
+	startSharding(store, client2, "node02", append(groupOptions,
+		WithShardingObserver(func(event ChangeEvent) {
+			events[event.Group] = event
+		}),
+	)...)
+
+	obs := NewObserver(parentPath, numShards, func(event ChangeEvent) {
+		observerEvents[event.Group] = event
+	}, WithObserverShardGroup("ingest", 4))
+	curator.NewFakeClientFactory(store, client3).Start(obs.GetCurator())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, client3}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []string{"node01", "node02"}, getStoreChildNames(store, "sharding", "assigns"))
+	assert.Equal(t, []string{"ingest", "compaction"}, getStoreChildNames(store, "sharding", "groups"))
+
+	// default group
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {2, 3},
+	}, getStoreGroupAssigns(store, "ingest"))
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2},
+		"node02": {3, 4, 5},
+	}, getStoreGroupAssigns(store, "compaction"))
+
+	// events
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, []ShardID{0, 1, 2, 3}, events[""].New[0].Shards)
+	assert.Equal(t, []ShardID{4, 5, 6, 7}, events[""].New[1].Shards)
+
+	assert.Equal(t, "ingest", events["ingest"].Group)
+	assert.Equal(t, []ShardID{0, 1}, events["ingest"].New[0].Shards)
+	assert.Equal(t, []ShardID{2, 3}, events["ingest"].New[1].Shards)
+
+	assert.Equal(t, []ShardID{0, 1, 2}, events["compaction"].New[0].Shards)
+	assert.Equal(t, []ShardID{3, 4, 5}, events["compaction"].New[1].Shards)
+
+	// standalone observer
+	assert.Equal(t, 2, len(observerEvents))
+	assert.Equal(t, events[""].New, observerEvents[""].New)
+	assert.Equal(t, events["ingest"].New, observerEvents["ingest"].New)
+}
+
+func TestSharding_Shard_Groups__Node_Deleted(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithShardGroup("ingest", 4))
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithShardGroup("ingest", 4))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {2, 3},
+	}, getStoreGroupAssigns(store, "ingest"))
+
+	store.SessionExpired(client2)
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+	}, getStoreGroupAssigns(store, "ingest"))
+}
+
+func TestSharding_Shard_Groups__Owned_Shards(t *testing.T) {
+	store := initStore()
+
+	var acquired, released []ShardID
+	h := newTestShardHandler()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}),
+		WithShardGroup("ingest", 4,
+			WithGroupShardListener(func(a []ShardID, r []ShardID) {
+				acquired = append(acquired, a...)
+				released = append(released, r...)
+			}),
+			WithGroupShardHandler(h),
+		),
+	)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, s1.MyShards())
+	assert.Equal(t, []ShardID{0, 1, 2, 3}, s1.GroupShards("ingest"))
+	assert.Equal(t, []ShardID(nil), s1.GroupShards("compaction"))
+	assert.Equal(t, []ShardID{0, 1, 2, 3}, acquired)
+	h.waitRunning(t, []ShardID{0, 1, 2, 3})
+
+	// node02 joined
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithShardGroup("ingest", 4))
+	store.Begin(client2)
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []ShardID{0, 1}, s1.GroupShards("ingest"))
+	assert.Equal(t, []ShardID{2, 3}, released)
+	h.waitRunning(t, []ShardID{0, 1})
+
+	// the shards of the group are released and acquired again in the new session
+	store.SessionExpired(client1)
+	store.Begin(client1)
+	runTesterWithoutErrors(tester)
+
+	shards := getStoreGroupAssigns(store, "ingest")["node01"]
+	assert.Equal(t, 2, len(shards))
+	assert.Equal(t, shards, s1.GroupShards("ingest"))
+	assert.Equal(t, []ShardID{2, 3, 0, 1}, released[:4])
+	h.waitRunning(t, shards)
+
+	h.mut.Lock()
+	assert.Equal(t, false, h.overlapped)
+	h.mut.Unlock()
+}
+
+func TestSharding_Shard_Groups__Same_Name_As_Node(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithShardGroup("node02", 4))
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithShardGroup("node02", 4))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {2, 3},
+	}, getStoreGroupAssigns(store, "node02"))
+}
+
+func TestSharding_Shard_Groups__With_Errors(t *testing.T) {
+	for k := 0; k < 100; k++ {
+		store := initStore()
+
+		clients := []curator.FakeClientID{client1, client2, client3}
+		for i, client := range clients {
+			nodeID := fmt.Sprintf("node%02d", i+1)
+			startSharding(store, client, nodeID, WithLogger(&noopLogger{}), WithShardGroup("ingest", 5))
+		}
+
+		tester := curator.NewFakeZookeeperTester(store, clients, int64(2000+k))
+		tester.Begin()
+		runTesterWithExactSteps(tester, 0.2, 500)
+		runTesterWithoutErrors(tester)
+
+		var shards []ShardID
+		for _, nodeShards := range getStoreGroupAssigns(store, "ingest") {
+			shards = append(shards, nodeShards...)
+		}
+		assert.ElementsMatch(t, []ShardID{0, 1, 2, 3, 4}, shards)
+	}
+}
+
+func TestSharding_Shard_Groups__Invalid(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid shard group", func() {
+		New(parentPath, "node01", numShards, "addr", WithShardGroup("", 4))
+	})
+	assert.PanicsWithValue(t, "Invalid shard group", func() {
+		New(parentPath, "node01", numShards, "addr", WithShardGroup("a/b", 4))
+	})
+	assert.PanicsWithValue(t, "Invalid shard group", func() {
+		New(parentPath, "node01", numShards, "addr", WithShardGroup("ingest", 0))
+	})
+	assert.PanicsWithValue(t, "Duplicated shard group", func() {
+		New(parentPath, "node01", numShards, "addr", WithShardGroup("ingest", 4), WithShardGroup("ingest", 8))
+	})
+}
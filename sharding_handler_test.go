@@ -0,0 +1,243 @@
+package sharding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func getStoreStatus(store *curator.FakeZookeeper) map[string][]ShardID {
+	result := map[string][]ShardID{}
+	for _, node := range findStoreZNode(store, "sharding", "status").Children {
+		var d statusData
+		if err := json.Unmarshal(node.Data, &d); err != nil {
+			panic(err)
+		}
+		result[node.Name] = d.Shards
+	}
+	return result
+}
+
+type testShardHandler struct {
+	// gate blocks OnRelease until it is closed, if not nil
+	gate chan struct{}
+
+	mut      sync.Mutex
+	running  map[ShardID]bool
+	acquires map[ShardID]int
+	releases map[ShardID]int
+
+	// blocked are the shards whose OnRelease is waiting for the gate,
+	// acquiredWhileBlocked are the shards acquired again while their OnRelease is waiting for the gate
+	blocked              map[ShardID]bool
+	acquiredWhileBlocked []ShardID
+
+	// overlapped is true if two workers of the same shard have run at the same time
+	overlapped bool
+}
+
+func newTestShardHandler() *testShardHandler {
+	return &testShardHandler{
+		running:  map[ShardID]bool{},
+		acquires: map[ShardID]int{},
+		releases: map[ShardID]int{},
+		blocked:  map[ShardID]bool{},
+	}
+}
+
+func (h *testShardHandler) OnAcquire(ctx context.Context, id ShardID) {
+	h.mut.Lock()
+	if h.running[id] {
+		h.overlapped = true
+	}
+	h.running[id] = true
+	h.acquires[id]++
+	if h.blocked[id] {
+		h.acquiredWhileBlocked = append(h.acquiredWhileBlocked, id)
+	}
+	h.mut.Unlock()
+
+	<-ctx.Done()
+}
+
+func (h *testShardHandler) OnRelease(id ShardID) {
+	if h.gate != nil {
+		h.mut.Lock()
+		h.blocked[id] = true
+		h.mut.Unlock()
+
+		<-h.gate
+	}
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	delete(h.blocked, id)
+	delete(h.running, id)
+	h.releases[id]++
+}
+
+func (h *testShardHandler) getRunning() []ShardID {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return getKeys(h.running)
+}
+
+func (h *testShardHandler) getReleases() map[ShardID]int {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return maps.Clone(h.releases)
+}
+
+// waitBlocked waits until OnRelease of the shards are all waiting for the gate
+func (h *testShardHandler) waitBlocked(t *testing.T, shards []ShardID) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		h.mut.Lock()
+		defer h.mut.Unlock()
+		return slices.Equal(shards, getKeys(h.blocked))
+	}, 5*time.Second, time.Millisecond)
+}
+
+func (h *testShardHandler) waitRunning(t *testing.T, shards []ShardID) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		return slices.Equal(shards, h.getRunning())
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestSharding_Shard_Handler(t *testing.T) {
+	store := initStore()
+
+	h := newTestShardHandler()
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithShardHandler(h))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	h.waitRunning(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7})
+
+	// node02 joined
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}))
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 5)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	h.waitRunning(t, []ShardID{0, 1, 2, 3})
+	assert.Equal(t, map[ShardID]int{4: 1, 5: 1, 6: 1, 7: 1}, h.getReleases())
+
+	// the session of node01 expired, the workers of the new session wait for the workers of the previous one
+	h.gate = make(chan struct{})
+
+	store.SessionExpired(client1)
+	store.Begin(client1)
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 5)
+	runTesterWithoutErrors(tester)
+
+	// the new session holds the same shards as the previous one
+	assert.Equal(t, []ShardID{0, 1, 2, 3}, getStoreAssigns(store)["node01"])
+
+	h.waitBlocked(t, []ShardID{0, 1, 2, 3})
+
+	close(h.gate)
+	h.waitRunning(t, getStoreAssigns(store)["node01"])
+
+	h.mut.Lock()
+	assert.Equal(t, []ShardID(nil), h.acquiredWhileBlocked)
+	assert.Equal(t, false, h.overlapped)
+	assert.Equal(t, 2, h.acquires[0])
+	h.mut.Unlock()
+}
+
+func TestSharding_Shard_Handler__Two_Phase_Handoff(t *testing.T) {
+	store := initStore()
+
+	h := newTestShardHandler()
+	h.gate = make(chan struct{})
+
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}), WithTwoPhaseHandoff(), WithShardHandler(h),
+	)
+	timer := newFakeTimer()
+	timer.install(s1)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	h.waitRunning(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7})
+
+	// node02 joined
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithTwoPhaseHandoff())
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	// revoked from node01, but NOT granted to node02 until the workers of node01 stopped
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+	}, getStoreAssigns(store))
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, getStoreStatus(store)["node01"])
+	assert.Equal(t, 0, timer.pendingLen())
+
+	close(h.gate)
+	assert.Eventually(t, func() bool {
+		return timer.pendingLen() == 4
+	}, 5*time.Second, time.Millisecond)
+	h.waitRunning(t, []ShardID{0, 1, 2, 3})
+
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, []ShardID{0, 1, 2, 3}, getStoreStatus(store)["node01"])
+}
+
+func TestSharding_Shard_Handler__With_Errors(t *testing.T) {
+	for k := 0; k < 20; k++ {
+		store := initStore()
+
+		clients := []curator.FakeClientID{client1, client2, client3}
+		handlers := make([]*testShardHandler, len(clients))
+		for i, client := range clients {
+			nodeID := fmt.Sprintf("node%02d", i+1)
+			handlers[i] = newTestShardHandler()
+			startSharding(store, client, nodeID, WithLogger(&noopLogger{}), WithShardHandler(handlers[i]))
+		}
+
+		tester := curator.NewFakeZookeeperTester(store, clients, int64(7000+k))
+		tester.Begin()
+		runTesterWithExactSteps(tester, 0.2, 500)
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+
+		assigns := getStoreAssigns(store)
+		for i, h := range handlers {
+			shards := slices.Clone(assigns[fmt.Sprintf("node%02d", i+1)])
+			slices.Sort(shards)
+			h.waitRunning(t, shards)
+
+			h.mut.Lock()
+			assert.Equal(t, false, h.overlapped)
+			h.mut.Unlock()
+		}
+	}
+}
+
+func TestSharding_Shard_Handler__Invalid(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid shard handler", func() {
+		New(parentPath, "node01", numShards, "addr", WithShardHandler(nil))
+	})
+}
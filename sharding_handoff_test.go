@@ -0,0 +1,221 @@
+package sharding
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+// statusWithoutSession returns the data of a status znode without the random session token
+func statusWithoutSession(data []byte) string {
+	var status statusData
+	if err := json.Unmarshal(data, &status); err != nil {
+		panic(err)
+	}
+	status.Session = ""
+	return string(status.marshalJSON())
+}
+
+func TestSharding_Two_Phase_Handoff__New_Node_Added(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithTwoPhaseHandoff())
+
+	store.Begin(client1)
+	initContainerNodesWithExtras(store, client1, 1)
+
+	store.ChildrenApply(client1) // lock children
+	store.CreateApply(client1)   // create status/node01
+	store.ChildrenApply(client1) // owner list assigns
+	store.CreateApply(client1)   // lock create
+	store.ChildrenApply(client1) // lock granted
+
+	store.ChildrenApply(client1) // list assigns
+	store.ChildrenApply(client1) // list nodes
+	store.ChildrenApply(client1) // list status
+	nodeDataFetched(store, client1, 1)
+	store.GetApply(client1) // get status/node01
+
+	store.CreateApply(client1) // create assigns/node01
+
+	store.ChildrenApply(client1) // owner list assigns
+	store.GetApply(client1)      // owner get assigns/node01
+	store.SetApply(client1)      // set status/node01
+	store.GetApply(client1)      // leader get status/node01
+
+	assert.Equal(t, []string{}, store.PendingCalls(client1))
+
+	assigns := store.Root.Children[0].Children[2].Children
+	assert.Equal(t, `{"shards":[0,1,2,3,4,5,6,7]}`, string(assigns[0].Data))
+
+	status := store.Root.Children[0].Children[4]
+	assert.Equal(t, "status", status.Name)
+	assert.Equal(t, `{"shards":[0,1,2,3,4,5,6,7],"assign_zxid":110}`, statusWithoutSession(status.Children[0].Data))
+
+	// Start Node 2
+	startSharding(store, client2, "node02", WithTwoPhaseHandoff())
+	store.Begin(client2)
+	initContainerNodesWithExtras(store, client2, 1)
+
+	store.ChildrenApply(client2) // lock children
+	store.CreateApply(client2)   // create status/node02
+	store.ChildrenApply(client2) // owner list assigns
+	store.CreateApply(client2)   // lock create
+	store.ChildrenApply(client2) // lock children
+	store.GetApply(client2)      // lock blocked
+
+	store.ChildrenApply(client1) // list nodes
+	store.ChildrenApply(client1) // list status
+	nodeDataFetched(store, client1, 1)
+	store.GetApply(client1) // get status/node02
+
+	// revoke from node01 first
+	store.SetApply(client1)
+
+	assert.Equal(t, `{"shards":[0,1,2,3]}`, string(assigns[0].Data))
+	assert.Equal(t, 1, len(store.Root.Children[0].Children[2].Children))
+	assert.Equal(t, []string{"get-w"}, store.PendingCalls(client1))
+
+	store.GetApply(client1) // owner get assigns/node01
+	store.SetApply(client1) // set status/node01
+	assert.Equal(t, `{"shards":[0,1,2,3],"assign_zxid":115}`, statusWithoutSession(status.Children[0].Data))
+
+	// grant to node02 only after node01 released
+	store.GetApply(client1)    // leader get status/node01
+	store.CreateApply(client1) // create assigns/node02
+	store.ChildrenApply(client1)
+	assert.Equal(t, []string{}, store.PendingCalls(client1))
+
+	assigns = store.Root.Children[0].Children[2].Children
+	assert.Equal(t, 2, len(assigns))
+	assert.Equal(t, "node02", assigns[1].Name)
+	assert.Equal(t, `{"shards":[4,5,6,7]}`, string(assigns[1].Data))
+}
+
+func checkNoDualOwnership(t *testing.T, store *curator.FakeZookeeper, nodes map[curator.FakeClientID]*Sharding) {
+	owners := map[ShardID]string{}
+	for client, s := range nodes {
+		if !store.States[client].HasSession {
+			// NOT protected by the two-phase handoff, see WithTwoPhaseHandoff
+			continue
+		}
+		for _, id := range s.owner.shards {
+			prev, existed := owners[id]
+			if existed {
+				assert.Failf(t, "dual ownership", "shard %d is owned by both %s and %s", id, prev, s.nodeID)
+			}
+			owners[id] = s.nodeID
+		}
+	}
+}
+
+func TestSharding_Two_Phase_Handoff__Using_Tester__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		nodes := map[curator.FakeClientID]*Sharding{
+			client1: startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithTwoPhaseHandoff()),
+			client2: startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithTwoPhaseHandoff()),
+			client3: startSharding(store, client3, "node03", WithLogger(&noopLogger{}), WithTwoPhaseHandoff()),
+		}
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3},
+			int64(k),
+		)
+
+		tester.Begin()
+		for i := 0; i < 2000; i++ {
+			tester.RunSessionExpiredAndConnectionError(3, 3, 1, curator.WithRunOperationErrorPercentage(5))
+			checkNoDualOwnership(t, store, nodes)
+		}
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+		checkNoDualOwnership(t, store, nodes)
+
+		var owned []ShardID
+		for _, s := range nodes {
+			owned = append(owned, s.owner.shards...)
+		}
+		assert.Equal(t, numShards, len(owned))
+	}
+}
+
+func TestSharding_Two_Phase_Handoff__Status_Of_Previous_Session_Still_Exists(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithTwoPhaseHandoff())
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	oldStatus := findStoreZNode(store, "sharding", "status", "node01")
+	assert.Equal(t, `{"shards":[0,1,2,3,4,5,6,7],"assign_zxid":110}`, statusWithoutSession(oldStatus.Data))
+
+	// the process restarts with a new session, while the previous session has NOT expired yet
+	second := startSharding(store, client2, "node01",
+		WithLogger(&noopLogger{}), WithTwoPhaseHandoff(),
+		WithDuplicateNodePolicy(DuplicateNodeTakeOver),
+	)
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client2}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	// the status znode of the previous session is NOT adopted
+	assert.Equal(t, []ShardID{}, second.MyShards())
+	assert.Same(t, oldStatus, findStoreZNode(store, "sharding", "status", "node01"))
+
+	// the previous session expired
+	store.SessionExpired(client1)
+	runTesterWithoutErrors(tester)
+
+	newStatus := findStoreZNode(store, "sharding", "status", "node01")
+	assert.NotSame(t, oldStatus, newStatus)
+	assert.Equal(t, newStatus.Stat.Czxid, second.owner.status.czxid)
+
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, second.MyShards())
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Two_Phase_Handoff__Slow_Node_Only_Blocks_Its_Revoked_Shards(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithTwoPhaseHandoff())
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithTwoPhaseHandoff())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	// node01 does NOT acknowledge its assign znode anymore, node02 is the leader
+	startSharding(store, client3, "node03", WithLogger(&noopLogger{}), WithTwoPhaseHandoff())
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client2, client3}, 123)
+	store.Begin(client3)
+	runTesterWithoutErrors(tester)
+
+	// the shard revoked from node02 is granted, the one revoked from node01 is NOT
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2},
+		"node02": {4, 5, 6},
+		"node03": {7},
+	}, getStoreAssigns(store))
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, client3}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2},
+		"node02": {4, 5, 6},
+		"node03": {7, 3},
+	}, getStoreAssigns(store))
+}
@@ -0,0 +1,186 @@
+package sharding
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+type sessionLeaseTest struct {
+	store  *curator.FakeZookeeper
+	timer  *fakeTimer
+	tester *curator.FakeZookeeperTester
+
+	s       *Sharding
+	r       *shardChangeRecorder
+	handler *testShardHandler
+	lostNum int
+}
+
+func newSessionLeaseTest() *sessionLeaseTest {
+	l := &sessionLeaseTest{
+		store:   initStore(),
+		timer:   newFakeTimer(),
+		r:       &shardChangeRecorder{},
+		handler: newTestShardHandler(),
+	}
+
+	l.s = startSharding(l.store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		l.r.listener(),
+		WithShardHandler(l.handler),
+		WithSessionLossTimeout(4*time.Second, func() {
+			l.lostNum++
+		}),
+	)
+	l.timer.install(l.s)
+
+	l.tester = curator.NewFakeZookeeperTester(l.store, []curator.FakeClientID{client1}, 123)
+	l.tester.Begin()
+	runTesterWithoutErrors(l.tester)
+	return l
+}
+
+// tick moves the time forward by the check interval and fires the check
+func (l *sessionLeaseTest) tick() {
+	l.timer.now = l.timer.now.Add(time.Second)
+	l.timer.fireAll()
+}
+
+func TestSharding_Session_Loss_Timeout(t *testing.T) {
+	l := newSessionLeaseTest()
+
+	allShards := []ShardID{0, 1, 2, 3, 4, 5, 6, 7}
+	assert.Equal(t, allShards, l.s.MyShards())
+	l.handler.waitRunning(t, allShards)
+	assert.Equal(t, []time.Duration{time.Second}, l.timer.durations)
+
+	// the requests succeeded
+	for i := 0; i < 8; i++ {
+		l.tick()
+		runTesterWithoutErrors(l.tester)
+	}
+	assert.Equal(t, 0, l.lostNum)
+	assert.Equal(t, allShards, l.s.MyShards())
+	assert.Equal(t, 1, l.timer.pendingLen())
+
+	// no responses, e.g. the connection is broken
+	for i := 0; i < 3; i++ {
+		l.tick()
+	}
+	assert.Equal(t, 0, l.lostNum)
+
+	l.tick()
+	assert.Equal(t, 1, l.lostNum)
+	assert.Equal(t, []ShardID{}, l.s.MyShards())
+	assert.Equal(t, []ShardID(nil), l.r.shards)
+	l.handler.waitRunning(t, nil)
+
+	_, ok := l.s.ShardEpoch(0)
+	assert.False(t, ok)
+
+	// only called once
+	l.tick()
+	assert.Equal(t, 1, l.lostNum)
+
+	// the responses come back in the same session
+	runTesterWithoutErrors(l.tester)
+
+	assert.Equal(t, 1, l.lostNum)
+	assert.Equal(t, allShards, l.s.MyShards())
+	assert.Equal(t, allShards, l.r.shards)
+	l.handler.waitRunning(t, allShards)
+
+	l.tick()
+	runTesterWithoutErrors(l.tester)
+	assert.Equal(t, 1, l.lostNum)
+}
+
+func TestSharding_Session_Loss_Timeout__Session_Expired(t *testing.T) {
+	l := newSessionLeaseTest()
+
+	allShards := []ShardID{0, 1, 2, 3, 4, 5, 6, 7}
+	l.handler.waitRunning(t, allShards)
+
+	l.store.SessionExpired(client1)
+	for i := 0; i < 4; i++ {
+		l.tick()
+	}
+	assert.Equal(t, 1, l.lostNum)
+	assert.Equal(t, []ShardID{}, l.s.MyShards())
+	l.handler.waitRunning(t, nil)
+
+	// new session, the checks of the previous session are stopped
+	l.store.Begin(client1)
+	runTesterWithoutErrors(l.tester)
+
+	assert.Equal(t, allShards, l.s.MyShards())
+	l.handler.waitRunning(t, allShards)
+
+	for i := 0; i < 8; i++ {
+		l.tick()
+		runTesterWithoutErrors(l.tester)
+	}
+	assert.Equal(t, 1, l.lostNum)
+	assert.Equal(t, 1, l.timer.pendingLen())
+}
+
+func TestSharding_Session_Loss_Timeout__Without_Shard_Handler(t *testing.T) {
+	store := initStore()
+	timer := newFakeTimer()
+
+	lostNum := 0
+	s := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		WithSessionLossTimeout(4*time.Second, func() {
+			lostNum++
+		}),
+	)
+	timer.install(s)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	for i := 0; i < 4; i++ {
+		timer.now = timer.now.Add(time.Second)
+		timer.fireAll()
+	}
+	assert.Equal(t, 1, lostNum)
+	assert.Equal(t, []ShardID{}, s.MyShards())
+}
+
+func TestSharding_Session_Loss_Timeout__Invalid(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid session loss timeout", func() {
+		New(parentPath, "node01", numShards, "addr", WithSessionLossTimeout(0, nil))
+	})
+}
+
+func TestSessionLease__Callbacks_Without_Lock(t *testing.T) {
+	now := time.Now()
+
+	var l *sessionLease
+	var calls []string
+	l = newSessionLease(parentPath, 4*time.Second, func() {
+		calls = append(calls, "lost")
+		// the lock of the lease is NOT held
+		l.stop()
+	})
+	l.now = func() time.Time { return now }
+	l.afterFunc = func(d time.Duration, fn func()) {}
+	l.suspend = func() {
+		calls = append(calls, "suspend")
+	}
+
+	l.start(nil)
+	assert.Equal(t, true, l.check(1))
+
+	now = now.Add(4 * time.Second)
+	assert.Equal(t, true, l.check(1))
+	assert.Equal(t, []string{"suspend", "lost"}, calls)
+
+	// stopped by onLost
+	assert.Equal(t, false, l.check(1))
+}
@@ -0,0 +1,196 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func newLoadReportFunc(store *curator.FakeZookeeper, nodeID string, costs map[ShardID]float64) LoadReportFunc {
+	return func() map[ShardID]ShardLoad {
+		result := map[ShardID]ShardLoad{}
+		for _, id := range getStoreAssigns(store)[nodeID] {
+			result[id] = ShardLoad{CPU: costs[id]}
+		}
+		return result
+	}
+}
+
+func TestSharding_Load_Aware_Rebalance(t *testing.T) {
+	store := initStore()
+
+	costs := map[ShardID]float64{
+		0: 20, 1: 20, 2: 1, 3: 1,
+		4: 1, 5: 1, 6: 1, 7: 1,
+	}
+
+	timer := newFakeTimer()
+	newOptions := func(nodeID string) []Option {
+		return []Option{
+			WithLogger(&noopLogger{}),
+			WithLoadReporting(10*time.Second, newLoadReportFunc(store, nodeID, costs)),
+			WithAllocator(NewLoadAwareAllocator(cpuCost, 0.1)),
+		}
+	}
+
+	s1 := startSharding(store, client1, "node01", newOptions("node01")...)
+	timer.install(s1)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	s2 := startSharding(store, client2, "node02", newOptions("node02")...)
+	timer.install(s2)
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	// publish the load reports
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	assert.ElementsMatch(t, []string{"node01", "node02"}, getStoreChildNames(store, "sharding", "loads"))
+	assert.Equal(t,
+		`{"shards":{"0":{"cpu":20},"1":{"cpu":20},"2":{"cpu":1},"3":{"cpu":1}}}`,
+		string(findStoreZNode(store, "sharding", "loads", "node01").Data),
+	)
+
+	// the leader reads the reports again
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {1, 2, 3},
+		"node02": {0, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	// stable after more reports
+	for i := 0; i < 3; i++ {
+		timer.fireAll()
+		runTesterWithoutErrors(tester)
+	}
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {1, 2, 3},
+		"node02": {0, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, 3, len(timer.pending))
+}
+
+func TestSharding_Load_Aware_Rebalance__With_Errors(t *testing.T) {
+	for k := 0; k < 50; k++ {
+		store := initStore()
+
+		costs := map[ShardID]float64{0: 20, 1: 20}
+
+		timer := newFakeTimer()
+		clients := []curator.FakeClientID{client1, client2, client3}
+		for i, client := range clients {
+			nodeID := fmt.Sprintf("node%02d", i+1)
+			s := startSharding(store, client, nodeID,
+				WithLogger(&noopLogger{}),
+				WithLoadReporting(10*time.Second, newLoadReportFunc(store, nodeID, costs)),
+				WithAllocator(NewLoadAwareAllocator(cpuCost, 0.1)),
+			)
+			timer.install(s)
+		}
+
+		tester := curator.NewFakeZookeeperTester(store, clients, int64(1000+k))
+		tester.Begin()
+
+		for i := 0; i < 20; i++ {
+			runTesterWithExactSteps(tester, 0.1, 30)
+			// the fake client does NOT accept requests while disconnected
+			runTesterWithoutErrors(tester)
+			timer.fireAll()
+		}
+		for i := 0; i < 3; i++ {
+			runTesterWithoutErrors(tester)
+			timer.fireAll()
+		}
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+
+		owners := map[ShardID]string{}
+		for nodeID, shards := range getStoreAssigns(store) {
+			for _, id := range shards {
+				owners[id] = nodeID
+			}
+		}
+		assert.NotEqual(t, owners[0], owners[1])
+	}
+}
+
+func TestSharding_Load_Reports__Replaced_After_All_Read(t *testing.T) {
+	store := initStore()
+
+	costs := map[ShardID]float64{0: 20, 1: 20}
+
+	timer := newFakeTimer()
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		WithLoadReporting(10*time.Second, newLoadReportFunc(store, "node01", costs)),
+	)
+	timer.install(s1)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	s2 := startSharding(store, client2, "node02",
+		WithLogger(&noopLogger{}),
+		WithLoadReporting(10*time.Second, newLoadReportFunc(store, "node02", costs)),
+	)
+	timer.install(s2)
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+
+	// publish the load reports, then the leader reads them
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	loads := s1.state.loads
+	assert.Equal(t, 2, len(loads))
+
+	s1.refreshLoadReports(s1.getCurrentSession(), s1.sessionSeq, s1.state)
+	store.ChildrenApply(client1) // list loads
+	store.GetApply(client1)      // get loads/node01
+	assert.Equal(t, loads, s1.state.loads)
+
+	store.ConnError(client1)
+	assert.Equal(t, loads, s1.state.loads)
+
+	runTesterWithoutErrors(tester)
+	assert.Equal(t, 2, len(s1.state.loads))
+}
+
+func TestSharding_Load_Reporting_Errors(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid load report interval", func() {
+		New(parentPath, "node01", numShards, "addr", WithLoadReporting(0, func() map[ShardID]ShardLoad {
+			return nil
+		}))
+	})
+	assert.PanicsWithValue(t, "Invalid load report func", func() {
+		New(parentPath, "node01", numShards, "addr", WithLoadReporting(time.Second, nil))
+	})
+	assert.PanicsWithValue(t, "Invalid load cost func", func() {
+		NewLoadAwareAllocator(nil, 0)
+	})
+	assert.PanicsWithValue(t, "Invalid load tolerance", func() {
+		NewLoadAwareAllocator(cpuCost, -1)
+	})
+}
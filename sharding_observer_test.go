@@ -315,6 +315,7 @@ func TestStandaloneObserver_2_Nodes(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 2)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 
@@ -333,14 +334,16 @@ func TestStandaloneObserver_2_Nodes(t *testing.T) {
 			{
 				ID:      "node01",
 				Address: "node01-addr:4001",
+				Weight:  1,
 				Shards:  []ShardID{0, 1, 2, 3},
-				MZxid:   109,
+				MZxid:   110,
 			},
 			{
 				ID:      "node02",
 				Address: "node02-addr:4001",
+				Weight:  1,
 				Shards:  []ShardID{4, 5, 6, 7},
-				MZxid:   110,
+				MZxid:   111,
 			},
 		},
 	}, events[0])
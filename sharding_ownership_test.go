@@ -0,0 +1,160 @@
+package sharding
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+type shardChange struct {
+	acquired []ShardID
+	released []ShardID
+}
+
+type shardChangeRecorder struct {
+	changes []shardChange
+	shards  []ShardID
+}
+
+func (r *shardChangeRecorder) listener() Option {
+	return WithShardListener(func(acquired []ShardID, released []ShardID) {
+		r.changes = append(r.changes, shardChange{acquired: acquired, released: released})
+		r.shards = append(subtractShards(r.shards, released), acquired...)
+		slices.Sort(r.shards)
+	})
+}
+
+func (r *shardChangeRecorder) popChanges() []shardChange {
+	changes := r.changes
+	r.changes = nil
+	return changes
+}
+
+func TestSharding_My_Shards(t *testing.T) {
+	store := initStore()
+
+	r := &shardChangeRecorder{}
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), r.listener())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, s1.MyShards())
+	assert.Equal(t, []shardChange{
+		{acquired: []ShardID{0, 1, 2, 3, 4, 5, 6, 7}},
+	}, r.popChanges())
+
+	// without WithShardStatus
+	assert.Nil(t, findStoreZNode(store, "sharding", "status"))
+
+	// node02 joined
+	s2 := startSharding(store, client2, "node02", WithLogger(&noopLogger{}))
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []ShardID{0, 1, 2, 3}, s1.MyShards())
+	assert.Equal(t, []shardChange{
+		{released: []ShardID{4, 5, 6, 7}},
+	}, r.popChanges())
+
+	// also tracked without a listener
+	assert.Equal(t, []ShardID{4, 5, 6, 7}, s2.MyShards())
+
+	// node02 expired
+	store.SessionExpired(client2)
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, s1.MyShards())
+	assert.Equal(t, []shardChange{
+		{acquired: []ShardID{4, 5, 6, 7}},
+	}, r.popChanges())
+
+	// the session of node01 expired
+	store.SessionExpired(client1)
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, s1.MyShards())
+
+	store.Begin(client1)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, s1.MyShards())
+	assert.Equal(t, []shardChange{
+		{released: []ShardID{0, 1, 2, 3, 4, 5, 6, 7}},
+		{acquired: []ShardID{0, 1, 2, 3, 4, 5, 6, 7}},
+	}, r.popChanges())
+}
+
+func TestSharding_My_Shards__With_Shard_Status(t *testing.T) {
+	store := initStore()
+
+	r := &shardChangeRecorder{}
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithTwoPhaseHandoff(), r.listener())
+	s2 := startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithTwoPhaseHandoff())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assigns := getStoreAssigns(store)
+	assert.Equal(t, assigns["node01"], s1.MyShards())
+	assert.Equal(t, assigns["node02"], s2.MyShards())
+	assert.Equal(t, s1.MyShards(), r.shards)
+	assert.Equal(t, 2, len(findStoreZNode(store, "sharding", "status").Children))
+}
+
+func TestSharding_My_Shards__Coordinator(t *testing.T) {
+	store := initStore()
+
+	r := &shardChangeRecorder{}
+	c := startCoordinator(store, client1, WithLogger(&noopLogger{}), r.listener())
+	startSharding(store, client2, "node01", WithLogger(&noopLogger{}))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Nil(t, c.MyShards())
+	assert.Equal(t, 0, len(r.changes))
+}
+
+func TestSharding_My_Shards__With_Errors(t *testing.T) {
+	for k := 0; k < 100; k++ {
+		store := initStore()
+
+		clients := []curator.FakeClientID{client1, client2, client3}
+		recorders := make([]*shardChangeRecorder, len(clients))
+		nodes := make([]*Sharding, len(clients))
+		for i, client := range clients {
+			nodeID := fmt.Sprintf("node%02d", i+1)
+			recorders[i] = &shardChangeRecorder{}
+			nodes[i] = startSharding(store, client, nodeID, WithLogger(&noopLogger{}), recorders[i].listener())
+		}
+
+		tester := curator.NewFakeZookeeperTester(store, clients, int64(6000+k))
+		tester.Begin()
+		runTesterWithExactSteps(tester, 0.2, 500)
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+
+		// the notified changes are consistent with the snapshots
+		assigns := getStoreAssigns(store)
+		for i, s := range nodes {
+			shards := slices.Clone(assigns[s.nodeID])
+			slices.Sort(shards)
+			assert.Equal(t, shards, s.MyShards())
+			assert.Equal(t, s.MyShards(), recorders[i].shards)
+		}
+	}
+}
+
+func TestSharding_My_Shards__Invalid(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid shard listener", func() {
+		New(parentPath, "node01", numShards, "addr", WithShardListener(nil))
+	})
+}
@@ -0,0 +1,216 @@
+package sharding
+
+import (
+	"testing"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func startTwoNodesWithPinning(store *curator.FakeZookeeper) (*Sharding, *curator.FakeZookeeperTester) {
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithShardPinning())
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithShardPinning())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	return s1, tester
+}
+
+func TestSharding_Pin_Shard(t *testing.T) {
+	store := initStore()
+
+	s1, tester := startTwoNodesWithPinning(store)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	var errors []error
+	s1.PinShard(5, "node01", func(err error) {
+		errors = append(errors, err)
+	})
+	s1.PinShard(1, "node02", func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{nil, nil}, errors)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 2, 3, 5},
+		"node02": {1, 4, 6, 7},
+	}, getStoreAssigns(store))
+
+	var pins []map[ShardID]string
+	s1.ListPins(func(result map[ShardID]string, err error) {
+		assert.Equal(t, nil, err)
+		pins = append(pins, result)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []map[ShardID]string{
+		{1: "node02", 5: "node01"},
+	}, pins)
+
+	// Unpin does NOT move shards back
+	s1.UnpinShard(5, func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	s1.ListPins(func(result map[ShardID]string, err error) {
+		assert.Equal(t, nil, err)
+		pins = append(pins, result)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{nil, nil, nil}, errors)
+	assert.Equal(t, []map[ShardID]string{
+		{1: "node02", 5: "node01"},
+		{1: "node02"},
+	}, pins)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 2, 3, 5},
+		"node02": {1, 4, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Pin_Shard__Node_Not_Active(t *testing.T) {
+	store := initStore()
+
+	s1, tester := startTwoNodesWithPinning(store)
+
+	s1.PinShard(2, "node03", func(err error) {
+		assert.Equal(t, nil, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	// pin is ignored
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	startSharding(store, client3, "node03", WithLogger(&noopLogger{}), WithShardPinning())
+	store.Begin(client3)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, client3}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 3},
+		"node02": {4, 5, 6},
+		"node03": {2, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Pin_Shard__Errors(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithShardPinning())
+
+	// called on a new goroutine without session
+	errCh := make(chan error, 2)
+	s1.PinShard(1, "node01", func(err error) {
+		errCh <- err
+	})
+	s1.ListPins(func(pins map[ShardID]string, err error) {
+		errCh <- err
+	})
+	assert.Equal(t, ErrNoSession, <-errCh)
+	assert.Equal(t, ErrNoSession, <-errCh)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	var errors []error
+	s1.PinShard(numShards, "node01", func(err error) {
+		errors = append(errors, err)
+	})
+	assert.Equal(t, 0, len(errors))
+
+	// called on the goroutine of the zookeeper client
+	runTesterWithoutErrors(tester)
+	assert.Equal(t, []error{ErrInvalidShardID}, errors)
+}
+
+func TestSharding_Pin_Shard__Overrides_Deleted(t *testing.T) {
+	store := initStore()
+
+	s1, tester := startTwoNodesWithPinning(store)
+
+	var errors []error
+	s1.PinShard(1, "node02", func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 2, 3, 4},
+		"node02": {1, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	version := findStoreZNode(store, "sharding", "overrides").Stat.Version
+	s1.getCurrentSession().GetClient().Delete(s1.getOverridesPath(), version, func(_ zk.DeleteResponse, err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	// treated as no overrides, and created again for watching
+	overrides := findStoreZNode(store, "sharding", "overrides")
+	assert.NotNil(t, overrides)
+	assert.Equal(t, 0, len(overrides.Data))
+
+	s1.PinShard(6, "node01", func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{nil, nil, nil}, errors)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 2, 3, 6},
+		"node02": {1, 5, 7, 4},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Pin_Shard__Using_Tester__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithShardPinning())
+		startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithShardPinning())
+		startSharding(store, client3, "node03", WithLogger(&noopLogger{}), WithShardPinning())
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3},
+			int64(k),
+		)
+		tester.Begin()
+		runTesterWithoutErrors(tester)
+
+		var numCalls int
+		var pinned []ShardID
+		for _, id := range []ShardID{0, 1, 2, 3} {
+			id := id
+			s1.PinShard(id, "node03", func(err error) {
+				numCalls++
+				// pins can fail because of connection errors
+				if err == nil {
+					pinned = append(pinned, id)
+				}
+			})
+		}
+
+		runTesterWithExactSteps(tester, 5, 2000)
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+
+		assert.Equal(t, 4, numCalls)
+		assert.Subset(t, getStoreAssigns(store)["node03"], pinned)
+	}
+}
@@ -0,0 +1,256 @@
+package sharding
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTimer struct {
+	now time.Time
+
+	// mut protects now, durations and pending, for timers added from worker goroutines of shard handlers
+	// and from the goroutines of WaitStable
+	mut       sync.Mutex
+	durations []time.Duration
+	pending   []func()
+}
+
+func newFakeTimer() *fakeTimer {
+	return &fakeTimer{
+		now: time.Date(2024, 4, 10, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func (t *fakeTimer) install(s *Sharding) {
+	s.now = t.getNow
+	s.afterFunc = t.afterFunc
+}
+
+func (t *fakeTimer) getNow() time.Time {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.now
+}
+
+// advance moves the time forward, it is safe while other goroutines are calling getNow
+func (t *fakeTimer) advance(d time.Duration) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.now = t.now.Add(d)
+}
+
+func (t *fakeTimer) afterFunc(d time.Duration, fn func()) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.durations = append(t.durations, d)
+	t.pending = append(t.pending, fn)
+}
+
+// lastDuration returns the duration of the latest timer, zero if none
+func (t *fakeTimer) lastDuration() time.Duration {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if len(t.durations) == 0 {
+		return 0
+	}
+	return t.durations[len(t.durations)-1]
+}
+
+func (t *fakeTimer) pendingLen() int {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return len(t.pending)
+}
+
+// takeAll removes the pending callbacks without calling them
+func (t *fakeTimer) takeAll() []func() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+func (t *fakeTimer) fireAll() {
+	for _, fn := range t.takeAll() {
+		fn()
+	}
+}
+
+func TestSharding_Max_Moves_Per_Round(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithMaxMovesPerRound(1))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	// Start Node 2
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithMaxMovesPerRound(1))
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+
+	var node02Shards [][]ShardID
+	for len(store.PendingCalls(client1))+len(store.PendingCalls(client2)) > 0 {
+		tester.RunSessionExpiredAndConnectionError(0, 0, 1)
+
+		shards, ok := getStoreAssigns(store)["node02"]
+		if !ok {
+			continue
+		}
+		if len(node02Shards) > 0 && slices.Equal(node02Shards[len(node02Shards)-1], shards) {
+			continue
+		}
+		node02Shards = append(node02Shards, shards)
+	}
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	assert.Equal(t, [][]ShardID{
+		{4},
+		{4, 5},
+		{4, 5, 6},
+		{4, 5, 6, 7},
+	}, node02Shards)
+}
+
+func TestSharding_Min_Rebalance_Interval(t *testing.T) {
+	store := initStore()
+
+	timer := newFakeTimer()
+
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		WithMaxMovesPerRound(2),
+		WithMinRebalanceInterval(time.Minute),
+	)
+	timer.install(s1)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, 0, len(timer.pending))
+
+	// Start Node 2
+	startSharding(store, client2, "node02",
+		WithLogger(&noopLogger{}),
+		WithMaxMovesPerRound(2),
+		WithMinRebalanceInterval(time.Minute),
+	)
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 6, 7},
+		"node02": {4, 5},
+	}, getStoreAssigns(store))
+	assert.Equal(t, []time.Duration{time.Minute}, timer.durations)
+
+	// Fire timer after 1 minute
+	timer.now = timer.now.Add(time.Minute)
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, 0, len(timer.pending))
+	assert.Equal(t, []time.Duration{time.Minute}, timer.durations)
+}
+
+func TestSharding_Min_Rebalance_Interval__Node_Removed_Not_Limited(t *testing.T) {
+	store := initStore()
+
+	timer := newFakeTimer()
+
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		WithMinRebalanceInterval(time.Minute),
+	)
+	timer.install(s1)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	// Start Node 2
+	startSharding(store, client2, "node02",
+		WithLogger(&noopLogger{}),
+		WithMinRebalanceInterval(time.Minute),
+	)
+	store.Begin(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+
+	// Node 2 is removed right after the previous rebalance
+	store.SessionExpired(client2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, 0, len(timer.pending))
+}
+
+func TestSharding_Rate_Limiting__Using_Tester__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		timer := newFakeTimer()
+		options := []Option{
+			WithLogger(&noopLogger{}),
+			WithMaxMovesPerRound(1),
+			WithMinRebalanceInterval(time.Second),
+		}
+
+		timer.install(startSharding(store, client1, "node01", options...))
+		timer.install(startSharding(store, client2, "node02", options...))
+		timer.install(startSharding(store, client3, "node03", options...))
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3},
+			int64(k),
+		)
+
+		tester.Begin()
+		runTesterWithExactSteps(tester, 5, 2000)
+		runTesterWithoutErrors(tester)
+
+		for len(timer.pending) > 0 {
+			timer.now = timer.now.Add(time.Second)
+			timer.fireAll()
+			runTesterWithoutErrors(tester)
+		}
+
+		checkFinalShards(t, store)
+	}
+}
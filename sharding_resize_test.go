@@ -0,0 +1,224 @@
+package sharding
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapShards(t *testing.T) {
+	t.Run("split", func(t *testing.T) {
+		assert.Equal(t, []ShardID{1, 5, 3, 7}, mapShards([]ShardID{1, 3}, 4, 8))
+		assert.Equal(t, []ShardID{1, 5, 9}, mapShards([]ShardID{1}, 4, 12))
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		assert.Equal(t, []ShardID{1, 3}, mapShards([]ShardID{1, 3, 5, 7}, 8, 4))
+		assert.Equal(t, []ShardID{0}, mapShards([]ShardID{4}, 8, 2))
+	})
+
+	t.Run("neither split nor merge", func(t *testing.T) {
+		assert.Equal(t, []ShardID{1, 3, 5}, mapShards([]ShardID{3}, 4, 6))
+	})
+
+	t.Run("unknown number of shards", func(t *testing.T) {
+		assert.Equal(t, []ShardID{3, 9}, mapShards([]ShardID{3, 9}, 0, 8))
+	})
+}
+
+func TestNewShardResize(t *testing.T) {
+	assert.Equal(t, &ShardResize{
+		OldNumShards: 2,
+		NewNumShards: 4,
+		Mapping: map[ShardID][]ShardID{
+			0: {0, 2},
+			1: {1, 3},
+		},
+	}, newShardResize(2, 4))
+
+	assert.Equal(t, &ShardResize{
+		OldNumShards: 4,
+		NewNumShards: 2,
+		Mapping: map[ShardID][]ShardID{
+			0: {0},
+			1: {1},
+			2: {0},
+			3: {1},
+		},
+	}, newShardResize(4, 2))
+}
+
+func TestIsValidResize(t *testing.T) {
+	assert.Equal(t, true, isValidResize(8, 16))
+	assert.Equal(t, true, isValidResize(8, 2))
+	assert.Equal(t, false, isValidResize(8, 12))
+	assert.Equal(t, false, isValidResize(8, 0))
+	assert.Equal(t, false, isValidResize(0, 8))
+}
+
+func TestSharding_Dynamic_Num_Shards(t *testing.T) {
+	store := initStore()
+
+	var events []ChangeEvent
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithDynamicNumShards())
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithDynamicNumShards())
+
+	factory := curator.NewFakeClientFactory(store, observer1)
+	observer := NewObserver(parentPath, numShards, func(event ChangeEvent) {
+		events = append(events, event)
+	}, WithObserverDynamicNumShards())
+	factory.Start(observer.GetCurator())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, observer1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, ShardID(8), getStoreConfig(store).NumShards)
+	assert.Nil(t, events[len(events)-1].Resize)
+
+	// Split
+	var errors []error
+	s1.SetNumShards(16, func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{nil}, errors)
+	assert.Equal(t, ShardID(16), getStoreConfig(store).NumShards)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 8, 9, 10, 11},
+		"node02": {4, 5, 6, 7, 12, 13, 14, 15},
+	}, getStoreAssigns(store))
+
+	lastEvent := events[len(events)-1]
+	assert.Equal(t, ShardID(8), lastEvent.Resize.OldNumShards)
+	assert.Equal(t, ShardID(16), lastEvent.Resize.NewNumShards)
+	assert.Equal(t, []ShardID{3, 11}, lastEvent.Resize.Mapping[3])
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 8, 9, 10, 11}, lastEvent.New[0].Shards)
+	assert.Equal(t, []ShardID{0, 1, 2, 3}, lastEvent.Old[0].Shards)
+
+	// Merge
+	s1.SetNumShards(4, func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{nil, nil}, errors)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {2, 3},
+	}, getStoreAssigns(store))
+
+	lastEvent = events[len(events)-1]
+	assert.Equal(t, ShardID(16), lastEvent.Resize.OldNumShards)
+	assert.Equal(t, ShardID(4), lastEvent.Resize.NewNumShards)
+	assert.Equal(t, []ShardID{1}, lastEvent.Resize.Mapping[13])
+}
+
+func TestSharding_Dynamic_Num_Shards__Errors(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithDynamicNumShards())
+
+	// called on a new goroutine
+	errCh := make(chan error, 1)
+	s1.SetNumShards(16, func(err error) {
+		errCh <- err
+	})
+	assert.Equal(t, ErrNoSession, <-errCh)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	s1.SetNumShards(0, func(err error) {
+		errCh <- err
+	})
+	assert.Equal(t, ErrInvalidNumShards, <-errCh)
+
+	var errors []error
+	s1.SetNumShards(12, func(err error) {
+		errors = append(errors, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{ErrInvalidNumShards}, errors)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+// checkNoDualOwnershipAfterResize checks dual ownership after converting owned shards to numShards
+func checkNoDualOwnershipAfterResize(
+	t *testing.T, store *curator.FakeZookeeper,
+	nodes map[curator.FakeClientID]*Sharding, numShards ShardID,
+) {
+	owners := map[ShardID]string{}
+	for client, s := range nodes {
+		if !store.States[client].HasSession {
+			continue
+		}
+		for _, id := range mapShards(s.owner.shards, s.owner.assign.numShards, numShards) {
+			prev, existed := owners[id]
+			if existed {
+				assert.Failf(t, "dual ownership", "shard %d is owned by both %s and %s", id, prev, s.nodeID)
+			}
+			owners[id] = s.nodeID
+		}
+	}
+}
+
+func TestSharding_Dynamic_Num_Shards__Two_Phase_Handoff__Using_Tester__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		options := []Option{
+			WithLogger(&noopLogger{}),
+			WithDynamicNumShards(),
+			WithTwoPhaseHandoff(),
+		}
+		nodes := map[curator.FakeClientID]*Sharding{
+			client1: startSharding(store, client1, "node01", options...),
+			client2: startSharding(store, client2, "node02", options...),
+			client3: startSharding(store, client3, "node03", options...),
+		}
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3},
+			int64(k),
+		)
+		tester.Begin()
+		runTesterWithoutErrors(tester)
+
+		var resizeErr error
+		nodes[client1].SetNumShards(16, func(err error) {
+			resizeErr = err
+		})
+
+		for i := 0; i < 2000; i++ {
+			tester.RunSessionExpiredAndConnectionError(3, 3, 1, curator.WithRunOperationErrorPercentage(5))
+			checkNoDualOwnershipAfterResize(t, store, nodes, 16)
+		}
+		runTesterWithoutErrors(tester)
+		checkNoDualOwnershipAfterResize(t, store, nodes, 16)
+
+		var shards []ShardID
+		for _, nodeShards := range getStoreAssigns(store) {
+			shards = append(shards, nodeShards...)
+		}
+		slices.Sort(shards)
+
+		// a resize returned with connection errors might still be applied
+		if resizeErr == nil || len(shards) > 8 {
+			assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, shards)
+		} else {
+			assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, shards)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package sharding
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func getStoreChildNames(store *curator.FakeZookeeper, names ...string) []string {
+	result := []string{}
+	for _, child := range findStoreZNode(store, names...).Children {
+		result = append(result, child.Name)
+	}
+	return result
+}
+
+type shutdownTest struct {
+	store  *curator.FakeZookeeper
+	tester *curator.FakeZookeeperTester
+	timer  *fakeTimer
+
+	s1 *Sharding
+	s2 *Sharding
+
+	errs []error
+}
+
+func newShutdownTest(options ...Option) *shutdownTest {
+	st := &shutdownTest{
+		store: initStore(),
+		timer: newFakeTimer(),
+	}
+
+	options = append([]Option{WithLogger(&noopLogger{})}, options...)
+	st.s1 = startSharding(st.store, client1, "node01", options...)
+	st.timer.install(st.s1)
+
+	st.tester = curator.NewFakeZookeeperTester(st.store, []curator.FakeClientID{client1}, 123)
+	st.tester.Begin()
+	runTesterWithoutErrors(st.tester)
+
+	st.s2 = startSharding(st.store, client2, "node02", options...)
+	st.timer.install(st.s2)
+
+	st.tester = curator.NewFakeZookeeperTester(st.store, []curator.FakeClientID{client1, client2}, 123)
+	st.store.Begin(client2)
+	runTesterWithoutErrors(st.tester)
+
+	return st
+}
+
+func (st *shutdownTest) shutdown(s *Sharding) {
+	s.Shutdown(10*time.Second, func(err error) {
+		st.errs = append(st.errs, err)
+	})
+	runTesterWithoutErrors(st.tester)
+}
+
+func TestSharding_Shutdown__Follower(t *testing.T) {
+	// the leader is NOT configured with WithNodeDraining
+	st := newShutdownTest()
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(st.store))
+
+	st.shutdown(st.s2)
+
+	assert.Equal(t, []error{nil}, st.errs)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(st.store))
+
+	assert.Equal(t, []string{"node01"}, getStoreChildNames(st.store, "sharding", "nodes"))
+	assert.Equal(t, 1, len(getStoreChildNames(st.store, "sharding", "locks")))
+
+	// the timeout is ignored after finished
+	st.timer.fireAll()
+	runTesterWithoutErrors(st.tester)
+	assert.Equal(t, []error{nil}, st.errs)
+
+	// shutdown only once
+	var err error
+	st.s2.Shutdown(time.Second, func(e error) {
+		err = e
+	})
+	assert.Equal(t, ErrShutdown, err)
+}
+
+func TestSharding_Shutdown__Leader(t *testing.T) {
+	st := newShutdownTest(WithShardListener(func(acquired []ShardID, released []ShardID) {}))
+
+	st.shutdown(st.s1)
+
+	assert.Equal(t, []error{nil}, st.errs)
+	assert.Equal(t, map[string][]ShardID{
+		"node02": {4, 5, 6, 7, 0, 1, 2, 3},
+	}, getStoreAssigns(st.store))
+	assert.Equal(t, []string{"node02"}, getStoreChildNames(st.store, "sharding", "nodes"))
+	assert.Equal(t, []ShardID{}, st.s1.MyShards())
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, st.s2.MyShards())
+
+	// node02 is the new leader
+	st.store.SessionExpired(client1)
+	startSharding(st.store, client3, "node03", WithLogger(&noopLogger{}))
+	tester := curator.NewFakeZookeeperTester(st.store, []curator.FakeClientID{client2, client3}, 123)
+	st.store.Begin(client3)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node02": {0, 1, 2, 3},
+		"node03": {4, 5, 6, 7},
+	}, getStoreAssigns(st.store))
+}
+
+func TestSharding_Shutdown__Timeout(t *testing.T) {
+	st := newShutdownTest(WithNodeDraining())
+
+	// node02 keeps the shards of node01 if node02 is also draining
+	st.s1.DrainNode("node02", func(err error) {})
+	runTesterWithoutErrors(st.tester)
+
+	st.shutdown(st.s1)
+	assert.Equal(t, 0, len(st.errs))
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(st.store))
+	assert.Equal(t, []time.Duration{10 * time.Second}, st.timer.durations)
+
+	st.timer.fireAll()
+	runTesterWithoutErrors(st.tester)
+
+	assert.Equal(t, []error{ErrShutdownTimeout}, st.errs)
+	assert.Equal(t, map[string][]ShardID{
+		"node02": {4, 5, 6, 7, 0, 1, 2, 3},
+	}, getStoreAssigns(st.store))
+	assert.Equal(t, []string{"node02"}, getStoreChildNames(st.store, "sharding", "drains"))
+}
+
+func getStoreNodeData(store *curator.FakeZookeeper, nodeID string) nodeData {
+	var data nodeData
+	if err := json.Unmarshal(findStoreZNode(store, "sharding", "nodes", nodeID).Data, &data); err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func TestSharding_Shutdown__Error_Then_Again(t *testing.T) {
+	st := newShutdownTest()
+
+	node := findStoreZNode(st.store, "sharding", "nodes", "node02")
+	validData := node.Data
+	node.Data = []byte("invalid")
+
+	st.shutdown(st.s2)
+	assert.Equal(t, 1, len(st.errs))
+	assert.Error(t, st.errs[0])
+	assert.Equal(t, []ShardID{4, 5, 6, 7}, st.s2.MyShards())
+
+	// the timeout of the failed shutdown is ignored
+	st.timer.fireAll()
+	runTesterWithoutErrors(st.tester)
+	assert.Equal(t, 1, len(st.errs))
+
+	// can be called again
+	node.Data = validData
+	st.shutdown(st.s2)
+
+	assert.Equal(t, nil, st.errs[1])
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(st.store))
+	assert.Equal(t, []string{"node01"}, getStoreChildNames(st.store, "sharding", "nodes"))
+}
+
+func TestSharding_Shutdown__Connection_Error(t *testing.T) {
+	st := newShutdownTest()
+
+	st.s2.Shutdown(10*time.Second, func(err error) {
+		st.errs = append(st.errs, err)
+	})
+	st.store.ChildrenApply(client2)
+	st.store.ConnError(client2)
+	runTesterWithoutErrors(st.tester)
+
+	assert.Equal(t, []error{nil}, st.errs)
+	assert.Equal(t, []string{"node01"}, getStoreChildNames(st.store, "sharding", "nodes"))
+}
+
+func TestSharding_Shutdown__Session_Expired(t *testing.T) {
+	st := newShutdownTest()
+
+	st.s2.Shutdown(10*time.Second, func(err error) {
+		st.errs = append(st.errs, err)
+	})
+	st.store.ChildrenApply(client2)
+	st.store.GetApply(client2)
+
+	// the leaving mark is NOT written by the expired session
+	st.store.SessionExpired(client2)
+	st.store.Begin(client2)
+
+	// the node znode of the new session is created with the leaving mark
+	for !slices.Contains(getStoreChildNames(st.store, "sharding", "nodes"), "node02") {
+		st.tester.RunSessionExpiredAndConnectionError(0, 0, 1)
+	}
+	assert.Equal(t, true, getStoreNodeData(st.store, "node02").Leaving)
+
+	runTesterWithoutErrors(st.tester)
+
+	assert.Equal(t, []error{nil}, st.errs)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(st.store))
+	assert.Equal(t, []string{"node01"}, getStoreChildNames(st.store, "sharding", "nodes"))
+}
+
+func TestSharding_Shutdown__Timeout_After_Session_Expired(t *testing.T) {
+	st := newShutdownTest(WithNodeDraining())
+
+	// node01 keeps its shards if node01 is also draining
+	st.s1.DrainNode("node01", func(err error) {})
+	runTesterWithoutErrors(st.tester)
+
+	st.shutdown(st.s2)
+	assert.Equal(t, 0, len(st.errs))
+
+	// the timeout fired before the new session has started
+	st.store.SessionExpired(client2)
+	st.timer.fireAll()
+	st.store.Begin(client2)
+	runTesterWithoutErrors(st.tester)
+
+	assert.Equal(t, []error{ErrShutdownTimeout}, st.errs)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(st.store))
+	assert.Equal(t, []string{"node01"}, getStoreChildNames(st.store, "sharding", "nodes"))
+}
+
+func TestSharding_Shutdown__Stop_Load_Reporting(t *testing.T) {
+	st := newShutdownTest(WithLoadReporting(10*time.Second, func() map[ShardID]ShardLoad {
+		return nil
+	}))
+
+	st.shutdown(st.s1)
+	assert.Equal(t, []error{nil}, st.errs)
+
+	for i := 0; i < 3; i++ {
+		st.timer.fireAll()
+		runTesterWithoutErrors(st.tester)
+	}
+
+	// only the load report and the load refresh of node02, the new leader
+	assert.Equal(t, 2, st.timer.pendingLen())
+	assert.Equal(t, []string{"node02"}, getStoreChildNames(st.store, "sharding", "loads"))
+}
+
+func TestSharding_Shutdown__Shard_Handler(t *testing.T) {
+	store := initStore()
+	timer := newFakeTimer()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithTwoPhaseHandoff())
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	h := newTestShardHandler()
+	h.gate = make(chan struct{})
+
+	s2 := startSharding(store, client2, "node02",
+		WithLogger(&noopLogger{}), WithTwoPhaseHandoff(), WithShardHandler(h),
+	)
+	timer.install(s2)
+
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+	h.waitRunning(t, []ShardID{4, 5, 6, 7})
+
+	var errs []error
+	s2.Shutdown(10*time.Second, func(err error) {
+		errs = append(errs, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	timeouts := timer.takeAll()
+	assert.Equal(t, 1, len(timeouts))
+
+	// revoked from node02, but NOT granted to node01 until the workers of node02 stopped
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": nil,
+	}, getStoreAssigns(store))
+	assert.Equal(t, []string{"node01", "node02"}, getStoreChildNames(store, "sharding", "nodes"))
+	assert.Equal(t, 0, len(errs))
+
+	close(h.gate)
+	h.waitRunning(t, []ShardID{})
+	assert.Eventually(t, func() bool {
+		// the status updates of the 4 workers and the shutdown
+		return timer.pendingLen() == 5
+	}, 5*time.Second, time.Millisecond)
+
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{nil}, errs)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+	assert.Equal(t, []string{"node01"}, getStoreChildNames(store, "sharding", "nodes"))
+	assert.Equal(t, map[ShardID]int{4: 1, 5: 1, 6: 1, 7: 1}, h.getReleases())
+
+	// the timeout is ignored after finished
+	timeouts[0]()
+	runTesterWithoutErrors(tester)
+	assert.Equal(t, []error{nil}, errs)
+}
+
+func TestSharding_Shutdown__Shard_Groups(t *testing.T) {
+	st := newShutdownTest(WithShardGroup("ingest", 4))
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {2, 3},
+	}, getStoreGroupAssigns(st.store, "ingest"))
+
+	// the shards of the group are also handed off before leaving
+	var groupAssigns map[string][]ShardID
+	st.s2.Shutdown(10*time.Second, func(err error) {
+		st.errs = append(st.errs, err)
+		groupAssigns = getStoreGroupAssigns(st.store, "ingest")
+	})
+	runTesterWithoutErrors(st.tester)
+
+	assert.Equal(t, []error{nil}, st.errs)
+	assert.Equal(t, []ShardID{0, 1, 2, 3}, groupAssigns["node01"])
+	assert.Equal(t, 0, len(groupAssigns["node02"]))
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+	}, getStoreGroupAssigns(st.store, "ingest"))
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(st.store))
+}
+
+func TestSharding_Shutdown__Node_ID_Prefix_Of_Another(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "web", WithLogger(&noopLogger{}))
+	startSharding(store, client2, "api", WithLogger(&noopLogger{}))
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	// the lock znode of the node web-1, created by another client
+	factory := curator.NewFakeClientFactory(store, client3)
+	factory.Start(curator.New(func(sess *curator.Session) {
+		sess.GetClient().Create(parentPath+lockZNodeName+"/node:web-1-", nil,
+			zk.FlagEphemeral|zk.FlagSequence,
+			func(resp zk.CreateResponse, err error) {
+				if err != nil {
+					panic(err)
+				}
+			},
+		)
+	}))
+	store.Begin(client3)
+	store.CreateApply(client3)
+
+	assert.Equal(t, []string{"node:api-0000000000", "node:web-0000000001", "node:web-1-0000000002"},
+		getStoreChildNames(store, "sharding", "locks"))
+
+	var errs []error
+	s1.Shutdown(10*time.Second, func(err error) {
+		errs = append(errs, err)
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []error{nil}, errs)
+	assert.Equal(t, []string{"node:api-0000000000", "node:web-1-0000000002"},
+		getStoreChildNames(store, "sharding", "locks"))
+	assert.Equal(t, []string{"api"}, getStoreChildNames(store, "sharding", "nodes"))
+}
+
+func TestIsLockNodeOf(t *testing.T) {
+	assert.Equal(t, true, isLockNodeOf("node:web-0000000012", "web"))
+	assert.Equal(t, true, isLockNodeOf("node:web-1-0000000012", "web-1"))
+	assert.Equal(t, false, isLockNodeOf("node:web-1-0000000012", "web"))
+	assert.Equal(t, false, isLockNodeOf("node:web-000000001", "web"))
+	assert.Equal(t, false, isLockNodeOf("node:web-000000001a", "web"))
+	assert.Equal(t, false, isLockNodeOf("node:web-0000000012", "web-1"))
+}
+
+func TestSharding_Shutdown__Coordinator(t *testing.T) {
+	store := initStore()
+
+	c := NewCoordinator(parentPath, "coord01", numShards, WithLogger(&noopLogger{}))
+	curator.NewFakeClientFactory(store, client1).Start(c.GetCurator())
+	startSharding(store, client2, "node01", WithLogger(&noopLogger{}))
+
+	c2 := NewCoordinator(parentPath, "coord02", numShards, WithLogger(&noopLogger{}))
+	curator.NewFakeClientFactory(store, client3).Start(c2.GetCurator())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2, client3}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+	assert.Equal(t, 3, len(getStoreChildNames(store, "sharding", "locks")))
+
+	var shutdownErr error
+	c.Shutdown(time.Second, func(err error) {
+		shutdownErr = err
+	})
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, nil, shutdownErr)
+	assert.Equal(t, 2, len(getStoreChildNames(store, "sharding", "locks")))
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Shutdown__No_Session(t *testing.T) {
+	s := New(parentPath, "node01", numShards, "addr")
+
+	var err error
+	s.Shutdown(time.Second, func(e error) {
+		err = e
+	})
+	assert.Equal(t, ErrNoSession, err)
+}
@@ -0,0 +1,161 @@
+package sharding
+
+import (
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharding_Shard_Status__Standalone_Observer(t *testing.T) {
+	store := initStore()
+	var events []ChangeEvent
+
+	startSharding(store, client1, "node01", WithShardStatus())
+
+	factory := curator.NewFakeClientFactory(store, observer1)
+	observer := NewObserver(parentPath, numShards, func(event ChangeEvent) {
+		events = append(events, event)
+	}, WithObserverShardStatus())
+	factory.Start(observer.GetCurator())
+
+	store.Begin(client1)
+	store.Begin(observer1)
+
+	initContainerNodesWithExtras(store, client1, 1)
+
+	store.ChildrenApply(client1) // lock children
+	store.CreateApply(client1)   // create status/node01
+	store.ChildrenApply(client1) // owner list assigns
+	store.CreateApply(client1)   // lock create
+	store.ChildrenApply(client1) // lock granted
+
+	store.ChildrenApply(client1) // list assigns
+	store.ChildrenApply(client1) // list nodes
+	nodeDataFetched(store, client1, 1)
+	store.CreateApply(client1) // create assigns/node01
+
+	store.CreateApply(observer1) // create lock
+	store.CreateApply(observer1) // create nodes
+	store.CreateApply(observer1) // create assigns
+	store.CreateApply(observer1) // create status
+
+	store.ChildrenApply(observer1) // list nodes
+	store.ChildrenApply(observer1) // list assigns
+	store.ChildrenApply(observer1) // list status
+
+	store.GetApply(observer1) // get nodes/node01
+
+	assert.Equal(t, 0, len(events))
+	store.GetApply(observer1) // get assigns/node01
+	assert.Equal(t, 1, len(events))
+
+	store.GetApply(observer1) // get status/node01
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, []Node{
+		{
+			ID:            "node01",
+			Address:       "node01-addr:4001",
+			Weight:        1,
+			Shards:        []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
+			MZxid:         110,
+			PendingShards: []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
+		},
+	}, events[0].New)
+
+	store.ChildrenApply(client1) // owner list assigns
+	store.GetApply(client1)      // owner get assigns/node01
+	store.SetApply(client1)      // set status/node01
+	assert.Equal(t, []string{}, store.PendingCalls(client1))
+
+	store.GetApply(observer1) // get status/node01
+	assert.Equal(t, []string{}, store.PendingCalls(observer1))
+
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, []Node{
+		{
+			ID:           "node01",
+			Address:      "node01-addr:4001",
+			Weight:       1,
+			Shards:       []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
+			MZxid:        110,
+			ActiveShards: []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
+		},
+	}, events[1].New)
+}
+
+func TestSharding_Shard_Status__With_Observer_Using_Tester__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		var lastEvent ChangeEvent
+
+		startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithShardStatus())
+		startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithShardStatus())
+		startSharding(store, client3, "node03",
+			WithLogger(&noopLogger{}),
+			WithShardStatus(),
+			WithShardingObserver(func(event ChangeEvent) {
+				lastEvent = event
+			}),
+		)
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3},
+			int64(k),
+		)
+
+		tester.Begin()
+		runTesterWithExactSteps(tester, 5, 2000)
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+		checkObserverShards(t, store, lastEvent)
+
+		for _, node := range lastEvent.New {
+			assert.Equal(t, node.Shards, node.ActiveShards)
+			assert.Equal(t, 0, len(node.PendingShards))
+		}
+	}
+}
+
+func TestSharding_Shard_Status__With_Shard_Activation(t *testing.T) {
+	store := initStore()
+	timer := newFakeTimer()
+
+	var lastEvent ChangeEvent
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		WithShardActivation(),
+		WithShardingObserver(func(event ChangeEvent) {
+			lastEvent = event
+		}),
+	)
+	timer.install(s1)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, lastEvent.New[0].Shards)
+	assert.Equal(t, []ShardID(nil), lastEvent.New[0].ActiveShards)
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, lastEvent.New[0].PendingShards)
+
+	s1.MarkShardActive(1)
+	s1.MarkShardActive(3)
+	s1.MarkShardActive(3)
+	s1.MarkShardActive(8) // NOT held
+	assert.Equal(t, 2, timer.pendingLen())
+
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []ShardID{1, 3}, lastEvent.New[0].ActiveShards)
+	assert.Equal(t, []ShardID{0, 2, 4, 5, 6, 7}, lastEvent.New[0].PendingShards)
+
+	// the activation is reset after the shards are released
+	store.SessionExpired(client1)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, lastEvent.New[0].PendingShards)
+}
@@ -87,15 +87,19 @@ func TestSharding_Begin(t *testing.T) {
 	store.CreateApply(client1) // create lock
 	store.CreateApply(client1) // create nodes
 	store.CreateApply(client1) // create assigns
+	store.CreateApply(client1) // create config
+	store.GetApply(client1)    // get config
 	store.CreateApply(client1) // create nodes/node01
 
 	// Lock Start
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 	store.CreateApply(client1)
 	store.ChildrenApply(client1)
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 
 	store.CreateApply(client1)
 
@@ -116,23 +120,52 @@ func TestSharding_Begin(t *testing.T) {
 }
 
 func initContainerNodes(store *curator.FakeZookeeper, client curator.FakeClientID) {
+	initContainerNodesWithExtras(store, client, 0)
+}
+
+// initContainerNodesWithExtras is initContainerNodes for a node with optional container znodes, e.g. status
+func initContainerNodesWithExtras(store *curator.FakeZookeeper, client curator.FakeClientID, numExtra int) {
 	store.CreateApply(client) // create lock
 	store.CreateApply(client) // create nodes
 	store.CreateApply(client) // create assigns
+	store.CreateApply(client) // create config
+	for i := 0; i < numExtra; i++ {
+		store.CreateApply(client) // create extra container
+	}
+	store.GetApply(client)    // get config
 	store.CreateApply(client) // create nodes/node01
 }
 
 func lockGranted(store *curator.FakeZookeeper, client curator.FakeClientID) {
-	store.ChildrenApply(client)
-	store.CreateApply(client)
-	store.ChildrenApply(client)
+	store.ChildrenApply(client) // lock children
+	store.ChildrenApply(client) // owner list assigns
+	store.CreateApply(client)   // lock create
+	store.ChildrenApply(client) // lock granted
+}
+
+// nodeDataFetched applies the requests of the leader getting the data of its active nodes
+func nodeDataFetched(store *curator.FakeZookeeper, client curator.FakeClientID, numNodes int) {
+	for i := 0; i < numNodes; i++ {
+		store.GetApply(client)
+	}
+}
+
+// assignsListed applies the requests of the owner re-listing the assign znodes and getting its own assign
+func assignsListed(store *curator.FakeZookeeper, clients ...curator.FakeClientID) {
+	for _, client := range clients {
+		store.ChildrenApply(client)
+	}
+	for _, client := range clients {
+		store.GetApply(client)
+	}
 }
 
 func lockBlocked(store *curator.FakeZookeeper, client curator.FakeClientID) {
-	store.ChildrenApply(client)
-	store.CreateApply(client)
-	store.ChildrenApply(client)
-	store.GetApply(client)
+	store.ChildrenApply(client) // lock children
+	store.ChildrenApply(client) // owner list assigns
+	store.CreateApply(client)   // lock create
+	store.ChildrenApply(client) // lock children
+	store.GetApply(client)      // lock blocked
 }
 
 func TestSharding_Two_Nodes(t *testing.T) {
@@ -152,6 +185,7 @@ func TestSharding_Two_Nodes(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 2)
 
 	store.CreateApply(client1)
 	store.CreateApply(client1)
@@ -193,6 +227,7 @@ func TestSharding_Three_Nodes(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 3)
 
 	store.CreateApply(client1)
 	store.CreateApply(client1)
@@ -235,11 +270,14 @@ func TestSharding_Three_Nodes_One_Node_Deleted(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 3)
 
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 
+	assignsListed(store, client1, client2, client3)
+
 	// Node 3 Session Expired
 	store.SessionExpired(client3)
 	store.ChildrenApply(client1)
@@ -285,11 +323,14 @@ func TestSharding_Three_Nodes__Lease_Expired(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 3)
 
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 
+	assignsListed(store, client1, client2, client3)
+
 	// Leader Expired
 	store.SessionExpired(client1)
 	assert.Equal(t, []string{}, store.PendingCalls(client1))
@@ -303,6 +344,7 @@ func TestSharding_Three_Nodes__Lease_Expired(t *testing.T) {
 	store.GetApply(client2)
 	store.GetApply(client2)
 	store.GetApply(client2)
+	nodeDataFetched(store, client2, 2)
 
 	store.PrintData()
 	store.PrintPendingCalls()
@@ -355,6 +397,8 @@ func TestSharding_Create_Ephemeral_Node_Connection_Error(t *testing.T) {
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
+	store.CreateApply(client1)
+	store.GetApply(client1)
 
 	store.ConnError(client1)
 	store.Retry(client1)
@@ -371,11 +415,7 @@ func TestSharding_List_Assign_Nodes_Error(t *testing.T) {
 
 	startSharding(store, client1, "node01")
 	store.Begin(client1)
-
-	store.CreateApply(client1)
-	store.CreateApply(client1)
-	store.CreateApply(client1)
-	store.CreateApply(client1)
+	initContainerNodes(store, client1)
 
 	lockGranted(store, client1)
 
@@ -384,6 +424,7 @@ func TestSharding_List_Assign_Nodes_Error(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 
 	store.CreateApply(client1)
 
@@ -404,6 +445,7 @@ func TestSharding_Get_Assign_Node_Data_Conn_Error(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.CreateApply(client1)
 
 	store.SessionExpired(client1)
@@ -422,6 +464,7 @@ func TestSharding_Get_Assign_Node_Data_Conn_Error(t *testing.T) {
 	store.Retry(client2)
 	store.ChildrenApply(client2)
 	store.GetApply(client2)
+	nodeDataFetched(store, client2, 1)
 
 	store.CreateApply(client2)
 	store.DeleteApply(client2)
@@ -453,11 +496,14 @@ func TestSharding_Get_Assign_Data_Of_Two_Nodes__Conn_Error(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 3)
 
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 
+	assignsListed(store, client1, client2, client3)
+
 	// session expired
 	store.SessionExpired(client1)
 
@@ -475,12 +521,14 @@ func TestSharding_Get_Assign_Data_Of_Two_Nodes__Conn_Error(t *testing.T) {
 	store.GetApply(client2)
 	store.GetApply(client2)
 	store.GetApply(client2)
+	nodeDataFetched(store, client2, 2)
 
 	store.SetApply(client2)
 	store.SetApply(client2)
 	store.DeleteApply(client2)
 
-	assert.Equal(t, []string{}, store.PendingCalls(client2))
+	// watches of the owner
+	assert.Equal(t, []string{"get-w", "children-w"}, store.PendingCalls(client2))
 
 	children := store.Root.Children[0].Children[2].Children
 	assert.Equal(t, 2, len(children))
@@ -503,6 +551,7 @@ func TestSharding_Create_And_Delete_With_Conn_Error(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.CreateApply(client1)
 
 	store.SessionExpired(client1)
@@ -517,6 +566,7 @@ func TestSharding_Create_And_Delete_With_Conn_Error(t *testing.T) {
 	store.ChildrenApply(client2)
 	store.ChildrenApply(client2)
 	store.GetApply(client2)
+	nodeDataFetched(store, client2, 1)
 
 	store.ConnError(client2)
 	store.Retry(client2)
@@ -553,11 +603,14 @@ func TestSharding__Set_Conn_Error(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 3)
 
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 
+	assignsListed(store, client1, client2, client3)
+
 	// session expired
 	store.SessionExpired(client1)
 
@@ -570,6 +623,7 @@ func TestSharding__Set_Conn_Error(t *testing.T) {
 	store.GetApply(client2)
 	store.GetApply(client2)
 	store.GetApply(client2)
+	nodeDataFetched(store, client2, 2)
 
 	store.ConnError(client2)
 	store.Retry(client2)
@@ -583,7 +637,8 @@ func TestSharding__Set_Conn_Error(t *testing.T) {
 	store.SetApply(client2)
 	store.DeleteApply(client2)
 
-	assert.Equal(t, []string{}, store.PendingCalls(client2))
+	// watches of the owner
+	assert.Equal(t, []string{"get-w", "children-w"}, store.PendingCalls(client2))
 
 	children := store.Root.Children[0].Children[2].Children
 	assert.Equal(t, 2, len(children))
@@ -606,8 +661,11 @@ func TestSharding__Client1_Created__Then_Client2_Added(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.CreateApply(client1)
 
+	assignsListed(store, client1)
+
 	// Client 2 Started
 	startSharding(store, client2, "node02")
 	store.Begin(client2)
@@ -616,6 +674,7 @@ func TestSharding__Client1_Created__Then_Client2_Added(t *testing.T) {
 	lockBlocked(store, client2)
 
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.SetApply(client1)
 	store.CreateApply(client1)
 
@@ -644,6 +703,7 @@ func TestSharding_WithObserver_SingleNode__Then_New_Node_Added(t *testing.T) {
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 	store.CreateApply(client1)
 
 	store.GetApply(client1)
@@ -654,15 +714,18 @@ func TestSharding_WithObserver_SingleNode__Then_New_Node_Added(t *testing.T) {
 	// create allocations
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.CreateApply(client1)
 
 	assert.Equal(t, 0, len(events))
 	// list assigns again
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 
 	assert.Equal(t, 0, len(events))
 	store.GetApply(client1)
 	assert.Equal(t, 1, len(events))
+	store.GetApply(client1) // owner get assigns/node01
 
 	assert.Equal(t, []ChangeEvent{
 		{
@@ -670,8 +733,9 @@ func TestSharding_WithObserver_SingleNode__Then_New_Node_Added(t *testing.T) {
 				{
 					ID:      "node01",
 					Address: "node01-addr:4001",
+					Weight:  1,
 					Shards:  []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
-					MZxid:   107,
+					MZxid:   108,
 				},
 			},
 		},
@@ -689,12 +753,15 @@ func TestSharding_WithObserver_SingleNode__Then_New_Node_Added(t *testing.T) {
 	store.ChildrenApply(client1) // list children for sharding
 
 	store.GetApply(client1) // get nodes/node02
+	nodeDataFetched(store, client1, 1)
 
 	store.SetApply(client1)
 	store.CreateApply(client1)
 
 	store.GetApply(client1)
+	store.GetApply(client1)      // owner get assigns/node01
 	store.ChildrenApply(client1) // list children for assigns of observer
+	store.ChildrenApply(client1) // owner list assigns
 
 	assert.Equal(t, 1, len(events))
 	store.GetApply(client1) // get assigns/node02
@@ -704,22 +771,25 @@ func TestSharding_WithObserver_SingleNode__Then_New_Node_Added(t *testing.T) {
 			{
 				ID:      "node01",
 				Address: "node01-addr:4001",
+				Weight:  1,
 				Shards:  []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
-				MZxid:   107,
+				MZxid:   108,
 			},
 		},
 		New: []Node{
 			{
 				ID:      "node01",
 				Address: "node01-addr:4001",
+				Weight:  1,
 				Shards:  []ShardID{0, 1, 2, 3},
-				MZxid:   109,
+				MZxid:   110,
 			},
 			{
 				ID:      "node02",
 				Address: "node02-addr:4001",
+				Weight:  1,
 				Shards:  []ShardID{4, 5, 6, 7},
-				MZxid:   110,
+				MZxid:   111,
 			},
 		},
 	}, events[1])
@@ -744,12 +814,15 @@ func TestSharding_WithObserver_SingleNode__Then_New_Node_Added(t *testing.T) {
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
-			MZxid:   112,
+			MZxid:   113,
 		},
 	}, events[2].New)
 
+	store.GetApply(client1)      // owner get assigns/node01
 	store.ChildrenApply(client1) // children assigns for observer
+	store.ChildrenApply(client1) // owner list assigns
 
 	assert.Equal(t, 0, len(store.PendingCalls(client1)))
 	assert.Equal(t, 3, len(events))
@@ -771,19 +844,23 @@ func TestSharding_WithObserver_OneNode_Then_2_Nodes_Added(t *testing.T) {
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 
 	store.CreateApply(client1)
 	store.GetApply(client1)
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.CreateApply(client1)
 
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 
 	assert.Equal(t, 0, len(events))
 	store.GetApply(client1)
 	assert.Equal(t, 1, len(events))
+	store.GetApply(client1) // owner get assigns/node01
 
 	// =========================
 	// 2 Other Nodes Started
@@ -802,14 +879,17 @@ func TestSharding_WithObserver_OneNode_Then_2_Nodes_Added(t *testing.T) {
 
 	store.GetApply(client1)
 	store.GetApply(client1)
+	nodeDataFetched(store, client1, 2)
 
 	store.SetApply(client1)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 
 	store.GetApply(client1)
+	store.GetApply(client1) // owner get assigns/node01
 	store.ChildrenApply(client1)
-	store.GetApply(client1) // get assigns/node02 for observer
+	store.ChildrenApply(client1) // owner list assigns
+	store.GetApply(client1)      // get assigns/node02 for observer
 
 	assert.Equal(t, 1, len(events))
 	store.GetApply(client1) // get assigns/node03 for observer
@@ -819,28 +899,32 @@ func TestSharding_WithObserver_OneNode_Then_2_Nodes_Added(t *testing.T) {
 			{
 				ID:      "node01",
 				Address: "node01-addr:4001",
+				Weight:  1,
 				Shards:  []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
-				MZxid:   107,
+				MZxid:   108,
 			},
 		},
 		New: []Node{
 			{
 				ID:      "node01",
 				Address: "node01-addr:4001",
+				Weight:  1,
 				Shards:  []ShardID{0, 1, 2},
-				MZxid:   110,
+				MZxid:   111,
 			},
 			{
 				ID:      "node02",
 				Address: "node02-addr:4001",
+				Weight:  1,
 				Shards:  []ShardID{3, 4, 5},
-				MZxid:   111,
+				MZxid:   112,
 			},
 			{
 				ID:      "node03",
 				Address: "node03-addr:4001",
+				Weight:  1,
 				Shards:  []ShardID{6, 7},
-				MZxid:   112,
+				MZxid:   113,
 			},
 		},
 	}, events[1])
@@ -866,12 +950,15 @@ func TestSharding_WithObserver_OneNode_Then_2_Nodes_Added(t *testing.T) {
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
-			MZxid:   115,
+			MZxid:   116,
 		},
 	}, events[2].New)
 
+	store.GetApply(client1) // owner get assigns/node01
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 	assert.Equal(t, 3, len(events))
 
 	assert.Equal(t, 0, len(store.PendingCalls(client1)))
@@ -889,7 +976,9 @@ func TestSharding_Observer_On_Non_Leader_Node(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.CreateApply(client1)
+	assignsListed(store, client1)
 
 	assert.Equal(t, 0, len(store.PendingCalls(client1)))
 
@@ -906,6 +995,7 @@ func TestSharding_Observer_On_Non_Leader_Node(t *testing.T) {
 	store.ChildrenApply(client2)
 	store.ChildrenApply(client2)
 	store.ChildrenApply(client2)
+	store.ChildrenApply(client2) // owner list assigns
 	store.CreateApply(client2)
 
 	store.GetApply(client2)
@@ -917,8 +1007,9 @@ func TestSharding_Observer_On_Non_Leader_Node(t *testing.T) {
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
-			MZxid:   107,
+			MZxid:   108,
 		},
 	}, events[0].New)
 
@@ -927,27 +1018,35 @@ func TestSharding_Observer_On_Non_Leader_Node(t *testing.T) {
 
 	// Leader do rebalance
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.SetApply(client1)
 	store.CreateApply(client1)
 
 	store.GetApply(client2)
 	store.ChildrenApply(client2)
+	store.ChildrenApply(client2) // owner list assigns
 
 	assert.Equal(t, 1, len(events))
 	store.GetApply(client2)
 	assert.Equal(t, 2, len(events))
+	store.GetApply(client2) // owner get assigns/node02
+
+	store.GetApply(client1)      // owner get assigns/node01
+	store.ChildrenApply(client1) // owner list assigns
 	assert.Equal(t, []Node{
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2, 3},
-			MZxid:   110,
+			MZxid:   111,
 		},
 		{
 			ID:      "node02",
 			Address: "node02-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{4, 5, 6, 7},
-			MZxid:   111,
+			MZxid:   112,
 		},
 	}, events[1].New)
 
@@ -966,6 +1065,7 @@ func TestSharding_Observer_On_Non_Leader_Node(t *testing.T) {
 
 	store.GetApply(client2)
 	store.GetApply(client2)
+	nodeDataFetched(store, client2, 1)
 
 	store.SetApply(client2)
 	store.DeleteApply(client2)
@@ -977,12 +1077,15 @@ func TestSharding_Observer_On_Non_Leader_Node(t *testing.T) {
 		{
 			ID:      "node02",
 			Address: "node02-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{4, 5, 6, 7, 0, 1, 2, 3},
-			MZxid:   113,
+			MZxid:   114,
 		},
 	}, events[2].New)
 
+	store.GetApply(client2) // owner get assigns/node02
 	store.ChildrenApply(client2)
+	store.ChildrenApply(client2) // owner list assigns
 	assert.Equal(t, 3, len(events))
 
 	assert.Equal(t, 0, len(store.PendingCalls(client2)))
@@ -1006,6 +1109,7 @@ func TestSharding_Observer_List_Conn_Error(t *testing.T) {
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 
 	store.CreateApply(client1)
 	store.GetApply(client1)
@@ -1013,9 +1117,11 @@ func TestSharding_Observer_List_Conn_Error(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.CreateApply(client1)
 
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 
 	assert.Equal(t, 0, len(events))
 	store.GetApply(client1)
@@ -1024,8 +1130,9 @@ func TestSharding_Observer_List_Conn_Error(t *testing.T) {
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
-			MZxid:   107,
+			MZxid:   108,
 		},
 	}, events[0].New)
 }
@@ -1044,6 +1151,7 @@ func TestSharding_Observer_Get_Node_Data_Error(t *testing.T) {
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 
 	store.ConnError(client1)
 	store.Retry(client1)
@@ -1055,8 +1163,10 @@ func TestSharding_Observer_Get_Node_Data_Error(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 	store.CreateApply(client1)
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 
 	// Get Assigns Node01 Error
 	store.ConnError(client1)
@@ -1069,8 +1179,9 @@ func TestSharding_Observer_Get_Node_Data_Error(t *testing.T) {
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
-			MZxid:   107,
+			MZxid:   108,
 		},
 	}, events[0].New)
 }
@@ -1099,6 +1210,7 @@ func TestSharding_Observer_3_Nodes__One_Watch_Partial_Results(t *testing.T) {
 	store.ChildrenApply(client3)
 	store.ChildrenApply(client3)
 	store.ChildrenApply(client3)
+	store.ChildrenApply(client3) // owner list assigns
 	store.CreateApply(client3)
 
 	store.GetApply(client3)
@@ -1109,35 +1221,43 @@ func TestSharding_Observer_3_Nodes__One_Watch_Partial_Results(t *testing.T) {
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 3)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 
+	assignsListed(store, client1, client2)
+
 	store.ChildrenApply(client3)
+	store.ChildrenApply(client3) // owner list assigns
 	store.GetApply(client3)
 	store.GetApply(client3)
 
 	assert.Equal(t, 0, len(events))
 	store.GetApply(client3)
 	assert.Equal(t, 1, len(events))
+	store.GetApply(client3) // owner get assigns/node03
 	assert.Equal(t, []Node{
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2},
-			MZxid:   111,
+			MZxid:   112,
 		},
 		{
 			ID:      "node02",
 			Address: "node02-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{3, 4, 5},
-			MZxid:   112,
+			MZxid:   113,
 		},
 		{
 			ID:      "node03",
 			Address: "node03-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{6, 7},
-			MZxid:   113,
+			MZxid:   114,
 		},
 	}, events[0].New)
 
@@ -1164,21 +1284,28 @@ func TestSharding_Observer_3_Nodes__One_Watch_Partial_Results(t *testing.T) {
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2, 3},
-			MZxid:   115,
+			MZxid:   116,
 		},
 		{
 			ID:      "node03",
 			Address: "node03-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{6, 7, 4, 5},
-			MZxid:   116,
+			MZxid:   117,
 		},
 	}, events[1].New)
 
 	store.DeleteApply(client1)
+	store.GetApply(client3) // owner get assigns/node03
 	store.ChildrenApply(client3)
+	store.ChildrenApply(client3) // owner list assigns
 	assert.Equal(t, 2, len(events))
 
+	store.GetApply(client1)      // owner get assigns/node01
+	store.ChildrenApply(client1) // owner list assigns
+
 	assert.Equal(t, []string{"node01", "node03"}, getKeys(sharding3.obs.nodes))
 }
 
@@ -1201,6 +1328,7 @@ func TestSharding_Observer_2_Nodes_One_Node_Expired_When_Getting_Data(t *testing
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	store.ChildrenApply(client1) // owner list assigns
 
 	store.CreateApply(client1)
 	store.GetApply(client1)
@@ -1214,10 +1342,12 @@ func TestSharding_Observer_2_Nodes_One_Node_Expired_When_Getting_Data(t *testing
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 1)
 
 	store.CreateApply(client1)
 
 	store.ChildrenApply(client1) // assigns children
+	store.ChildrenApply(client1) // owner list assigns
 	assert.Equal(t, 0, len(events))
 	store.GetApply(client1)
 	assert.Equal(t, 1, len(events))
@@ -1225,8 +1355,9 @@ func TestSharding_Observer_2_Nodes_One_Node_Expired_When_Getting_Data(t *testing
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2, 3, 4, 5, 6, 7},
-			MZxid:   109,
+			MZxid:   110,
 		},
 	}, events[0].New)
 }
@@ -1256,6 +1387,7 @@ func TestSharding_Observer_3_Nodes_One_Node_Expired_When_Getting_Data_With_Watch
 	store.ChildrenApply(client3)
 	store.ChildrenApply(client3)
 	store.ChildrenApply(client3)
+	store.ChildrenApply(client3) // owner list assigns
 	store.CreateApply(client3)
 	store.GetApply(client3)
 	store.GetApply(client3)
@@ -1265,11 +1397,15 @@ func TestSharding_Observer_3_Nodes_One_Node_Expired_When_Getting_Data_With_Watch
 
 	store.ChildrenApply(client1)
 	store.ChildrenApply(client1)
+	nodeDataFetched(store, client1, 3)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 	store.CreateApply(client1)
 
+	assignsListed(store, client1, client2)
+
 	store.ChildrenApply(client3)
+	store.ChildrenApply(client3) // owner list assigns
 	store.GetApply(client3)
 
 	// Node 2 Expired
@@ -1283,6 +1419,7 @@ func TestSharding_Observer_3_Nodes_One_Node_Expired_When_Getting_Data_With_Watch
 	// Client 3 Run Again
 	store.GetApply(client3)
 	store.GetApply(client3)
+	store.GetApply(client3) // owner get assigns/node03
 
 	store.ChildrenApply(client3)
 	store.ChildrenApply(client3)
@@ -1294,18 +1431,21 @@ func TestSharding_Observer_3_Nodes_One_Node_Expired_When_Getting_Data_With_Watch
 		{
 			ID:      "node01",
 			Address: "node01-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{0, 1, 2, 3},
-			MZxid:   115,
+			MZxid:   116,
 		},
 		{
 			ID:      "node03",
 			Address: "node03-addr:4001",
+			Weight:  1,
 			Shards:  []ShardID{6, 7, 4, 5},
-			MZxid:   116,
+			MZxid:   117,
 		},
 	}, events[0].New)
 
 	store.ChildrenApply(client3)
+	store.ChildrenApply(client3) // owner list assigns
 	store.GetApply(client3)
 
 	assert.Equal(t, 1, len(events))
@@ -1335,7 +1475,7 @@ func TestSharding_Without_Observers__Using_Tester(t *testing.T) {
 		0,
 		1000_000,
 	)
-	assert.Equal(t, 36, steps)
+	assert.Equal(t, 56, steps)
 
 	store.PrintData()
 	store.PrintPendingCalls()
@@ -1688,6 +1828,19 @@ func checkFinalShards(t *testing.T, store *curator.FakeZookeeper) {
 	assert.Equal(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7}, shards)
 }
 
+func getStoreAssigns(store *curator.FakeZookeeper) map[string][]ShardID {
+	result := map[string][]ShardID{}
+	for _, child := range store.Root.Children[0].Children[2].Children {
+		var d assignData
+		err := json.Unmarshal(child.Data, &d)
+		if err != nil {
+			panic(err)
+		}
+		result[child.Name] = d.Shards
+	}
+	return result
+}
+
 func runTesterWithExactSteps(
 	tester *curator.FakeZookeeperTester,
 	prob float64, exactSteps int,
@@ -0,0 +1,139 @@
+package sharding
+
+import (
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharding_With_Node_Topology__Spread_Replicas_To_Zones__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		var lastEvent ChangeEvent
+
+		zoneOptions := func(zone string) []Option {
+			return []Option{
+				WithLogger(&noopLogger{}),
+				WithReplicationFactor(2),
+				WithNodeTopology(Topology{Zone: zone}),
+			}
+		}
+
+		startSharding(store, client1, "node01", zoneOptions("zone-a")...)
+		startSharding(store, client2, "node02", zoneOptions("zone-a")...)
+		startSharding(store, client3, "node03", zoneOptions("zone-b")...)
+		startSharding(store, client4, "node04", append(zoneOptions("zone-b"),
+			WithShardingObserver(func(event ChangeEvent) {
+				lastEvent = event
+			}),
+		)...)
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3, client4},
+			int64(k),
+		)
+
+		tester.Begin()
+		runTesterWithExactSteps(tester, 5, 2000)
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+		checkObserverShards(t, store, lastEvent)
+
+		zoneShards := map[string]int{}
+		holderZones := map[ShardID][]string{}
+		for _, node := range lastEvent.New {
+			zone := node.Topology.Zone
+			zoneShards[zone] += len(node.Shards)
+			for _, id := range node.Shards {
+				holderZones[id] = append(holderZones[id], zone)
+			}
+			for _, id := range node.Replicas {
+				holderZones[id] = append(holderZones[id], zone)
+			}
+		}
+
+		assert.Equal(t, map[string]int{"zone-a": 4, "zone-b": 4}, zoneShards)
+		for id := ShardID(0); id < numShards; id++ {
+			zones := holderZones[id]
+			assert.Equal(t, 2, len(zones))
+			assert.NotEqual(t, zones[0], zones[1])
+		}
+	}
+}
+
+func TestSharding_With_Node_Topology__Node_Data(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01",
+		WithNodeTopology(Topology{Zone: "zone-a", Rack: "rack-1", Host: "host-1"}),
+	)
+
+	store.Begin(client1)
+	initContainerNodes(store, client1)
+
+	nodes := store.Root.Children[0].Children[1]
+	assert.Equal(t, "nodes", nodes.Name)
+	assert.Equal(t,
+		`{"address":"node01-addr:4001","topology":{"zone":"zone-a","rack":"rack-1","host":"host-1"}}`,
+		string(nodes.Children[0].Data),
+	)
+}
+
+func TestSharding_With_Node_Topology__Leader_Without_Topology(t *testing.T) {
+	store := initStore()
+
+	var lastEvent ChangeEvent
+
+	startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		WithReplicationFactor(2),
+		WithShardingObserver(func(event ChangeEvent) {
+			lastEvent = event
+		}),
+	)
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	zoneOptions := func(zone string) []Option {
+		return []Option{
+			WithLogger(&noopLogger{}),
+			WithReplicationFactor(2),
+			WithNodeTopology(Topology{Zone: zone}),
+		}
+	}
+
+	startSharding(store, client2, "node02", zoneOptions("zone-a")...)
+	startSharding(store, client3, "node03", zoneOptions("zone-a")...)
+	startSharding(store, client4, "node04", zoneOptions("zone-b")...)
+	tester = curator.NewFakeZookeeperTester(
+		store, []curator.FakeClientID{client1, client2, client3, client4}, 123,
+	)
+	store.Begin(client2)
+	store.Begin(client3)
+	store.Begin(client4)
+	runTesterWithoutErrors(tester)
+
+	checkFinalShards(t, store)
+	checkObserverShards(t, store, lastEvent)
+
+	// the leader node01 reads the topologies of the other nodes
+	holderZones := map[ShardID][]string{}
+	for _, node := range lastEvent.New {
+		zone := node.Topology.Zone
+		for _, id := range node.Shards {
+			holderZones[id] = append(holderZones[id], zone)
+		}
+		for _, id := range node.Replicas {
+			holderZones[id] = append(holderZones[id], zone)
+		}
+	}
+	for id := ShardID(0); id < numShards; id++ {
+		zones := holderZones[id]
+		assert.Equal(t, 2, len(zones))
+		assert.NotEqual(t, zones[0], zones[1])
+	}
+}
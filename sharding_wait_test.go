@@ -0,0 +1,208 @@
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func waitInBackground(fn func() error) <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- fn()
+	}()
+	return ch
+}
+
+// assertNotReturned checks that the wait has not returned yet, without waiting
+func assertNotReturned(t *testing.T, ch <-chan error) {
+	t.Helper()
+	select {
+	case err := <-ch:
+		t.Fatalf("should not return, but returned: %v", err)
+	default:
+	}
+}
+
+func cancelledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// waitTimerDuration waits until the latest timer added by WaitStable has the duration
+func waitTimerDuration(t *testing.T, timer *fakeTimer, d time.Duration) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		return timer.lastDuration() == d
+	}, 5*time.Second, time.Millisecond)
+}
+
+func assertReturned(t *testing.T, ch <-chan error, expected error) {
+	t.Helper()
+	select {
+	case err := <-ch:
+		assert.Equal(t, expected, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("should return")
+	}
+}
+
+func TestObserver_Wait_Ready(t *testing.T) {
+	store := initStore()
+
+	obs := NewObserver(parentPath, numShards, func(event ChangeEvent) {})
+	curator.NewFakeClientFactory(store, observer1).Start(obs.GetCurator())
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{observer1}, 123)
+	tester.Begin()
+
+	ready := waitInBackground(func() error {
+		return obs.WaitReady(context.Background())
+	})
+
+	// an empty cluster is NOT ready
+	runTesterWithoutErrors(tester)
+	assert.Equal(t, context.Canceled, obs.WaitReady(cancelledContext()))
+	assertNotReturned(t, ready)
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, observer1}, 123)
+	store.Begin(client1)
+	runTesterWithoutErrors(tester)
+
+	assertReturned(t, ready, nil)
+
+	// already ready
+	assert.Equal(t, nil, obs.WaitReady(context.Background()))
+}
+
+func TestObserver_Wait_Ready__Context_Cancelled(t *testing.T) {
+	obs := NewObserver(parentPath, numShards, func(event ChangeEvent) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := waitInBackground(func() error {
+		return obs.WaitReady(ctx)
+	})
+	assertNotReturned(t, ready)
+
+	cancel()
+	assertReturned(t, ready, context.Canceled)
+}
+
+func TestSharding_Wait_Stable(t *testing.T) {
+	store := initStore()
+
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		WithShardingObserver(func(event ChangeEvent) {}),
+	)
+	timer := newFakeTimer()
+	timer.install(s1)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+
+	stable := waitInBackground(func() error {
+		return s1.WaitStable(context.Background(), 100*time.Millisecond)
+	})
+
+	runTesterWithoutErrors(tester)
+	assert.Equal(t, nil, s1.WaitReady(context.Background()))
+
+	// the duration starts after all shards are assigned
+	waitTimerDuration(t, timer, 100*time.Millisecond)
+	assertNotReturned(t, stable)
+
+	timer.advance(60 * time.Millisecond)
+	timer.fireAll()
+	waitTimerDuration(t, timer, 40*time.Millisecond)
+	assertNotReturned(t, stable)
+
+	// changed before stable, the duration starts again
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}))
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	waitTimerDuration(t, timer, 100*time.Millisecond)
+	assertNotReturned(t, stable)
+
+	timer.advance(100 * time.Millisecond)
+	timer.fireAll()
+	assertReturned(t, stable, nil)
+
+	// already stable
+	assert.Equal(t, nil, s1.WaitStable(context.Background(), 100*time.Millisecond))
+
+	// cancelled before stable
+	ctx, cancel := context.WithCancel(context.Background())
+	stable = waitInBackground(func() error {
+		return s1.WaitStable(ctx, time.Hour)
+	})
+	waitTimerDuration(t, timer, time.Hour-100*time.Millisecond)
+	assertNotReturned(t, stable)
+
+	cancel()
+	assertReturned(t, stable, context.Canceled)
+}
+
+func TestSharding_Wait_Stable__Shards_Not_Granted(t *testing.T) {
+	store := initStore()
+
+	h := newTestShardHandler()
+	s1 := startSharding(store, client1, "node01",
+		WithLogger(&noopLogger{}),
+		WithTwoPhaseHandoff(),
+		WithShardHandler(h),
+		WithShardingObserver(func(event ChangeEvent) {}),
+	)
+	timer := newFakeTimer()
+	timer.install(s1)
+
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	h.waitRunning(t, []ShardID{0, 1, 2, 3, 4, 5, 6, 7})
+	assert.Equal(t, nil, s1.WaitStable(context.Background(), 0))
+
+	// node02 joined, the revoked shards are NOT granted until the workers of node01 stopped
+	h.gate = make(chan struct{})
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithTwoPhaseHandoff())
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+	}, getStoreAssigns(store))
+
+	assert.Equal(t, context.Canceled, s1.WaitStable(cancelledContext(), 0))
+	stable := waitInBackground(func() error {
+		return s1.WaitStable(context.Background(), 0)
+	})
+
+	close(h.gate)
+	assert.Eventually(t, func() bool {
+		return timer.pendingLen() == 4
+	}, 5*time.Second, time.Millisecond)
+
+	timer.fireAll()
+	runTesterWithoutErrors(tester)
+
+	assertReturned(t, stable, nil)
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1, 2, 3},
+		"node02": {4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_Wait_Ready__Without_Observer(t *testing.T) {
+	s := New(parentPath, "node01", numShards, "addr")
+	assert.Equal(t, ErrNoObserver, s.WaitReady(context.Background()))
+	assert.Equal(t, ErrNoObserver, s.WaitStable(context.Background(), time.Second))
+}
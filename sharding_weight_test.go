@@ -0,0 +1,184 @@
+package sharding
+
+import (
+	"testing"
+
+	"github.com/QuangTung97/zk/curator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharding_Two_Nodes_With_Weights(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithNodeWeight(1))
+	startSharding(store, client2, "node02", WithNodeWeight(3))
+
+	store.Begin(client1)
+	store.Begin(client2)
+
+	initContainerNodes(store, client1)
+	initContainerNodes(store, client2)
+
+	lockGranted(store, client1)
+	lockBlocked(store, client2)
+
+	store.ChildrenApply(client1) // list assigns
+	store.ChildrenApply(client1) // list nodes
+
+	assert.Equal(t, []string{"get-w", "get-w"}, store.PendingCalls(client1))
+	store.GetApply(client1)
+	store.GetApply(client1)
+
+	store.CreateApply(client1)
+	store.CreateApply(client1)
+	assignsListed(store, client1)
+
+	assert.Equal(t, []string{}, store.PendingCalls(client1))
+
+	nodes := store.Root.Children[0].Children[1].Children
+	assert.Equal(t, `{"address":"node02-addr:4001","weight":3}`, string(nodes[1].Data))
+
+	children := store.Root.Children[0].Children[2].Children
+	assert.Equal(t, 2, len(children))
+
+	assert.Equal(t, "node01", children[0].Name)
+	assert.Equal(t, `{"shards":[0,1]}`, string(children[0].Data))
+
+	assert.Equal(t, "node02", children[1].Name)
+	assert.Equal(t, `{"shards":[2,3,4,5,6,7]}`, string(children[1].Data))
+}
+
+func TestSharding_With_Weights__Leader_Without_Weight(t *testing.T) {
+	store := initStore()
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}))
+	tester := curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1}, 123)
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithNodeWeight(3))
+	tester = curator.NewFakeZookeeperTester(store, []curator.FakeClientID{client1, client2}, 123)
+	store.Begin(client2)
+	runTesterWithoutErrors(tester)
+
+	// the leader node01 uses the default weight 1 for itself
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 1},
+		"node02": {2, 3, 4, 5, 6, 7},
+	}, getStoreAssigns(store))
+}
+
+func TestSharding_With_Weights__With_Observer(t *testing.T) {
+	store := initStore()
+
+	var lastEvent ChangeEvent
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithNodeWeight(3))
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithNodeWeight(1),
+		WithShardingObserver(func(event ChangeEvent) {
+			lastEvent = event
+		}),
+	)
+
+	tester := curator.NewFakeZookeeperTester(
+		store, []curator.FakeClientID{client1, client2},
+		123,
+	)
+
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	checkFinalShards(t, store)
+	checkObserverShards(t, store, lastEvent)
+
+	assert.Equal(t, 2, len(lastEvent.New))
+
+	assert.Equal(t, "node01", lastEvent.New[0].ID)
+	assert.Equal(t, uint32(3), lastEvent.New[0].Weight)
+	assert.Equal(t, 6, len(lastEvent.New[0].Shards))
+
+	assert.Equal(t, "node02", lastEvent.New[1].ID)
+	assert.Equal(t, uint32(1), lastEvent.New[1].Weight)
+	assert.Equal(t, 2, len(lastEvent.New[1].Shards))
+}
+
+func TestSharding_With_Weights__Using_Tester__Many_Times(t *testing.T) {
+	for k := 0; k < 200; k++ {
+		store := initStore()
+
+		startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithNodeWeight(1))
+		startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithNodeWeight(2))
+		startSharding(store, client3, "node03", WithLogger(&noopLogger{}), WithNodeWeight(5))
+
+		tester := curator.NewFakeZookeeperTester(
+			store, []curator.FakeClientID{client1, client2, client3},
+			int64(k),
+		)
+
+		tester.Begin()
+		runTesterWithExactSteps(tester, 5, 2000, curator.WithRunOperationErrorPercentage(5))
+		runTesterWithoutErrors(tester)
+
+		checkFinalShards(t, store)
+
+		assigns := getStoreAssigns(store)
+		assert.Equal(t, 3, len(assigns))
+		assert.Equal(t, 1, len(assigns["node01"]))
+		assert.Equal(t, 2, len(assigns["node02"]))
+		assert.Equal(t, 5, len(assigns["node03"]))
+	}
+}
+
+func TestSharding_With_Shard_Weights__With_Observer(t *testing.T) {
+	store := initStore()
+
+	weights := map[ShardID]uint32{
+		0: 4,
+		1: 4,
+	}
+
+	var lastEvent ChangeEvent
+	var observerEvent ChangeEvent
+
+	startSharding(store, client1, "node01", WithLogger(&noopLogger{}), WithShardWeights(weights))
+	startSharding(store, client2, "node02", WithLogger(&noopLogger{}), WithShardWeights(weights),
+		WithShardingObserver(func(event ChangeEvent) {
+			lastEvent = event
+		}),
+	)
+
+	obs := NewObserver(parentPath, numShards, func(event ChangeEvent) {
+		observerEvent = event
+	}, WithObserverShardWeights(weights))
+	curator.NewFakeClientFactory(store, client3).Start(obs.GetCurator())
+
+	tester := curator.NewFakeZookeeperTester(
+		store, []curator.FakeClientID{client1, client2, client3},
+		123,
+	)
+
+	tester.Begin()
+	runTesterWithoutErrors(tester)
+
+	checkFinalShards(t, store)
+	checkObserverShards(t, store, lastEvent)
+
+	assert.Equal(t, map[string][]ShardID{
+		"node01": {0, 2, 4, 6},
+		"node02": {1, 3, 5, 7},
+	}, getStoreAssigns(store))
+
+	assert.Equal(t, 2, len(lastEvent.New))
+	assert.Equal(t, uint64(7), lastEvent.New[0].TotalWeight)
+	assert.Equal(t, uint64(7), lastEvent.New[1].TotalWeight)
+
+	assert.Equal(t, lastEvent.New, observerEvent.New)
+}
+
+func TestSharding_With_Shard_Weights__Invalid(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid shard weight", func() {
+		New(parentPath, "node01", numShards, "addr", WithShardWeights(map[ShardID]uint32{
+			1: 0,
+		}))
+	})
+}
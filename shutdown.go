@@ -0,0 +1,421 @@
+package sharding
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/QuangTung97/zk"
+	"github.com/QuangTung97/zk/curator"
+)
+
+type shutdownState struct {
+	callback func(err error)
+
+	// timedOut is set by the timer of the timeout, protected by sessMut
+	timedOut bool
+
+	// seq is the sessionSeq of the session waiting for the assign znodes,
+	// the waiting starts again in a new session because the watches of the previous session are lost
+	seq int
+
+	// emptyAssigns are the assign znodes of the current node without shards and replicas,
+	// of the default group and the shard groups
+	emptyAssigns map[string]struct{}
+	assignEmpty  bool
+	finished     bool
+}
+
+// Shutdown gracefully removes the current node from the cluster. It marks the current node as leaving
+// in its node znode, waits until the leader has moved all shards and replicas of the current node,
+// of every shard group, to other nodes, and the workers of WithShardHandler and WithGroupShardHandler have stopped.
+// Then it releases the shards of the current node, stops the leader logic and the load reporting,
+// and deletes the node znode and the leader lock znode of the current node,
+// so the other nodes take over without waiting for the zookeeper session to expire.
+// If the shards are NOT handed off before the timeout, the current node still leaves,
+// and the callback is called with ErrShutdownTimeout.
+// If the zookeeper session changes during Shutdown, the node znode of the new session is created
+// with the leaving mark, and the waiting continues in the new session.
+// If the leaving mark can NOT be written, the callback is called with the error, and Shutdown can be called again.
+//
+// A leaving node is treated the same as a draining node, but does NOT need WithNodeDraining.
+// A coordinator only releases the leader lock.
+// The callback is called on the goroutine of the zookeeper client.
+// The Sharding object can NOT be used again, the client factory should be closed after the callback.
+func (s *Sharding) Shutdown(timeout time.Duration, callback func(err error)) {
+	sess, state, err := s.beginShutdown(callback)
+	if err != nil {
+		callback(err)
+		return
+	}
+
+	if !s.coordinator {
+		s.afterFunc(timeout, func() {
+			s.onShutdownTimeout(state)
+		})
+	}
+	runOnSession(sess, s.parentPath, func(sess *curator.Session) {
+		if s.joinedSeq != s.sessionSeq || s.getShutdown() != state {
+			// continued by onJoined after the node znode of the new session is created
+			return
+		}
+		s.continueShutdown(sess)
+	})
+}
+
+// onJoined is called after the node znode of the current node is created in a new session
+func (s *Sharding) onJoined(sess *curator.Session) {
+	s.joinedSeq = s.sessionSeq
+	if s.getShutdown() != nil {
+		s.continueShutdown(sess)
+	}
+}
+
+// continueShutdown waits for the assign znodes of the current node in the current session.
+// It is called once in every session, the timeout is handled here if it has fired while changing sessions.
+func (s *Sharding) continueShutdown(sess *curator.Session) {
+	state := s.shutdown
+	if state.finished || state.seq == s.sessionSeq {
+		return
+	}
+	state.seq = s.sessionSeq
+
+	if s.isShutdownTimedOut(state) {
+		s.finishShutdown(sess, ErrShutdownTimeout)
+		return
+	}
+	if s.coordinator {
+		s.finishShutdown(sess, nil)
+		return
+	}
+
+	state.emptyAssigns = map[string]struct{}{}
+	state.assignEmpty = false
+	s.markNodeLeaving(sess, func() {
+		for _, pathVal := range s.getShutdownAssignPaths() {
+			s.waitAssignEmpty(sess, pathVal)
+		}
+	})
+}
+
+// onShutdownTimeout is called on the timer goroutine, the shutdown is finished on the goroutine
+// of the current session, or by continueShutdown when the next session has started
+func (s *Sharding) onShutdownTimeout(state *shutdownState) {
+	s.sessMut.Lock()
+	if s.shutdown != state {
+		s.sessMut.Unlock()
+		return
+	}
+	state.timedOut = true
+	sess := s.currentSess
+	s.sessMut.Unlock()
+
+	if sess == nil {
+		return
+	}
+	runOnSession(sess, s.parentPath, func(sess *curator.Session) {
+		if s.joinedSeq != s.sessionSeq || s.getShutdown() != state {
+			return
+		}
+		s.finishShutdown(sess, ErrShutdownTimeout)
+	})
+}
+
+// markNodeLeaving sets the leaving mark in the node znode of the current node,
+// the leader moves all shards of a leaving node to other nodes, same as a draining node
+func (s *Sharding) markNodeLeaving(sess *curator.Session, onMarked func()) {
+	pathVal := s.getNodesPath() + "/" + s.nodeID
+	retry := func(sess *curator.Session) {
+		s.markNodeLeaving(sess, onMarked)
+	}
+
+	sess.GetClient().Get(pathVal, func(resp zk.GetResponse, err error) {
+		if err != nil {
+			if errors.Is(err, zk.ErrConnectionClosed) {
+				sess.AddRetry(retry)
+				return
+			}
+			s.failShutdown(err)
+			return
+		}
+
+		var data nodeData
+		if err := json.Unmarshal(resp.Data, &data); err != nil {
+			s.failShutdown(err)
+			return
+		}
+		if data.Leaving {
+			// created with the leaving mark in a new session
+			onMarked()
+			return
+		}
+
+		data.Leaving = true
+		sess.GetClient().Set(pathVal, data.marshalJSON(), resp.Stat.Version, func(_ zk.SetResponse, err error) {
+			s.onNodeLeavingSet(sess, err, retry, onMarked)
+		})
+	})
+}
+
+func (s *Sharding) onNodeLeavingSet(
+	sess *curator.Session, err error, retry func(sess *curator.Session), onMarked func(),
+) {
+	if err == nil {
+		onMarked()
+		return
+	}
+	if errors.Is(err, zk.ErrConnectionClosed) {
+		sess.AddRetry(retry)
+		return
+	}
+	if errors.Is(err, zk.ErrBadVersion) {
+		// the node znode is changed by another request, read it again
+		retry(sess)
+		return
+	}
+	s.failShutdown(err)
+}
+
+// failShutdown is called when the leaving mark can NOT be written, so Shutdown can be called again
+func (s *Sharding) failShutdown(err error) {
+	state := s.shutdown
+	state.finished = true
+
+	s.sessMut.Lock()
+	s.shutdownStarted = false
+	s.shutdown = nil
+	s.sessMut.Unlock()
+
+	state.callback(err)
+}
+
+// getShutdownAssignPaths returns the assign znodes of the current node, of the default group and the shard groups
+func (s *Sharding) getShutdownAssignPaths() []string {
+	paths := []string{s.getNodeAssignPath(s.nodeID)}
+	for _, g := range s.groups {
+		paths = append(paths, g.getNodeAssignPath(s.nodeID))
+	}
+	return paths
+}
+
+func (s *Sharding) beginShutdown(callback func(err error)) (*curator.Session, *shutdownState, error) {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+
+	if s.currentSess == nil {
+		return nil, nil, ErrNoSession
+	}
+	if s.shutdownStarted {
+		return nil, nil, ErrShutdown
+	}
+	s.shutdownStarted = true
+	s.shutdown = &shutdownState{callback: callback}
+	return s.currentSess, s.shutdown, nil
+}
+
+// getShutdown returns the state of the started Shutdown, nil if not started or failed
+func (s *Sharding) getShutdown() *shutdownState {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	return s.shutdown
+}
+
+// isShutdownStarted is used for creating the node znode of a new session with the leaving mark
+func (s *Sharding) isShutdownStarted() bool {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	return s.shutdownStarted
+}
+
+func (s *Sharding) isShutdownTimedOut(state *shutdownState) bool {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	return state.timedOut
+}
+
+// isShutdownFinished is used by timers for stopping after the current node has left
+func (s *Sharding) isShutdownFinished() bool {
+	s.sessMut.Lock()
+	defer s.sessMut.Unlock()
+	return s.shutdownFinished
+}
+
+// waitAssignEmpty watches an assign znode of the current node until it has no shards and replicas
+func (s *Sharding) waitAssignEmpty(sess *curator.Session, pathVal string) {
+	if s.shutdown.finished || s.shutdown.assignEmpty {
+		return
+	}
+
+	sess.GetClient().GetW(pathVal, func(resp zk.GetResponse, err error) {
+		s.handleAssignForShutdown(sess, pathVal, resp, err)
+	}, func(ev zk.Event) {
+		if ev.Type == zk.EventNodeDataChanged || ev.Type == zk.EventNodeDeleted {
+			s.waitAssignEmpty(sess, pathVal)
+		}
+	})
+}
+
+func (s *Sharding) handleAssignForShutdown(sess *curator.Session, pathVal string, resp zk.GetResponse, err error) {
+	retry := func(sess *curator.Session) {
+		s.waitAssignEmpty(sess, pathVal)
+	}
+
+	if err != nil {
+		if errors.Is(err, zk.ErrConnectionClosed) {
+			sess.AddRetry(retry)
+			return
+		}
+		if errors.Is(err, zk.ErrNoNode) {
+			s.onAssignEmpty(sess, pathVal)
+			return
+		}
+		s.handleError(sess, OpGet, pathVal, err, retry, false)
+		return
+	}
+
+	var assign assignData
+	if err := json.Unmarshal(resp.Data, &assign); err != nil {
+		// a skipped assign znode is treated as not existed
+		if !s.handleError(sess, OpUnmarshal, pathVal, err, retry, true) {
+			return
+		}
+		assign = assignData{}
+	}
+	if len(assign.Shards) == 0 && len(assign.Replicas) == 0 {
+		s.onAssignEmpty(sess, pathVal)
+		return
+	}
+	// shards are assigned again, e.g. when every other node is also leaving
+	delete(s.shutdown.emptyAssigns, pathVal)
+}
+
+// onAssignEmpty waits for the workers of the shard handlers after all assign znodes of the current node are empty
+func (s *Sharding) onAssignEmpty(sess *curator.Session, pathVal string) {
+	if s.shutdown.assignEmpty {
+		return
+	}
+	s.shutdown.emptyAssigns[pathVal] = struct{}{}
+	if len(s.shutdown.emptyAssigns) < len(s.getShutdownAssignPaths()) {
+		return
+	}
+	s.shutdown.assignEmpty = true
+
+	var runners []*shardRunner
+	for _, o := range s.getOwners() {
+		if o.runner != nil {
+			runners = append(runners, o.runner)
+		}
+	}
+	if len(runners) == 0 {
+		s.finishShutdown(sess, nil)
+		return
+	}
+
+	state := s.shutdown
+	go func() {
+		for _, r := range runners {
+			r.waitAllStopped()
+		}
+		s.afterFunc(0, func() {
+			runOnSession(sess, s.parentPath, func(sess *curator.Session) {
+				if s.sessionSeq != state.seq {
+					// waited again in the new session
+					return
+				}
+				s.finishShutdown(sess, nil)
+			})
+		})
+	}()
+}
+
+// finishShutdown releases the shards, stops the leader logic, and deletes the znodes of the current node
+func (s *Sharding) finishShutdown(sess *curator.Session, shutdownErr error) {
+	if s.shutdown.finished {
+		return
+	}
+	s.shutdown.finished = true
+
+	s.sessMut.Lock()
+	s.shutdownFinished = true
+	s.sessMut.Unlock()
+
+	s.leaderStopped = true
+	for _, g := range s.groups {
+		g.leaderStopped = true
+	}
+	if s.lease != nil {
+		s.lease.stop()
+	}
+	for _, o := range s.getOwners() {
+		o.suspend()
+	}
+
+	callback := s.shutdown.callback
+	counter := newCallbackCounter(func() {
+		callback(shutdownErr)
+	})
+	onDeleted := func(done func()) func(err error) {
+		return func(err error) {
+			if err != nil && shutdownErr == nil {
+				shutdownErr = err
+			}
+			done()
+		}
+	}
+
+	done := counter.begin()
+	client := sess.GetClient()
+	if !s.coordinator {
+		deleteIfExists(client, s.getNodesPath()+"/"+s.nodeID, onDeleted(counter.begin()))
+	}
+	s.deleteLockNodes(client, onDeleted(counter.begin()))
+	done()
+}
+
+// deleteLockNodes deletes the leader lock znodes of the current node, named as node:<nodeID>-<sequence>
+func (s *Sharding) deleteLockNodes(client curator.Client, callback func(err error)) {
+	client.Children(s.getLockPath(), func(resp zk.ChildrenResponse, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+
+		var firstErr error
+		counter := newCallbackCounter(func() {
+			callback(firstErr)
+		})
+		done := counter.begin()
+		for _, child := range resp.Children {
+			if !isLockNodeOf(child, s.nodeID) {
+				continue
+			}
+			deleteDone := counter.begin()
+			deleteIfExists(client, s.getLockPath()+"/"+child, func(err error) {
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				deleteDone()
+			})
+		}
+		done()
+	})
+}
+
+// lockSequenceLen is the length of the sequence number appended by zookeeper to a sequential znode
+const lockSequenceLen = 10
+
+// isLockNodeOf returns true if the lock znode is named as node:<nodeID>-<sequence>,
+// the sequence must have exactly 10 digits, so the node id "web" does NOT match the lock znodes of "web-1"
+func isLockNodeOf(name string, nodeID string) bool {
+	seq, ok := strings.CutPrefix(name, "node:"+nodeID+"-")
+	if !ok || len(seq) != lockSequenceLen {
+		return false
+	}
+	for _, ch := range seq {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
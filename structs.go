@@ -2,19 +2,65 @@ package sharding
 
 import (
 	"encoding/json"
+	"time"
 )
 
 const (
 	lockZNodeName   = "/locks"
 	nodeZNodeName   = "/nodes"
 	assignZNodeName = "/assigns"
+	statusZNodeName = "/status"
+
+	overridesZNodeName = "/overrides"
+	drainsZNodeName    = "/drains"
+	configZNodeName    = "/config"
+	loadsZNodeName     = "/loads"
+	groupsZNodeName    = "/groups"
 )
 
 // ShardID for shard if from zero
 type ShardID uint32
 
+// Topology is the location of a node, used for spreading shards and replicas between failure domains.
+// Empty fields are unknown.
+type Topology struct {
+	Zone string `json:"zone,omitempty"`
+	Rack string `json:"rack,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+// ShardLoad is the load of a shard, reported by the node serving it with WithLoadReporting.
+// The meaning and the units of the fields are decided by the application.
+type ShardLoad struct {
+	QPS   float64 `json:"qps,omitempty"`
+	CPU   float64 `json:"cpu,omitempty"`
+	Bytes uint64  `json:"bytes,omitempty"`
+}
+
 type nodeData struct {
-	Address string `json:"address"`
+	Address  string    `json:"address"`
+	Weight   uint32    `json:"weight,omitempty"`
+	Topology *Topology `json:"topology,omitempty"`
+
+	// Session is a random token of the zookeeper session that created the znode, only with WithDuplicateNodePolicy
+	Session string `json:"session,omitempty"`
+
+	// Leaving is true after Shutdown is called, the leader moves all shards of the node to other nodes
+	Leaving bool `json:"leaving,omitempty"`
+}
+
+func (d nodeData) getTopology() Topology {
+	if d.Topology == nil {
+		return Topology{}
+	}
+	return *d.Topology
+}
+
+func (d nodeData) getWeight() uint32 {
+	if d.Weight == 0 {
+		return 1
+	}
+	return d.Weight
 }
 
 func (d nodeData) marshalJSON() []byte {
@@ -26,5 +72,111 @@ func (d nodeData) marshalJSON() []byte {
 }
 
 type assignData struct {
-	Shards []ShardID `json:"shards"`
+	Shards   []ShardID `json:"shards"`             // primary shards
+	Replicas []ShardID `json:"replicas,omitempty"` // replica shards
+
+	// NumShards is the number of shards that the assignment is computed for, only with WithDynamicNumShards
+	NumShards ShardID `json:"num_shards,omitempty"`
+
+	// Epochs are the fencing epochs of primary shards that were assigned by previous versions of the znode,
+	// the epochs of the remaining primary shards are the mzxid of the current version.
+	// Only with WithFencingEpochs.
+	Epochs map[ShardID]int64 `json:"epochs,omitempty"`
+}
+
+func (d assignData) marshalJSON() []byte {
+	data, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// statusData is the data of the status znode of a node, containing shards that the node is holding
+// and the mzxid of the assign znode that the node has applied
+type statusData struct {
+	Shards     []ShardID `json:"shards"`
+	AssignZxid int64     `json:"assign_zxid,omitempty"`
+	NumShards  ShardID   `json:"num_shards,omitempty"`
+
+	// Inactive are the shards in Shards that are NOT marked active yet, only with WithShardActivation
+	Inactive []ShardID `json:"inactive,omitempty"`
+
+	// Session is a random token of the zookeeper session that created the znode
+	Session string `json:"session,omitempty"`
+}
+
+func (d statusData) marshalJSON() []byte {
+	data, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// overrideData is the data of the overrides znode, managed by PinShard and UnpinShard
+type overrideData struct {
+	// Pins maps a shard to the node that must hold it
+	Pins map[ShardID]string `json:"pins,omitempty"`
+}
+
+func (d overrideData) marshalJSON() []byte {
+	data, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// configData is the data of the config znode, the settings of the cluster that every node must agree on
+type configData struct {
+	NumShards ShardID `json:"num_shards"`
+
+	// zero or nil if unknown, e.g. created by an observer
+	ReplicationFactor int             `json:"replication_factor,omitempty"`
+	TwoPhaseHandoff   *bool           `json:"two_phase_handoff,omitempty"`
+	Settings          *configSettings `json:"settings,omitempty"`
+}
+
+// configSettings are the other settings of the config znode that affect the allocation of the leader.
+// The settings of each node, e.g. its weight and topology, are stored in its node znode instead.
+type configSettings struct {
+	Allocator            string        `json:"allocator"`
+	MaxMovesPerRound     int           `json:"max_moves_per_round"`
+	MinRebalanceInterval time.Duration `json:"min_rebalance_interval"`
+	NodeGracePeriod      time.Duration `json:"node_grace_period"`
+
+	ShardPinning     bool `json:"shard_pinning"`
+	NodeDraining     bool `json:"node_draining"`
+	DynamicNumShards bool `json:"dynamic_num_shards"`
+	ShardStatus      bool `json:"shard_status"`
+	LoadReporting    bool `json:"load_reporting"`
+	FencingEpochs    bool `json:"fencing_epochs"`
+
+	ShardWeights map[ShardID]uint32 `json:"shard_weights,omitempty"`
+	ShardGroups  map[string]ShardID `json:"shard_groups,omitempty"`
+}
+
+func (d configData) marshalJSON() []byte {
+	data, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// loadData is the data of the load znode of a node, only used with WithLoadReporting
+type loadData struct {
+	Shards map[ShardID]ShardLoad `json:"shards"`
+
+	// NumShards is the number of shards known by the node when reporting, only with WithDynamicNumShards
+	NumShards ShardID `json:"num_shards,omitempty"`
+}
+
+func (d loadData) marshalJSON() []byte {
+	data, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+	return data
 }
@@ -2,14 +2,28 @@ package sharding
 
 import (
 	"errors"
-	"log"
 
 	"github.com/QuangTung97/zk"
 	"github.com/QuangTung97/zk/curator"
 )
 
+// runOnSession moves fn from a timer goroutine, or any other goroutine, to the goroutine of the zookeeper client
+// by a cheap zookeeper request on the parent znode. The result of the request is ignored,
+// except that fn is retried by the session if the connection is closed.
+// fn should check itself whether the session or the state it depends on has changed.
+func runOnSession(sess *curator.Session, parent string, fn func(sess *curator.Session)) {
+	sess.GetClient().Children(parent, func(_ zk.ChildrenResponse, err error) {
+		if errors.Is(err, zk.ErrConnectionClosed) {
+			sess.AddRetry(fn)
+			return
+		}
+		fn(sess)
+	})
+}
+
 func sessMustCreateWithData(
-	sess *curator.Session, path string, flags int32, data []byte, callback func(resp zk.CreateResponse),
+	sess *curator.Session, errs *errorHandler,
+	path string, flags int32, data []byte, callback func(resp zk.CreateResponse),
 ) {
 	var loop func(sess *curator.Session)
 	loop = func(sess *curator.Session) {
@@ -22,19 +36,49 @@ func sessMustCreateWithData(
 				sess.AddRetry(loop)
 				return
 			}
-			log.Panicf("Create node with error: %v, path: %s", err, path)
+			errs.handle(sess, &UnexpectedError{Op: OpCreate, Path: path, Err: err}, loop, false)
 		})
 	}
 	loop(sess)
 }
 
 func sessMustCreatePersistence(
-	sess *curator.Session, path string, callback func(resp zk.CreateResponse),
+	sess *curator.Session, errs *errorHandler, path string, callback func(resp zk.CreateResponse),
 ) {
-	sessMustCreateWithData(sess, path, 0, nil, callback)
+	sessMustCreateWithData(sess, errs, path, 0, nil, callback)
+}
+
+// deleteIfExists deletes the znode with its current version, it is NOT an error if the znode does not exist
+func deleteIfExists(client curator.Client, pathVal string, callback func(err error)) {
+	var loop func()
+	loop = func() {
+		client.Get(pathVal, func(resp zk.GetResponse, err error) {
+			if err != nil {
+				if errors.Is(err, zk.ErrNoNode) {
+					err = nil
+				}
+				callback(err)
+				return
+			}
+
+			client.Delete(pathVal, resp.Stat.Version, func(resp zk.DeleteResponse, err error) {
+				if errors.Is(err, zk.ErrBadVersion) {
+					loop()
+					return
+				}
+				if errors.Is(err, zk.ErrNoNode) {
+					err = nil
+				}
+				callback(err)
+			})
+		})
+	}
+	loop()
 }
 
-func sessMustChildren(sess *curator.Session, path string, callback func(resp zk.ChildrenResponse)) {
+func sessMustChildren(
+	sess *curator.Session, errs *errorHandler, path string, callback func(resp zk.ChildrenResponse),
+) {
 	var loop func(sess *curator.Session)
 	loop = func(sess *curator.Session) {
 		sess.GetClient().Children(path, func(resp zk.ChildrenResponse, err error) {
@@ -43,7 +87,8 @@ func sessMustChildren(sess *curator.Session, path string, callback func(resp zk.
 					sess.AddRetry(loop)
 					return
 				}
-				panic(err)
+				errs.handle(sess, &UnexpectedError{Op: OpChildren, Path: path, Err: err}, loop, false)
+				return
 			}
 			callback(resp)
 		})
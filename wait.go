@@ -0,0 +1,128 @@
+package sharding
+
+import (
+	"context"
+	"time"
+)
+
+// WaitReady blocks until the first event with all shards assigned has been notified by the observer,
+// or ctx is done. Returns ErrNoObserver if the current node is not configured with WithShardingObserver.
+// Only the default shard group is waited.
+func (s *Sharding) WaitReady(ctx context.Context) error {
+	if s.obs == nil {
+		return ErrNoObserver
+	}
+	return s.obs.waitReady(ctx)
+}
+
+// WaitStable blocks until all shards are assigned, none of them is pending (see Node.PendingShards),
+// and the assignments have not changed for the duration d, or ctx is done.
+// Returns ErrNoObserver if the current node is not configured with WithShardingObserver.
+// Only the default shard group is waited.
+func (s *Sharding) WaitStable(ctx context.Context, d time.Duration) error {
+	if s.obs == nil {
+		return ErrNoObserver
+	}
+	return s.obs.waitStable(ctx, d)
+}
+
+// WaitReady blocks until the first event with all shards assigned has been notified, or ctx is done.
+// Only the default shard group is waited.
+func (o *Observer) WaitReady(ctx context.Context) error {
+	return o.core.waitReady(ctx)
+}
+
+// WaitStable blocks until all shards are assigned, none of them is pending (see Node.PendingShards),
+// and the assignments have not changed for the duration d, or ctx is done. Only the default shard group is waited.
+func (o *Observer) WaitStable(ctx context.Context, d time.Duration) error {
+	return o.core.waitStable(ctx, d)
+}
+
+func (c *observerCore) getReadyState() (ready bool, changed <-chan struct{}) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.ready, c.changed
+}
+
+func (c *observerCore) waitReady(ctx context.Context) error {
+	for {
+		ready, changed := c.getReadyState()
+		if ready {
+			return nil
+		}
+
+		if err := c.waitChanged(ctx, changed, false, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// setSettled is called on every computed assignment, the duration of WaitStable restarts when settled changes
+func (c *observerCore) setSettled(settled bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.settled == settled {
+		return
+	}
+	c.settled = settled
+	c.lastChange = c.now()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func hasPendingShards(nodes []Node) bool {
+	for _, n := range nodes {
+		if len(n.PendingShards) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// getStableState returns the remaining duration until the assignments have not changed for the duration d,
+// complete is false if not all shards are assigned, or some shards are not granted or still pending
+func (c *observerCore) getStableState(d time.Duration) (
+	complete bool, remaining time.Duration, changed <-chan struct{},
+) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if len(c.notified) == 0 || !c.settled {
+		return false, 0, c.changed
+	}
+	return true, d - c.now().Sub(c.lastChange), c.changed
+}
+
+func (c *observerCore) waitStable(ctx context.Context, d time.Duration) error {
+	for {
+		complete, remaining, changed := c.getStableState(d)
+		if complete && remaining <= 0 {
+			return nil
+		}
+		if err := c.waitChanged(ctx, changed, complete, remaining); err != nil {
+			return err
+		}
+	}
+}
+
+// waitChanged waits until the next event, or the timeout of afterFunc if hasTimeout is true
+func (c *observerCore) waitChanged(
+	ctx context.Context, changed <-chan struct{}, hasTimeout bool, timeout time.Duration,
+) error {
+	var timerCh chan struct{}
+	if hasTimeout {
+		timerCh = make(chan struct{})
+		c.afterFunc(timeout, func() {
+			close(timerCh)
+		})
+	}
+
+	select {
+	case <-changed:
+	case <-timerCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}